@@ -0,0 +1,114 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acstest
+
+import (
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// ErrorInfoDomain is the domain of the ErrorInfo details created by AckStatus.
+const ErrorInfoDomain = "agentcommunication.googleapis.com"
+
+// Faults is a declarative fault script for a Server, see Server.SetFaults. Register attempts and
+// client messages are counted from 1, across all streams, starting when the script is set. The
+// zero value injects no faults.
+type Faults struct {
+	// RegisterErrors fails the register attempts with the given numbers: the stream handler returns
+	// the error instead of acknowledging RegisterConnection, for example
+	// status.Error(codes.ResourceExhausted, "") or status.Error(codes.Unavailable, "").
+	RegisterErrors map[int]error
+	// WithheldAcks lists the client messages that are never acknowledged.
+	WithheldAcks map[int]bool
+	// AckStatuses replies to the given client messages with a MessageResponse carrying the status
+	// instead of OK, see AckStatus.
+	AckStatuses map[int]*spb.Status
+	// CancelAfterMessages ends each stream with StreamError once it received this many client
+	// messages, after acknowledging the last one. Zero disables it.
+	CancelAfterMessages int
+	// CancelAfter ends each stream with StreamError once it has been open for this long. Zero
+	// disables it.
+	CancelAfter time.Duration
+	// StreamError is the error streams are ended with by CancelAfterMessages and CancelAfter,
+	// Canceled (a normal disconnect that the client reconnects after) if nil.
+	StreamError error
+	// RecvDelay delays every response written to a stream after registration: acks, statuses and
+	// pushed messages. It delays the client's Recv.
+	RecvDelay time.Duration
+}
+
+// AckStatus returns a status with code and message carrying an ErrorInfo with reason, for use in
+// Faults.AckStatuses. The service uses the StreamAgentMessagesResponse_ErrorReason names as
+// reasons, for example "AGENT_MESSAGE_RATE_QUOTA_EXCEEDED" with ResourceExhausted.
+func AckStatus(code codes.Code, reason, message string) *spb.Status {
+	st, err := status.New(code, message).WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorInfoDomain})
+	if err != nil {
+		// Only fails for an OK code.
+		return status.New(code, message).Proto()
+	}
+	return st.Proto()
+}
+
+// SetFaults replaces the fault script of the server and resets its register attempt and client
+// message counters. Streams that are already connected pick up the new script, except for
+// CancelAfter which applies to new streams.
+func (s *Server) SetFaults(f Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = f
+	s.registers = 0
+	s.messages = 0
+}
+
+// registerFault counts a register attempt and returns the error it should fail with, if any.
+func (s *Server) registerFault() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registers++
+	return s.faults.RegisterErrors[s.registers]
+}
+
+// messageFault counts a client message and returns the response to send for it, nil if the ack is
+// withheld.
+func (s *Server) messageFault(messageID string) *acpb.StreamAgentMessagesResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages++
+	if s.faults.WithheldAcks[s.messages] {
+		return nil
+	}
+	resp := ackResponse(messageID)
+	if st, ok := s.faults.AckStatuses[s.messages]; ok {
+		resp.GetMessageResponse().Status = st
+	}
+	return resp
+}
+
+// streamFaults returns the stream level faults of the current script.
+func (s *Server) streamFaults() (cancelAfterMessages int, cancelAfter time.Duration, streamErr error, recvDelay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	streamErr = s.faults.StreamError
+	if streamErr == nil {
+		streamErr = status.Error(codes.Canceled, "acstest: stream canceled by fault script")
+	}
+	return s.faults.CancelAfterMessages, s.faults.CancelAfter, streamErr, s.faults.RecvDelay
+}
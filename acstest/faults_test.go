@@ -0,0 +1,214 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acstest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	client "github.com/GoogleCloudPlatform/agentcommunication_client"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+var fastReconnect = client.WithReconnectBackoff(client.ConstantBackoff{Delay: time.Millisecond})
+
+func testMessage() *acpb.MessageBody {
+	return &acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}}
+}
+
+// waitForState returns the first event transitioning to state.
+func waitForState(t *testing.T, events <-chan client.StateEvent, state client.ConnectionState) client.StateEvent {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed waiting for %v", state)
+			}
+			if ev.To == state && ev.From != state {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", state)
+		}
+	}
+}
+
+func TestFaults_RegisterErrors(t *testing.T) {
+	ctx := testContext(t)
+	srv := NewServer()
+	defer srv.Close()
+	c, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c.Close()
+
+	srv.SetFaults(Faults{RegisterErrors: map[int]error{
+		1: status.Error(codes.ResourceExhausted, "too many connections"),
+		2: status.Error(codes.Unavailable, "unavailable"),
+	}})
+	conn, err := client.NewConnection(ctx, testChannelID, c, fastReconnect)
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+	conn.Close()
+
+	srv.SetFaults(Faults{RegisterErrors: map[int]error{
+		1: status.Error(codes.Unavailable, "unavailable"),
+		2: status.Error(codes.Unavailable, "unavailable"),
+		3: status.Error(codes.Unavailable, "unavailable"),
+	}})
+	_, err = client.NewConnection(ctx, testChannelID, c, fastReconnect, client.WithMaxUnavailableRetries(1))
+	var retryErr *client.RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("NewConnection() = %v, want *client.RetryError", err)
+	}
+	for _, a := range retryErr.Attempts {
+		if a.Code != codes.Unavailable {
+			t.Errorf("attempt code = %v, want Unavailable", a.Code)
+		}
+	}
+}
+
+func TestFaults_Acks(t *testing.T) {
+	ctx := testContext(t)
+	srv, _, conn := Connect(t, testChannelID)
+	srv.SetFaults(Faults{
+		AckStatuses:  map[int]*spb.Status{1: AckStatus(codes.ResourceExhausted, "AGENT_MESSAGE_RATE_QUOTA_EXCEEDED", "slow down")},
+		WithheldAcks: map[int]bool{2: true},
+	})
+
+	err := conn.SendMessageContext(ctx, testMessage(), client.WithSendRetries(0))
+	if !errors.Is(err, client.ErrResourceExhausted) {
+		t.Errorf("SendMessageContext() = %v, want ErrResourceExhausted", err)
+	}
+	err = conn.SendMessageContext(ctx, testMessage(), client.WithSendRetries(0), client.WithSendAckTimeout(50*time.Millisecond))
+	if !errors.Is(err, client.ErrMessageTimeout) {
+		t.Errorf("SendMessageContext() = %v, want ErrMessageTimeout", err)
+	}
+	if err := conn.SendMessageContext(ctx, testMessage()); err != nil {
+		t.Errorf("SendMessageContext() = %v, want nil", err)
+	}
+	if sent := srv.Sent(); len(sent) != 3 {
+		t.Errorf("Sent() = %d messages, want 3", len(sent))
+	}
+}
+
+func TestAckStatus(t *testing.T) {
+	st := status.FromProto(AckStatus(codes.ResourceExhausted, "AGENT_MESSAGE_RATE_QUOTA_EXCEEDED", "slow down"))
+	if st.Code() != codes.ResourceExhausted || st.Message() != "slow down" {
+		t.Errorf("AckStatus() = %v, want ResourceExhausted: slow down", st)
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("AckStatus() details = %v, want one ErrorInfo", st.Details())
+	}
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	if !ok || info.GetReason() != "AGENT_MESSAGE_RATE_QUOTA_EXCEEDED" || info.GetDomain() != ErrorInfoDomain {
+		t.Errorf("AckStatus() details = %v, want ErrorInfo with reason AGENT_MESSAGE_RATE_QUOTA_EXCEEDED", st.Details())
+	}
+}
+
+func TestFaults_CancelAfterMessages(t *testing.T) {
+	ctx := testContext(t)
+	srv, _, conn := Connect(t, testChannelID, fastReconnect)
+	srv.SetFaults(Faults{CancelAfterMessages: 1})
+	events, stop := conn.Subscribe()
+	defer stop()
+
+	if err := conn.SendMessageContext(ctx, testMessage()); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	if ev := waitForState(t, events, client.StateReconnecting); ev.Reason != codes.Canceled.String() {
+		t.Errorf("reconnect reason = %q, want %q", ev.Reason, codes.Canceled)
+	}
+	waitForState(t, events, client.StateReady)
+}
+
+func TestFaults_CancelAfter(t *testing.T) {
+	srv, _, conn := Connect(t, testChannelID, fastReconnect)
+	events, stop := conn.Subscribe()
+	defer stop()
+	srv.SetFaults(Faults{CancelAfter: 50 * time.Millisecond, StreamError: status.Error(codes.Unavailable, "going away")})
+	// Only new streams pick up CancelAfter.
+	srv.Disconnect(testChannelID, nil)
+	waitForState(t, events, client.StateReady)
+
+	if ev := waitForState(t, events, client.StateReconnecting); ev.Reason != codes.Unavailable.String() {
+		t.Errorf("reconnect reason = %q, want %q", ev.Reason, codes.Unavailable)
+	}
+	srv.SetFaults(Faults{})
+}
+
+func TestFaults_RecvDelay(t *testing.T) {
+	ctx := testContext(t)
+	srv, _, conn := Connect(t, testChannelID)
+	const delay = 100 * time.Millisecond
+	srv.SetFaults(Faults{RecvDelay: delay})
+
+	start := time.Now()
+	if err := conn.SendMessageContext(ctx, testMessage()); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	if got := time.Since(start); got < delay {
+		t.Errorf("SendMessageContext() took %v, want at least %v", got, delay)
+	}
+
+	id, err := srv.Push(ctx, testChannelID, testMessage())
+	if err != nil {
+		t.Fatalf("Push() failed: %v", err)
+	}
+	shortCtx, cancel := context.WithTimeout(ctx, delay/2)
+	defer cancel()
+	if _, err := conn.ReceiveContext(shortCtx); err == nil {
+		t.Errorf("ReceiveContext() returned before RecvDelay")
+	}
+	if _, err := conn.ReceiveContext(ctx); err != nil {
+		t.Errorf("ReceiveContext() failed: %v", err)
+	}
+	if _, err := srv.WaitForAck(ctx, id); err != nil {
+		t.Errorf("WaitForAck() failed: %v", err)
+	}
+}
+
+func TestFaults_RecvDelayDisconnect(t *testing.T) {
+	ctx := testContext(t)
+	srv, _, conn := Connect(t, testChannelID)
+	events, stop := conn.Subscribe()
+	defer stop()
+	srv.SetFaults(Faults{RecvDelay: time.Hour})
+	defer srv.SetFaults(Faults{})
+
+	sendCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go conn.SendMessageContext(sendCtx, testMessage())
+	if _, err := srv.WaitForSent(ctx, 1); err != nil {
+		t.Fatalf("WaitForSent() failed: %v", err)
+	}
+	// The ack is held back, the disconnect must still end the stream right away.
+	srv.Disconnect(testChannelID, status.Error(codes.Unavailable, "going away"))
+	if ev := waitForState(t, events, client.StateReconnecting); ev.Reason != codes.Unavailable.String() {
+		t.Errorf("reconnect reason = %q, want %q", ev.Reason, codes.Unavailable)
+	}
+}
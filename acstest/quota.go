@@ -0,0 +1,80 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acstest
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// streamQuota enforces the message rate and bandwidth limits advertised to a stream, with a burst
+// of one minute's worth of quota. A nil *streamQuota allows everything.
+type streamQuota struct {
+	messages *rate.Limiter
+	bytes    *rate.Limiter
+}
+
+func perMinuteLimiter(perMinute int) *rate.Limiter {
+	if perMinute <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)
+}
+
+func newStreamQuota(messagesPerMinute, bytesPerMinute int) *streamQuota {
+	return &streamQuota{
+		messages: perMinuteLimiter(messagesPerMinute),
+		bytes:    perMinuteLimiter(bytesPerMinute),
+	}
+}
+
+// check consumes quota for a message of size bytes, it returns the ResourceExhausted response to
+// send if the message is over quota and nil otherwise. A message larger than the bandwidth burst
+// is let through once the bucket is full, as the client does.
+func (q *streamQuota) check(messageID string, size int) *acpb.StreamAgentMessagesResponse {
+	if q == nil {
+		return nil
+	}
+	if !q.messages.Allow() {
+		return quotaExceeded(messageID, acpb.StreamAgentMessagesResponse_AGENT_MESSAGE_RATE_QUOTA_EXCEEDED)
+	}
+	if burst := q.bytes.Burst(); q.bytes.Limit() != rate.Inf && size > burst {
+		size = burst
+	}
+	if !q.bytes.AllowN(time.Now(), size) {
+		return quotaExceeded(messageID, acpb.StreamAgentMessagesResponse_AGENT_BANDWIDTH_RATE_QUOTA_EXCEEDED)
+	}
+	return nil
+}
+
+func quotaExceeded(messageID string, reason acpb.StreamAgentMessagesResponse_ErrorReason) *acpb.StreamAgentMessagesResponse {
+	resp := ackResponse(messageID)
+	resp.GetMessageResponse().Status = AckStatus(codes.ResourceExhausted, reason.String(), "quota exceeded")
+	return resp
+}
+
+// EnforceRateLimits makes new streams reject client messages over the limits set with
+// SetRateLimits, acknowledging them with ResourceExhausted and an ErrorInfo reason of
+// AGENT_MESSAGE_RATE_QUOTA_EXCEEDED or AGENT_BANDWIDTH_RATE_QUOTA_EXCEEDED as the service does.
+// Rejected messages are not recorded as sent. Limits are only advertised by default.
+func (s *Server) EnforceRateLimits(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enforceRateLimits = enabled
+}
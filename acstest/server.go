@@ -0,0 +1,607 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package acstest provides an in-process fake AgentCommunication server for testing code built on
+// the client package.
+//
+// A typical test connects to a fake server, pushes messages to the agent and inspects what the
+// agent sent back:
+//
+//	srv, _, conn := acstest.Connect(t, "my-channel")
+//	if _, err := srv.Push(ctx, "my-channel", msg); err != nil {
+//		t.Fatal(err)
+//	}
+//	...
+//	sent, err := srv.WaitForSent(ctx, 1)
+package acstest
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/GoogleCloudPlatform/agentcommunication_client/gapic"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	// ResourceID is the resource ID reported by the fake metadata, see Metadata.
+	ResourceID = "projects/test-project/zones/test-zone/instances/test-instance"
+	// Zone is the zone reported by the fake metadata, see Metadata.
+	Zone = "test-zone"
+
+	metadataMessageRateLimit = "agent-communication-message-rate-limit"
+	metadataBandwidthLimit   = "agent-communication-bandwidth-limit"
+
+	bufSize = 1024 * 1024
+)
+
+// An unsigned identity token expiring in 2100, the client only reads the expiry.
+var fakeIDToken = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+	base64.RawURLEncoding.EncodeToString([]byte(`{"exp":4102444800}`)) + "."
+
+var installMetadata sync.Once
+
+// Metadata is a client.MetadataInitFunc reporting Zone and ResourceID with a fake identity token.
+// NewClient installs it as client.MetadataInitFunc. Because the client initializes metadata once
+// per process, it must be installed before any other client is created to take effect.
+func Metadata() (*client.MetadataInitData, error) {
+	return &client.MetadataInitData{
+		Zone:           Zone,
+		ResourceID:     ResourceID,
+		UniverseDomain: "googleapis.com",
+		TokenGetter:    func() (string, error) { return fakeIDToken, nil },
+	}, nil
+}
+
+// StreamKey identifies a stream by the resource and channel it was registered for.
+type StreamKey struct {
+	ResourceID string
+	ChannelID  string
+}
+
+// Message is a message sent by a client on a stream.
+type Message struct {
+	StreamKey
+	MessageID string
+	Body      *acpb.MessageBody
+}
+
+// SendAgentMessageHandler handles SendAgentMessage requests, see Server.HandleSendAgentMessage.
+type SendAgentMessageHandler func(ctx context.Context, req *acpb.SendAgentMessageRequest) (*acpb.SendAgentMessageResponse, error)
+
+// stream is a connected StreamAgentMessages call.
+type stream struct {
+	key StreamKey
+	// Responses to write to the stream, all writes go through the stream handler.
+	sends chan *acpb.StreamAgentMessagesResponse
+	// Closed once the stream handler returned.
+	finished <-chan struct{}
+	// Closed by Disconnect, the stream handler then returns disconnectErr.
+	disconnect    chan struct{}
+	disconnectErr error
+	// Enforces the advertised limits, nil if they are not enforced.
+	quota *streamQuota
+	// Registration order of the stream, higher is more recent.
+	registered int
+}
+
+// Server is a fake AgentCommunication server. Streams are tracked by resource and channel, a
+// newer stream for the same resource and channel replaces the older one, as in the real service.
+// All methods are safe for concurrent use.
+type Server struct {
+	acpb.UnimplementedAgentCommunicationServer
+
+	lis        *bufconn.Listener
+	grpcServer *grpc.Server
+
+	mu      sync.Mutex
+	streams map[StreamKey]*stream
+	sent    []*Message
+	// Messages dropped from the front of sent by the history limit.
+	forgotten int
+	// Statuses of the MessageResponses sent by clients for pushed messages, keyed by message ID,
+	// and the message IDs in the order they were acknowledged.
+	acks     map[string]*status.Status
+	ackOrder []string
+	// Caps sent and acks, 0 for no limit, see SetHistoryLimit.
+	historyLimit int
+	// Closed and replaced whenever the state above changes.
+	changed chan struct{}
+	nextID  int
+	// Registration counter of streams, see stream.registered.
+	registrations int
+
+	messageRateLimit  int
+	bandwidthLimit    int
+	enforceRateLimits bool
+	sendAgentMessage  SendAgentMessageHandler
+
+	faults Faults
+	// Register attempts and client messages since the fault script was set.
+	registers int
+	messages  int
+}
+
+// NewServer starts a Server listening on an in-memory connection, see Dial and NewClient. Callers
+// must call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		lis:              bufconn.Listen(bufSize),
+		grpcServer:       grpc.NewServer(),
+		streams:          make(map[StreamKey]*stream),
+		acks:             make(map[string]*status.Status),
+		changed:          make(chan struct{}),
+		sendAgentMessage: echoSendAgentMessage,
+	}
+	acpb.RegisterAgentCommunicationServer(s.grpcServer, s)
+	go s.grpcServer.Serve(s.lis)
+	return s
+}
+
+// Close stops the server, ending all streams.
+func (s *Server) Close() {
+	s.grpcServer.Stop()
+	s.lis.Close()
+}
+
+// Dial returns a gRPC connection to the server.
+func (s *Server) Dial() (*grpc.ClientConn, error) {
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return s.lis.DialContext(ctx)
+	}
+	return grpc.NewClient("passthrough:///acstest", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+// NewClient returns a client connected to the server, it installs Metadata as
+// client.MetadataInitFunc. Callers must close the returned client when done.
+func (s *Server) NewClient(ctx context.Context, opts ...option.ClientOption) (*agentcommunication.Client, error) {
+	installMetadata.Do(func() { client.MetadataInitFunc = Metadata })
+	cc, err := s.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return client.NewClient(ctx, false, append([]option.ClientOption{option.WithGRPCConn(cc)}, opts...)...)
+}
+
+// Connect starts a Server and returns it along with a client and a ready Connection for channelID
+// wired to it. The server, client and connection are closed when the test ends.
+func Connect(t testing.TB, channelID string, opts ...client.ConnectionOption) (*Server, *agentcommunication.Client, *client.Connection) {
+	t.Helper()
+	ctx := context.Background()
+	s := NewServer()
+	t.Cleanup(s.Close)
+	c, err := s.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("acstest: NewClient() failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	conn, err := client.NewConnection(ctx, channelID, c, opts...)
+	if err != nil {
+		t.Fatalf("acstest: NewConnection(%q) failed: %v", channelID, err)
+	}
+	t.Cleanup(conn.Close)
+	return s, c, conn
+}
+
+// SetRateLimits sets the message rate (in messages/minute) and bandwidth (in bytes/minute) limits
+// advertised in the headers of new streams. Zero omits the header, which is the default.
+func (s *Server) SetRateLimits(messagesPerMinute, bytesPerMinute int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messageRateLimit = messagesPerMinute
+	s.bandwidthLimit = bytesPerMinute
+}
+
+// Serve accepts connections on lis in addition to the in-memory listener, until the server is
+// closed. It is used to expose the fake server over TCP or a unix socket.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpcServer.Serve(lis)
+}
+
+// HandleSendAgentMessage sets the handler for SendAgentMessage requests. By default the request's
+// message body is echoed back.
+func (s *Server) HandleSendAgentMessage(h SendAgentMessageHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendAgentMessage = h
+}
+
+func echoSendAgentMessage(_ context.Context, req *acpb.SendAgentMessageRequest) (*acpb.SendAgentMessageResponse, error) {
+	return &acpb.SendAgentMessageResponse{MessageBody: req.GetMessageBody()}, nil
+}
+
+// notifyLocked wakes up all waiters, s.mu must be held.
+func (s *Server) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// wait blocks until cond, called with s.mu held, returns true or ctx is done.
+func (s *Server) wait(ctx context.Context, cond func() bool) error {
+	for {
+		s.mu.Lock()
+		ok := cond()
+		changed := s.changed
+		s.mu.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// streamForChannelLocked returns the most recent stream registered for channelID, s.mu must be
+// held.
+func (s *Server) streamForChannelLocked(channelID string) *stream {
+	var newest *stream
+	for key, st := range s.streams {
+		if key.ChannelID == channelID && (newest == nil || st.registered > newest.registered) {
+			newest = st
+		}
+	}
+	return newest
+}
+
+// Streams returns the currently connected streams.
+func (s *Server) Streams() []StreamKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]StreamKey, 0, len(s.streams))
+	for key := range s.streams {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// WaitForStream blocks until a stream is connected for channelID and returns its key.
+func (s *Server) WaitForStream(ctx context.Context, channelID string) (StreamKey, error) {
+	var st *stream
+	if err := s.wait(ctx, func() bool {
+		st = s.streamForChannelLocked(channelID)
+		return st != nil
+	}); err != nil {
+		return StreamKey{}, err
+	}
+	return st.key, nil
+}
+
+// Push sends msg to the client connected on channelID, waiting for a stream to connect if there is
+// none, and returns the message ID. Use WaitForAck to wait for the client to acknowledge it.
+func (s *Server) Push(ctx context.Context, channelID string, msg *acpb.MessageBody) (string, error) {
+	return s.push(ctx, msg, func() *stream { return s.streamForChannelLocked(channelID) })
+}
+
+// PushTo is like Push but sends msg to the stream registered for key, for servers with several
+// resources connected on the same channel.
+func (s *Server) PushTo(ctx context.Context, key StreamKey, msg *acpb.MessageBody) (string, error) {
+	return s.push(ctx, msg, func() *stream { return s.streams[key] })
+}
+
+// push sends msg on the stream returned by find, called with s.mu held.
+func (s *Server) push(ctx context.Context, msg *acpb.MessageBody, find func() *stream) (string, error) {
+	s.mu.Lock()
+	s.nextID++
+	id := fmt.Sprintf("acstest-%d", s.nextID)
+	s.mu.Unlock()
+	resp := &acpb.StreamAgentMessagesResponse{
+		MessageId: id,
+		Type:      &acpb.StreamAgentMessagesResponse_MessageBody{MessageBody: msg},
+	}
+	for {
+		var st *stream
+		if err := s.wait(ctx, func() bool {
+			st = find()
+			return st != nil
+		}); err != nil {
+			return "", err
+		}
+		select {
+		case st.sends <- resp:
+			return id, nil
+		case <-st.finished:
+			// The stream ended, wait for the client to reconnect.
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// Acked reports whether the client acknowledged the pushed message with the given ID.
+func (s *Server) Acked(messageID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.acks[messageID]
+	return ok
+}
+
+// WaitForAck blocks until the client acknowledges the pushed message with the given ID and returns
+// the status of the acknowledgement.
+func (s *Server) WaitForAck(ctx context.Context, messageID string) (*status.Status, error) {
+	var st *status.Status
+	if err := s.wait(ctx, func() bool {
+		var ok bool
+		st, ok = s.acks[messageID]
+		return ok
+	}); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Sent returns the messages sent by clients so far, in the order they were received, except those
+// forgotten because of the history limit, see SetHistoryLimit.
+func (s *Server) Sent() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Message(nil), s.sent...)
+}
+
+// WaitForSent blocks until clients sent at least n messages and returns them, as Sent. Messages
+// forgotten because of the history limit count towards n.
+func (s *Server) WaitForSent(ctx context.Context, n int) ([]*Message, error) {
+	if err := s.wait(ctx, func() bool { return s.forgotten+len(s.sent) >= n }); err != nil {
+		return nil, err
+	}
+	return s.Sent(), nil
+}
+
+// WaitForMessage blocks until a client sent a message for which match returns true and returns
+// the first such message still in Sent. match is called with the server's lock held and must not
+// call the server.
+func (s *Server) WaitForMessage(ctx context.Context, match func(*Message) bool) (*Message, error) {
+	var found *Message
+	if err := s.wait(ctx, func() bool {
+		for _, m := range s.sent {
+			if match(m) {
+				found = m
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// SetHistoryLimit caps the number of sent messages and acknowledgements the server remembers, the
+// oldest are forgotten first. Zero, the default, remembers everything, which long running servers
+// should avoid.
+func (s *Server) SetHistoryLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.historyLimit = n
+	s.trimHistoryLocked()
+}
+
+// trimHistoryLocked forgets the oldest sent messages and acknowledgements past the history limit.
+func (s *Server) trimHistoryLocked() {
+	if s.historyLimit <= 0 {
+		return
+	}
+	if n := len(s.sent) - s.historyLimit; n > 0 {
+		clear(s.sent[:n])
+		s.sent = s.sent[n:]
+		s.forgotten += n
+	}
+	if n := len(s.ackOrder) - s.historyLimit; n > 0 {
+		for _, id := range s.ackOrder[:n] {
+			delete(s.acks, id)
+		}
+		s.ackOrder = s.ackOrder[n:]
+	}
+}
+
+// Disconnect ends the streams connected on channelID with err, a nil err ends them cleanly (the
+// client sees io.EOF). Clients reconnect as they would with the real service.
+func (s *Server) Disconnect(channelID string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, st := range s.streams {
+		if key.ChannelID != channelID {
+			continue
+		}
+		st.disconnectErr = err
+		close(st.disconnect)
+		delete(s.streams, key)
+	}
+	s.notifyLocked()
+}
+
+func ackResponse(messageID string) *acpb.StreamAgentMessagesResponse {
+	return &acpb.StreamAgentMessagesResponse{
+		MessageId: messageID,
+		Type:      &acpb.StreamAgentMessagesResponse_MessageResponse{MessageResponse: &acpb.MessageResponse{}},
+	}
+}
+
+// StreamAgentMessages implements acpb.AgentCommunicationServer.
+func (s *Server) StreamAgentMessages(srv acpb.AgentCommunication_StreamAgentMessagesServer) error {
+	s.mu.Lock()
+	headers := metadata.MD{}
+	if s.messageRateLimit > 0 {
+		headers.Set(metadataMessageRateLimit, strconv.Itoa(s.messageRateLimit))
+	}
+	if s.bandwidthLimit > 0 {
+		headers.Set(metadataBandwidthLimit, strconv.Itoa(s.bandwidthLimit))
+	}
+	s.mu.Unlock()
+	if err := srv.SetHeader(headers); err != nil {
+		return err
+	}
+
+	// RegisterConnection must be the first message on the stream.
+	req, err := srv.Recv()
+	if err != nil {
+		return err
+	}
+	reg := req.GetRegisterConnection()
+	if reg == nil {
+		return status.Errorf(codes.InvalidArgument, "first message must be RegisterConnection, got %T", req.GetType())
+	}
+	if err := s.registerFault(); err != nil {
+		return err
+	}
+	if err := srv.Send(ackResponse(req.GetMessageId())); err != nil {
+		return err
+	}
+
+	st := &stream{
+		key:        StreamKey{ResourceID: reg.GetResourceId(), ChannelID: reg.GetChannelId()},
+		sends:      make(chan *acpb.StreamAgentMessagesResponse),
+		finished:   srv.Context().Done(),
+		disconnect: make(chan struct{}),
+	}
+	s.mu.Lock()
+	if s.enforceRateLimits {
+		st.quota = newStreamQuota(s.messageRateLimit, s.bandwidthLimit)
+	}
+	s.registrations++
+	st.registered = s.registrations
+	s.streams[st.key] = st
+	s.notifyLocked()
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.streams[st.key] == st {
+			delete(s.streams, st.key)
+		}
+		s.notifyLocked()
+		s.mu.Unlock()
+	}()
+
+	_, cancelAfter, streamErr, _ := s.streamFaults()
+	var cancelTimer <-chan time.Time
+	if cancelAfter > 0 {
+		timer := time.NewTimer(cancelAfter)
+		defer timer.Stop()
+		cancelTimer = timer.C
+	}
+
+	recvErr := make(chan error, 1)
+	go s.recv(srv, st, recvErr)
+	for {
+		select {
+		case resp := <-st.sends:
+			if _, _, _, delay := s.streamFaults(); delay > 0 {
+				// Keep observing the end of the stream while the response is held back.
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-st.finished:
+					timer.Stop()
+					return status.FromContextError(srv.Context().Err()).Err()
+				case <-cancelTimer:
+					timer.Stop()
+					return streamErr
+				case <-st.disconnect:
+					timer.Stop()
+					return st.disconnectErr
+				}
+			}
+			if err := srv.Send(resp); err != nil {
+				return err
+			}
+		case <-cancelTimer:
+			return streamErr
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-st.disconnect:
+			return st.disconnectErr
+		}
+	}
+}
+
+// recv records the messages and acknowledgements sent by the client and acks its messages, as
+// directed by the fault script.
+func (s *Server) recv(srv acpb.AgentCommunication_StreamAgentMessagesServer, st *stream, recvErr chan<- error) {
+	received := 0
+	for {
+		req, err := srv.Recv()
+		if err != nil {
+			recvErr <- err
+			return
+		}
+		switch req.GetType().(type) {
+		case *acpb.StreamAgentMessagesRequest_MessageBody:
+			if resp := st.quota.check(req.GetMessageId(), proto.Size(req.GetMessageBody())); resp != nil {
+				select {
+				case st.sends <- resp:
+					continue
+				case <-st.finished:
+					return
+				}
+			}
+			s.mu.Lock()
+			s.sent = append(s.sent, &Message{StreamKey: st.key, MessageID: req.GetMessageId(), Body: req.GetMessageBody()})
+			s.trimHistoryLocked()
+			s.notifyLocked()
+			s.mu.Unlock()
+			if resp := s.messageFault(req.GetMessageId()); resp != nil {
+				select {
+				case st.sends <- resp:
+				case <-st.finished:
+					return
+				}
+			}
+			received++
+			if cancelAfterMessages, _, streamErr, _ := s.streamFaults(); cancelAfterMessages > 0 && received >= cancelAfterMessages {
+				recvErr <- streamErr
+				return
+			}
+		case *acpb.StreamAgentMessagesRequest_MessageResponse:
+			s.mu.Lock()
+			if _, ok := s.acks[req.GetMessageId()]; !ok {
+				s.ackOrder = append(s.ackOrder, req.GetMessageId())
+			}
+			s.acks[req.GetMessageId()] = status.FromProto(req.GetMessageResponse().GetStatus())
+			s.trimHistoryLocked()
+			s.notifyLocked()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// SendAgentMessage implements acpb.AgentCommunicationServer.
+func (s *Server) SendAgentMessage(ctx context.Context, req *acpb.SendAgentMessageRequest) (*acpb.SendAgentMessageResponse, error) {
+	s.mu.Lock()
+	h := s.sendAgentMessage
+	s.mu.Unlock()
+	return h(ctx, req)
+}
@@ -0,0 +1,233 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acstest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	client "github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const testChannelID = "test-channel"
+
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestPushAndReceive(t *testing.T) {
+	ctx := testContext(t)
+	srv, _, conn := Connect(t, testChannelID)
+
+	if got := srv.Streams(); !cmp.Equal(got, []StreamKey{{ResourceID: ResourceID, ChannelID: testChannelID}}) {
+		t.Errorf("Streams() = %v, want one stream for %q", got, testChannelID)
+	}
+
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: []byte("test-body")}}
+	id, err := srv.Push(ctx, testChannelID, msg)
+	if err != nil {
+		t.Fatalf("Push() failed: %v", err)
+	}
+	got, err := conn.ReceiveContext(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveContext() failed: %v", err)
+	}
+	if diff := cmp.Diff(msg, got, protocmp.Transform()); diff != "" {
+		t.Errorf("ReceiveContext() diff (-want +got):\n%s", diff)
+	}
+	st, err := srv.WaitForAck(ctx, id)
+	if err != nil {
+		t.Fatalf("WaitForAck(%q) failed: %v", id, err)
+	}
+	if st.Code() != codes.OK {
+		t.Errorf("WaitForAck(%q) = %v, want OK", id, st)
+	}
+	if !srv.Acked(id) {
+		t.Errorf("Acked(%q) = false, want true", id)
+	}
+}
+
+func TestSent(t *testing.T) {
+	ctx := testContext(t)
+	srv, _, conn := Connect(t, testChannelID)
+
+	msgs := []*acpb.MessageBody{
+		{Body: &apb.Any{Value: []byte("one")}},
+		{Body: &apb.Any{Value: []byte("two")}},
+	}
+	for _, msg := range msgs {
+		if err := conn.SendMessageContext(ctx, msg); err != nil {
+			t.Fatalf("SendMessageContext() failed: %v", err)
+		}
+	}
+	sent, err := srv.WaitForSent(ctx, len(msgs))
+	if err != nil {
+		t.Fatalf("WaitForSent() failed: %v", err)
+	}
+	for i, msg := range msgs {
+		if sent[i].ChannelID != testChannelID || sent[i].ResourceID != ResourceID {
+			t.Errorf("Sent()[%d] stream = %+v, want %q on %q", i, sent[i].StreamKey, testChannelID, ResourceID)
+		}
+		if diff := cmp.Diff(msg, sent[i].Body, protocmp.Transform()); diff != "" {
+			t.Errorf("Sent()[%d] diff (-want +got):\n%s", i, diff)
+		}
+	}
+
+	m, err := srv.WaitForMessage(ctx, func(m *Message) bool { return string(m.Body.GetBody().GetValue()) == "two" })
+	if err != nil || m != sent[1] {
+		t.Errorf("WaitForMessage() = %v, %v, want the second message", m, err)
+	}
+
+	// Past the history limit the oldest messages are forgotten, but still counted.
+	srv.SetHistoryLimit(1)
+	if got := srv.Sent(); len(got) != 1 || got[0] != sent[1] {
+		t.Errorf("Sent() with a history limit of 1 = %v, want the last message", got)
+	}
+	if _, err := srv.WaitForSent(ctx, len(msgs)); err != nil {
+		t.Errorf("WaitForSent() of forgotten messages failed: %v", err)
+	}
+}
+
+func TestRateLimitsAndDisconnect(t *testing.T) {
+	ctx := testContext(t)
+	srv, _, conn := Connect(t, testChannelID)
+	if conn.MessageRateLimit() != 0 || conn.MessageBandwidthLimit() != 0 {
+		t.Errorf("limits = %d, %d, want none", conn.MessageRateLimit(), conn.MessageBandwidthLimit())
+	}
+
+	events, stop := conn.Subscribe()
+	defer stop()
+	srv.SetRateLimits(60, 1000)
+	srv.Disconnect(testChannelID, nil)
+	for ev := range events {
+		if ev.To == client.StateReady {
+			break
+		}
+	}
+	if conn.MessageRateLimit() != 60 || conn.MessageBandwidthLimit() != 1000 {
+		t.Errorf("limits after reconnect = %d, %d, want 60, 1000", conn.MessageRateLimit(), conn.MessageBandwidthLimit())
+	}
+	if _, err := srv.WaitForStream(ctx, testChannelID); err != nil {
+		t.Errorf("WaitForStream() failed: %v", err)
+	}
+}
+
+func TestMultipleChannels(t *testing.T) {
+	ctx := testContext(t)
+	srv, c, conn := Connect(t, testChannelID)
+	other, err := client.NewConnection(ctx, "other-channel", c)
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+	defer other.Close()
+
+	if _, err := srv.Push(ctx, "other-channel", &acpb.MessageBody{Body: &apb.Any{Value: []byte("other")}}); err != nil {
+		t.Fatalf("Push() failed: %v", err)
+	}
+	got, err := other.ReceiveContext(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveContext() failed: %v", err)
+	}
+	if string(got.GetBody().GetValue()) != "other" {
+		t.Errorf("ReceiveContext() = %v, want the message pushed to other-channel", got)
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if msg, err := conn.ReceiveContext(shortCtx); err == nil {
+		t.Errorf("ReceiveContext() on %q = %v, want no message", testChannelID, msg)
+	}
+}
+
+func TestStreamForChannel(t *testing.T) {
+	s := &Server{streams: make(map[StreamKey]*stream)}
+	for i, resourceID := range []string{"b", "c", "a"} {
+		key := StreamKey{ResourceID: resourceID, ChannelID: testChannelID}
+		s.streams[key] = &stream{key: key, registered: i + 1}
+	}
+	for range 10 {
+		if st := s.streamForChannelLocked(testChannelID); st == nil || st.key.ResourceID != "a" {
+			t.Fatalf("streamForChannelLocked() = %v, want the stream registered last", st)
+		}
+	}
+}
+
+func TestSendAgentMessage(t *testing.T) {
+	ctx := testContext(t)
+	srv, c, _ := Connect(t, testChannelID)
+
+	msg := &acpb.MessageBody{Body: &apb.Any{Value: []byte("ping")}}
+	resp, err := client.SendAgentMessage(ctx, testChannelID, c, msg)
+	if err != nil {
+		t.Fatalf("SendAgentMessage() failed: %v", err)
+	}
+	if diff := cmp.Diff(msg, resp.GetMessageBody(), protocmp.Transform()); diff != "" {
+		t.Errorf("SendAgentMessage() echo diff (-want +got):\n%s", diff)
+	}
+
+	srv.HandleSendAgentMessage(func(_ context.Context, req *acpb.SendAgentMessageRequest) (*acpb.SendAgentMessageResponse, error) {
+		if req.GetChannelId() != testChannelID || req.GetResourceId() != ResourceID {
+			t.Errorf("SendAgentMessage request = %v, want channel %q and resource %q", req, testChannelID, ResourceID)
+		}
+		return &acpb.SendAgentMessageResponse{MessageBody: &acpb.MessageBody{Body: &apb.Any{Value: []byte("pong")}}}, nil
+	})
+	resp, err = client.SendAgentMessage(ctx, testChannelID, c, msg)
+	if err != nil {
+		t.Fatalf("SendAgentMessage() failed: %v", err)
+	}
+	if got := string(resp.GetMessageBody().GetBody().GetValue()); got != "pong" {
+		t.Errorf("SendAgentMessage() = %q, want %q", got, "pong")
+	}
+}
+
+func TestEnforceRateLimits(t *testing.T) {
+	ctx := testContext(t)
+	srv := NewServer()
+	defer srv.Close()
+	srv.SetRateLimits(0, 100)
+	srv.EnforceRateLimits(true)
+	c, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c.Close()
+	conn, err := client.NewConnection(ctx, testChannelID, c, client.WithClientRateLimiting(false))
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+	defer conn.Close()
+
+	msg := &acpb.MessageBody{Body: &apb.Any{Value: make([]byte, 60)}}
+	if err := conn.SendMessageContext(ctx, msg); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	if err := conn.SendMessageContext(ctx, msg, client.WithSendRetries(0)); !errors.Is(err, client.ErrResourceExhausted) {
+		t.Errorf("SendMessageContext() over bandwidth = %v, want ErrResourceExhausted", err)
+	}
+	if sent := srv.Sent(); len(sent) != 1 {
+		t.Errorf("Sent() = %d messages, want 1, rejected messages are not recorded", len(sent))
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const defaultSendWindow = 64
+
+// ErrSendCanceled is the error of a send canceled with PendingSend.Cancel before its message was
+// written to the stream.
+var ErrSendCanceled = errors.New("send canceled")
+
+const (
+	sendQueued = iota
+	sendWritten
+	sendCanceled
+)
+
+// PendingSend is a send started by SendAsync.
+type PendingSend struct {
+	done   chan struct{}
+	err    error
+	cancel context.CancelCauseFunc
+
+	mu    sync.Mutex
+	state int
+}
+
+// Done returns a channel that is closed once the send completed, that is once the message was
+// acknowledged or the send failed.
+func (p *PendingSend) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the result of the send once Done is closed, as SendMessageContext would have, and
+// nil before.
+func (p *PendingSend) Err() error {
+	select {
+	case <-p.done:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// Wait waits for the send to complete and returns its result, or the context's error once ctx is
+// done. The send is not canceled when ctx is done.
+func (p *PendingSend) Wait(ctx context.Context) error {
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel cancels the send if its message was not written to the stream yet, in which case the
+// send fails with ErrSendCanceled. It returns false if the message was already written, the send
+// then continues until the message is acknowledged, or if the send already completed. Canceling
+// a canceled send again returns true.
+func (p *PendingSend) Cancel() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != sendQueued {
+		return p.state == sendCanceled
+	}
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	p.state = sendCanceled
+	p.cancel(ErrSendCanceled)
+	return true
+}
+
+// claim marks the message written, unless the send was canceled.
+func (p *PendingSend) claim() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == sendCanceled {
+		return false
+	}
+	p.state = sendWritten
+	return true
+}
+
+// SendAsync sends msg like SendMessageContext but returns once the send is started, the returned
+// PendingSend reports the result once the message is acknowledged. This allows a single goroutine
+// to have many messages in flight instead of waiting a round trip per message. At most the send
+// window (see WithSendWindow) of messages are outstanding at once, SendAsync blocks until one of
+// them completes if the window is full, or until ctx is done. It fails with
+// ErrConnectionDraining once Drain was called.
+//
+// ctx applies to the whole send, not only to the SendAsync call. msg must not be modified until
+// the send completed. Messages sent concurrently may be delivered in any order.
+func (c *Connection) SendAsync(ctx context.Context, msg *acpb.MessageBody, opts ...SendOption) (*PendingSend, error) {
+	select {
+	case c.sendWindow <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, fmt.Errorf("connection closed with err: %w", c.getCloseErr())
+	}
+	if err := c.startSend(); err != nil {
+		<-c.sendWindow
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	p := &PendingSend{done: make(chan struct{}), cancel: cancel}
+	go func() {
+		err := c.sendStarted(ctx, msg, append(slices.Clip(opts), withClaim(p.claim)))
+		c.inflight.Done()
+		<-c.sendWindow
+		p.mu.Lock()
+		if p.state == sendCanceled {
+			err = ErrSendCanceled
+		}
+		p.err = err
+		close(p.done)
+		p.mu.Unlock()
+		cancel(nil)
+	}()
+	return p, nil
+}
+
+// withClaim sets the function called before the message is written, see sendOptions.
+func withClaim(claim func() bool) SendOption {
+	return func(o *sendOptions) {
+		o.claim = claim
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSendAsync(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithSendWindow(2))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	var pending []*PendingSend
+	for _, v := range []string{"a", "b"} {
+		p, err := conn.SendAsync(ctx, testMessage(v))
+		if err != nil {
+			t.Fatalf("SendAsync() failed: %v", err)
+		}
+		pending = append(pending, p)
+	}
+	for _, p := range pending {
+		if err := p.Wait(ctx); err != nil {
+			t.Errorf("Wait() = %v, want nil", err)
+		}
+		if err := p.Err(); err != nil {
+			t.Errorf("Err() = %v, want nil", err)
+		}
+		if p.Cancel() {
+			t.Error("Cancel() of a completed send = true, want false")
+		}
+	}
+
+	// Only one more message is allowed by the quota, the next ones stay queued.
+	conn.quota.setLimits(1, 0)
+	if p, err := conn.SendAsync(ctx, testMessage("c")); err != nil || p.Wait(ctx) != nil {
+		t.Fatalf("SendAsync() = %v, want a successful send", err)
+	}
+	queued := make([]*PendingSend, 2)
+	for i, v := range []string{"d", "e"} {
+		if queued[i], err = conn.SendAsync(ctx, testMessage(v)); err != nil {
+			t.Fatalf("SendAsync() failed: %v", err)
+		}
+	}
+	if queued[0].Err() != nil {
+		t.Errorf("Err() of a queued send = %v, want nil", queued[0].Err())
+	}
+	// The window is full.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.SendAsync(timeoutCtx, testMessage("f")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendAsync() with a full window = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	for _, p := range queued {
+		if !p.Cancel() {
+			t.Error("Cancel() of a queued send = false, want true")
+		}
+		if err := p.Wait(ctx); !errors.Is(err, ErrSendCanceled) {
+			t.Errorf("Wait() of a canceled send = %v, want %v", err, ErrSendCanceled)
+		}
+	}
+	// Canceled sends free the window.
+	conn.quota.setLimits(0, 0)
+	if p, err := conn.SendAsync(ctx, testMessage("g")); err != nil || p.Wait(ctx) != nil {
+		t.Fatalf("SendAsync() = %v, want a successful send", err)
+	}
+
+	got := sentValues(srv)
+	slices.Sort(got)
+	if diff := cmp.Diff([]string{"a", "b", "c", "g"}, got); diff != "" {
+		t.Errorf("sent messages diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BackoffPolicy decides how long to wait before retrying. Implementations must be safe for
+// concurrent use.
+type BackoffPolicy interface {
+	// Backoff returns the delay before retry number attempt, attempt starts at 1.
+	Backoff(attempt int) time.Duration
+}
+
+// ExponentialBackoff grows the delay by Multiplier on every attempt, starting at Initial and never
+// exceeding Max (if set). Jitter is the fraction, between 0 and 1, of each delay that is randomized
+// so that many clients retrying at the same time spread out.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// Backoff implements BackoffPolicy. Without Max, the delay of large attempts is clamped to the
+// largest time.Duration.
+func (b ExponentialBackoff) Backoff(attempt int) time.Duration {
+	if b.Initial <= 0 {
+		return 0
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	d := float64(b.Initial) * math.Pow(multiplier, float64(max(attempt-1, 0)))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if jitter := min(max(b.Jitter, 0), 1); jitter > 0 {
+		d = d*(1-jitter) + rand.Float64()*d*jitter
+	}
+	// Converting a float64 beyond the int64 range to a Duration is undefined.
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+// ConstantBackoff always waits Delay.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+// Backoff implements BackoffPolicy.
+func (b ConstantBackoff) Backoff(int) time.Duration {
+	return b.Delay
+}
+
+// CappedBackoff limits the delay of Policy to Max. A nil Policy does not wait.
+type CappedBackoff struct {
+	Policy BackoffPolicy
+	Max    time.Duration
+}
+
+// Backoff implements BackoffPolicy.
+func (b CappedBackoff) Backoff(attempt int) time.Duration {
+	if b.Policy == nil {
+		return 0
+	}
+	return min(b.Policy.Backoff(attempt), b.Max)
+}
+
+// linearBackoff waits Base on the first attempt and adds Step for each following attempt, this is
+// the historical behavior of the client and is used as the default.
+type linearBackoff struct {
+	Base time.Duration
+	Step time.Duration
+}
+
+func (b linearBackoff) Backoff(attempt int) time.Duration {
+	return b.Base + time.Duration(max(attempt-1, 0))*b.Step
+}
+
+var (
+	// Reconnect on ResourceExhausted after 1s, 2s, ... 10s.
+	defaultResourceExhaustedBackoff BackoffPolicy = CappedBackoff{Policy: linearBackoff{Base: time.Second, Step: time.Second}, Max: 10 * time.Second}
+	// Reconnect on Unavailable immediately, then after 200ms, 400ms, ...
+	defaultUnavailableBackoff BackoffPolicy = linearBackoff{Step: 200 * time.Millisecond}
+	// Resend on ResourceExhausted after 250ms, 500ms, ...
+	defaultSendBackoff BackoffPolicy = linearBackoff{Base: 250 * time.Millisecond, Step: 250 * time.Millisecond}
+)
+
+// RetryAttempt describes a single failed attempt of a retried operation.
+type RetryAttempt struct {
+	// Err is the error returned by the attempt.
+	Err error
+	// Code is the status code of Err.
+	Code codes.Code
+	// Delay is the backoff that followed the attempt.
+	Delay time.Duration
+}
+
+// RetryError is returned when an operation was retried until its retry limit or time budget was
+// exhausted. It unwraps to the error of the last attempt.
+type RetryError struct {
+	Attempts []RetryAttempt
+}
+
+// Error returns the error message for RetryError.
+func (e *RetryError) Error() string {
+	if len(e.Attempts) == 0 {
+		return "retries exhausted"
+	}
+	return fmt.Sprintf("retries exhausted after %d attempts, last error: %v", len(e.Attempts), e.Attempts[len(e.Attempts)-1].Err)
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// errorCode maps err to a status code, including the errors returned by this package.
+func errorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrResourceExhausted):
+		return codes.ResourceExhausted
+	case errors.Is(err, ErrMessageTimeout):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	}
+	return status.Code(err)
+}
+
+// withinRetryBudget reports whether waiting delay before the next attempt stays within the
+// connection's max retry elapsed time, measured from start.
+func (c *Connection) withinRetryBudget(start time.Time, delay time.Duration) bool {
+	return c.maxRetryElapsedTime <= 0 || time.Since(start)+delay <= c.maxRetryElapsedTime
+}
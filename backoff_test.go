@@ -0,0 +1,112 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoffPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy BackoffPolicy
+		want   []time.Duration
+	}{
+		{
+			name:   "constant",
+			policy: ConstantBackoff{Delay: time.Second},
+			want:   []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:   "exponential",
+			policy: ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 3},
+			want:   []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second},
+		},
+		{
+			name:   "capped",
+			policy: CappedBackoff{Policy: linearBackoff{Base: time.Second, Step: time.Second}, Max: 2 * time.Second},
+			want:   []time.Duration{time.Second, 2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:   "capped nil policy",
+			policy: CappedBackoff{Max: time.Second},
+			want:   []time.Duration{0, 0},
+		},
+		{
+			name:   "default resource exhausted",
+			policy: defaultResourceExhaustedBackoff,
+			want:   []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second, 5 * time.Second, 6 * time.Second, 7 * time.Second, 8 * time.Second, 9 * time.Second, 10 * time.Second, 10 * time.Second},
+		},
+		{
+			name:   "default unavailable",
+			policy: defaultUnavailableBackoff,
+			want:   []time.Duration{0, 200 * time.Millisecond, 400 * time.Millisecond},
+		},
+		{
+			name:   "default send",
+			policy: defaultSendBackoff,
+			want:   []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, 750 * time.Millisecond},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, want := range tc.want {
+				if got := tc.policy.Backoff(i + 1); got != want {
+					t.Errorf("Backoff(%d) = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExponentialBackoffOverflow(t *testing.T) {
+	b := ExponentialBackoff{Initial: time.Second, Multiplier: 2, Jitter: 0.1}
+	for _, attempt := range []int{64, 100, 10000} {
+		if got := b.Backoff(attempt); float64(got) < 0.9*math.MaxInt64 {
+			t.Errorf("Backoff(%d) = %v, want about the largest Duration", attempt, got)
+		}
+	}
+	if got := (ExponentialBackoff{Multiplier: 2}).Backoff(10000); got != 0 {
+		t.Errorf("Backoff(10000) without Initial = %v, want 0", got)
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	b := ExponentialBackoff{Initial: time.Second, Multiplier: 2, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if got := b.Backoff(2); got < time.Second || got > 2*time.Second {
+			t.Fatalf("Backoff(2) = %v, want between 1s and 2s", got)
+		}
+	}
+}
+
+func TestRetryError(t *testing.T) {
+	err := &RetryError{Attempts: []RetryAttempt{
+		{Err: status.Error(codes.Unavailable, "unavailable"), Code: codes.Unavailable},
+		{Err: ErrResourceExhausted, Code: codes.ResourceExhausted},
+	}}
+	if !errors.Is(err, ErrResourceExhausted) {
+		t.Errorf("errors.Is(%v, ErrResourceExhausted) = false, want true", err)
+	}
+	if got := errorCode(ErrMessageTimeout); got != codes.DeadlineExceeded {
+		t.Errorf("errorCode(ErrMessageTimeout) = %v, want %v", got, codes.DeadlineExceeded)
+	}
+}
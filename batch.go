@@ -0,0 +1,176 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// SendResult is the result of a single message sent by SendMessages.
+type SendResult struct {
+	// Err is the error of the last attempt, nil if the message was acknowledged.
+	Err error
+	// Code is the status code of Err, codes.OK if the message was acknowledged.
+	Code codes.Code
+	// Attempts is the number of times the message was sent, 0 if it could not be encoded.
+	Attempts int
+}
+
+// batchMessage is a message of a SendMessages batch.
+type batchMessage struct {
+	frags []*acpb.MessageBody
+	// Index of the first fragment not acknowledged yet.
+	next int
+	// Outbox entry, if the connection has an outbox.
+	seq uint64
+}
+
+// send sends the remaining fragments of b, a single attempt each.
+func (b *batchMessage) send(ctx context.Context, c *Connection, o sendOptions) error {
+	for ; b.next < len(b.frags); b.next++ {
+		if err := c.sendMessage(ctx, b.frags[b.next], o); err != nil {
+			if len(b.frags) == 1 {
+				return err
+			}
+			return fmt.Errorf("sending fragment %d of %d: %w", b.next+1, len(b.frags), err)
+		}
+	}
+	return nil
+}
+
+// SendMessages sends msgs concurrently and waits for all of them to be acknowledged, which is much
+// faster than sending them one by one. Messages failing with a timeout or ResourceExhausted are
+// retried together, with a backoff after ResourceExhausted (see WithSendBackoff), while the others
+// are not resent. Options apply to every message, see SendMessageContext. At most the send window
+// (see WithSendWindow) of messages are in flight at once, shared with SendAsync.
+//
+// The result of msgs[i] is at index i of the returned slice, the returned error is non nil if at
+// least one message was not sent. Messages may be delivered in any order.
+func (c *Connection) SendMessages(ctx context.Context, msgs []*acpb.MessageBody, opts ...SendOption) (results []SendResult, err error) {
+	o := c.sendOptions(opts)
+	ctx, span := c.tracer.Start(ctx, "agentcommunication.SendMessages", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attrChannelID.String(c.channelID), attrBatchSize.Int(len(msgs))))
+	defer func() { endSpan(span, err) }()
+
+	results = make([]SendResult, len(msgs))
+	if err := c.startSend(); err != nil {
+		for i := range results {
+			results[i] = SendResult{Err: err, Code: errorCode(err)}
+		}
+		return results, err
+	}
+	defer c.inflight.Done()
+	batch := make([]*batchMessage, len(msgs))
+	var pending []int
+	for i, msg := range msgs {
+		msg = c.prepareMessage(ctx, msg, o)
+		b := &batchMessage{}
+		if c.outbox != nil {
+			if b.seq, results[i].Err = c.outbox.add(msg); results[i].Err != nil {
+				results[i].Err = fmt.Errorf("recording message in outbox: %w", results[i].Err)
+				continue
+			}
+		}
+		if b.frags, results[i].Err = c.encodeFragments(msg); results[i].Err != nil {
+			if c.outbox != nil {
+				c.outbox.remove(b.seq)
+			}
+			continue
+		}
+		batch[i] = b
+		pending = append(pending, i)
+	}
+
+	start := time.Now()
+	for round := 1; len(pending) > 0; round++ {
+		var wg sync.WaitGroup
+		for _, i := range pending {
+			select {
+			case c.sendWindow <- struct{}{}:
+			case <-ctx.Done():
+				results[i].Err = ctx.Err()
+				continue
+			case <-c.closed:
+				results[i].Err = fmt.Errorf("connection closed with err: %w", c.getCloseErr())
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-c.sendWindow }()
+				results[i].Attempts++
+				results[i].Err = batch[i].send(ctx, c, o)
+			}()
+		}
+		wg.Wait()
+
+		var retry []int
+		var delay time.Duration
+		for _, i := range pending {
+			switch err := results[i].Err; {
+			case errors.Is(err, ErrResourceExhausted):
+				delay = c.sendBackoff.Backoff(round)
+				retry = append(retry, i)
+			case errors.Is(err, ErrMessageTimeout):
+				// Waiting for the ack already took the ack timeout, resend immediately.
+				retry = append(retry, i)
+			}
+		}
+		if len(retry) == 0 || round > o.retries || !c.withinRetryBudget(start, delay) {
+			break
+		}
+		c.logger.Debug("Resending failed messages of batch", "count", len(retry), "of", len(msgs), "delay", delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			for _, i := range retry {
+				results[i].Err = err
+			}
+			break
+		}
+		pending = retry
+	}
+
+	var failed int
+	var first error
+	for i := range results {
+		r := &results[i]
+		r.Code = errorCode(r.Err)
+		if c.outbox != nil && batch[i] != nil {
+			if r.Err != nil && c.keepInOutbox(ctx, r.Err) {
+				c.outbox.release(batch[i].seq)
+				r.Err = fmt.Errorf("%w: %w", ErrOutboxed, r.Err)
+			} else if err := c.outbox.remove(batch[i].seq); err != nil {
+				c.logger.Warn("Error removing message from outbox", "error", err)
+			}
+		}
+		if r.Err != nil {
+			if failed == 0 {
+				first = fmt.Errorf("message %d: %w", i, r.Err)
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d messages not sent, first error: %w", failed, len(msgs), first)
+	}
+	return results, nil
+}
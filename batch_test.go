@@ -0,0 +1,119 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc/codes"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+func TestSendMessages(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithSendBackoff(ConstantBackoff{Delay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	busy := 0
+	srv.reqMx.Lock()
+	srv.ackFunc = func(msg *acpb.MessageBody) *spb.Status {
+		switch string(msg.GetBody().GetValue()) {
+		case "busy":
+			if busy++; busy == 1 {
+				return &spb.Status{Code: int32(codes.ResourceExhausted)}
+			}
+		case "invalid":
+			return &spb.Status{Code: int32(codes.InvalidArgument)}
+		}
+		return nil
+	}
+	srv.reqMx.Unlock()
+
+	msgs := []*acpb.MessageBody{testMessage("a"), testMessage("busy"), testMessage("invalid"), testMessage("b")}
+	got, err := conn.SendMessages(ctx, msgs)
+	if err == nil {
+		t.Error("SendMessages() succeeded, want an error for the invalid message")
+	}
+	want := []SendResult{
+		{Code: codes.OK, Attempts: 1},
+		{Code: codes.OK, Attempts: 2},
+		{Code: codes.InvalidArgument, Attempts: 1},
+		{Code: codes.OK, Attempts: 1},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(SendResult{}, "Err")); diff != "" {
+		t.Errorf("SendMessages() diff (-want +got):\n%s", diff)
+	}
+	if got[2].Err == nil {
+		t.Error("SendMessages() result of the invalid message has no error")
+	}
+
+	// Only the message failing with ResourceExhausted is resent.
+	sent := sentValues(srv)
+	slices.Sort(sent)
+	if diff := cmp.Diff([]string{"a", "b", "busy", "busy", "invalid"}, sent); diff != "" {
+		t.Errorf("sent messages diff (-want +got):\n%s", diff)
+	}
+
+	if got, err := conn.SendMessages(ctx, msgs[:1]); err != nil || got[0].Code != codes.OK {
+		t.Errorf("SendMessages() = %v, %v, want a successful send", got, err)
+	}
+}
+
+func TestSendMessages_Window(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithSendWindow(1))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	// Only one more message is allowed by the quota, the next one stays queued and fills the window.
+	conn.quota.setLimits(1, 0)
+	if _, err := conn.SendMessages(ctx, []*acpb.MessageBody{testMessage("a")}); err != nil {
+		t.Fatalf("SendMessages() failed: %v", err)
+	}
+	queued, err := conn.SendAsync(ctx, testMessage("b"))
+	if err != nil {
+		t.Fatalf("SendAsync() failed: %v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	got, err := conn.SendMessages(timeoutCtx, []*acpb.MessageBody{testMessage("c"), testMessage("d")})
+	if err == nil {
+		t.Error("SendMessages() with a full window succeeded, want an error")
+	}
+	for i, r := range got {
+		if !errors.Is(r.Err, context.DeadlineExceeded) || r.Attempts != 0 {
+			t.Errorf("SendMessages() result %d = %+v, want %v without attempts", i, r, context.DeadlineExceeded)
+		}
+	}
+
+	queued.Cancel()
+	conn.quota.setLimits(0, 0)
+	if _, err := conn.SendMessages(ctx, []*acpb.MessageBody{testMessage("c")}); err != nil {
+		t.Errorf("SendMessages() after the window freed = %v, want nil", err)
+	}
+	if diff := cmp.Diff([]string{"a", "c"}, sentValues(srv)); diff != "" {
+		t.Errorf("sent messages diff (-want +got):\n%s", diff)
+	}
+}
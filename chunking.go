@@ -0,0 +1,260 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	// ChunkIDLabel identifies the message a fragment belongs to.
+	ChunkIDLabel = "acs-chunk-id"
+	// ChunkIndexLabel is the 0 based position of a fragment in its message.
+	ChunkIndexLabel = "acs-chunk-index"
+	// ChunkCountLabel is the number of fragments of a message.
+	ChunkCountLabel = "acs-chunk-count"
+	// ChunkSizeLabel is the payload size of the reassembled message in bytes.
+	ChunkSizeLabel = "acs-chunk-size"
+
+	defaultReassemblyTimeout = time.Minute
+	defaultMaxMessageSize    = 64 << 20
+	// Room left in the bandwidth quota for the labels and framing of a fragment.
+	chunkOverhead = 512
+	// Bytes accounted per fragment of a message being reassembled, the size of a slice header.
+	partOverhead = 24
+)
+
+// splitMessage splits the payload of msg into fragments of at most size bytes. The first fragment
+// carries the labels and type URL of msg, every fragment carries the chunk labels.
+func splitMessage(msg *acpb.MessageBody, size int) []*acpb.MessageBody {
+	value := msg.GetBody().GetValue()
+	count := (len(value) + size - 1) / size
+	id := uuid.New().String()
+	frags := make([]*acpb.MessageBody, 0, count)
+	for i := range count {
+		labels := map[string]string{
+			ChunkIDLabel:    id,
+			ChunkIndexLabel: strconv.Itoa(i),
+			ChunkCountLabel: strconv.Itoa(count),
+			ChunkSizeLabel:  strconv.Itoa(len(value)),
+		}
+		body := &apb.Any{Value: value[i*size : min((i+1)*size, len(value))]}
+		if i == 0 {
+			for k, v := range msg.GetLabels() {
+				labels[k] = v
+			}
+			body.TypeUrl = msg.GetBody().GetTypeUrl()
+		}
+		frags = append(frags, &acpb.MessageBody{Labels: labels, Body: body})
+	}
+	return frags
+}
+
+// chunkSize returns the fragment size for the connection, capped so a fragment fits in the
+// bandwidth limit.
+func (c *Connection) chunkSize() int {
+	size := c.chunkThreshold
+	if limit := c.MessageBandwidthLimit(); limit > 2*chunkOverhead {
+		size = min(size, limit-chunkOverhead)
+	}
+	return size
+}
+
+// partialMessage is a message being reassembled.
+type partialMessage struct {
+	first    *acpb.MessageBody
+	parts    [][]byte
+	received int
+	// Bytes received so far and expected size of the payload.
+	buffered int
+	size     int
+	// Bytes accounted for parts, see partOverhead.
+	overhead int
+	expires  time.Time
+}
+
+// reassembler reassembles the fragments sent by splitMessage. Fragments can arrive in any order
+// and more than once, incomplete messages are dropped after the timeout. maxSize caps both the
+// size of a reassembled message and the bytes buffered for all incomplete messages.
+type reassembler struct {
+	timeout time.Duration
+	maxSize int
+
+	mu       sync.Mutex
+	partial  map[string]*partialMessage
+	buffered int
+	// Recently completed or dropped messages, to drop their redelivered fragments.
+	done map[string]time.Time
+}
+
+func newReassembler(timeout time.Duration, maxSize int) *reassembler {
+	return &reassembler{
+		timeout: timeout,
+		maxSize: maxSize,
+		partial: make(map[string]*partialMessage),
+		done:    make(map[string]time.Time),
+	}
+}
+
+// drop forgets the message with the given ID, its fragments received later are dropped too.
+func (r *reassembler) drop(id string, now time.Time) {
+	if p, ok := r.partial[id]; ok {
+		r.buffered -= p.buffered + p.overhead
+		delete(r.partial, id)
+	}
+	r.done[id] = now.Add(r.timeout)
+}
+
+// expire drops the partial messages past their timeout.
+func (r *reassembler) expire(now time.Time, logger *slog.Logger) {
+	for id, p := range r.partial {
+		if now.After(p.expires) {
+			logger.Warn("Dropping incomplete message after reassembly timeout", "chunk_id", id, "received", p.received, "count", len(p.parts))
+			r.drop(id, now)
+		}
+	}
+	for id, expires := range r.done {
+		if now.After(expires) {
+			delete(r.done, id)
+		}
+	}
+}
+
+// parseChunkLabels returns the index, count and total size of a fragment.
+func parseChunkLabels(labels map[string]string) (index, count, size int, err error) {
+	if index, err = strconv.Atoi(labels[ChunkIndexLabel]); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid %s: %w", ChunkIndexLabel, err)
+	}
+	if count, err = strconv.Atoi(labels[ChunkCountLabel]); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid %s: %w", ChunkCountLabel, err)
+	}
+	if size, err = strconv.Atoi(labels[ChunkSizeLabel]); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid %s: %w", ChunkSizeLabel, err)
+	}
+	if count <= 0 || index < 0 || index >= count || size < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid fragment %d of %d with size %d", index, count, size)
+	}
+	// Every fragment carries at least a byte of the payload.
+	if count > max(size, 1) {
+		return 0, 0, 0, fmt.Errorf("invalid fragment count %d for size %d", count, size)
+	}
+	return index, count, size, nil
+}
+
+// add adds a received message, it returns the message unchanged if it is not a fragment, the
+// reassembled message once its last fragment is added, and nil otherwise.
+func (r *reassembler) add(msg *acpb.MessageBody, logger *slog.Logger) *acpb.MessageBody {
+	id, ok := msg.GetLabels()[ChunkIDLabel]
+	if !ok {
+		return msg
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.expire(now, logger)
+
+	logger = logger.With("chunk_id", id)
+	if _, ok := r.done[id]; ok {
+		logger.Debug("Dropping fragment of a completed or dropped message")
+		return nil
+	}
+	index, count, size, err := parseChunkLabels(msg.GetLabels())
+	if err != nil {
+		logger.Warn("Dropping malformed fragment", "error", err)
+		return nil
+	}
+	p, ok := r.partial[id]
+	if !ok {
+		if size > r.maxSize {
+			logger.Warn("Dropping message larger than the max message size", "size", size, "max_size", r.maxSize)
+			r.drop(id, now)
+			return nil
+		}
+		overhead := count * partOverhead
+		if r.buffered+overhead > r.maxSize {
+			logger.Warn("Dropping message, too many bytes buffered for incomplete messages", "max_size", r.maxSize)
+			r.drop(id, now)
+			return nil
+		}
+		p = &partialMessage{parts: make([][]byte, count), size: size, overhead: overhead, expires: now.Add(r.timeout)}
+		r.partial[id] = p
+		r.buffered += overhead
+	}
+	if count != len(p.parts) || size != p.size {
+		logger.Warn("Dropping message with inconsistent fragments", "count", count, "size", size)
+		r.drop(id, now)
+		return nil
+	}
+	if p.parts[index] != nil {
+		logger.Debug("Dropping duplicate fragment", "index", index)
+		return nil
+	}
+	value := msg.GetBody().GetValue()
+	if p.buffered+len(value) > size {
+		logger.Warn("Dropping message with fragments larger than its size", "size", size)
+		r.drop(id, now)
+		return nil
+	}
+	if r.buffered+len(value) > r.maxSize {
+		logger.Warn("Dropping message, too many bytes buffered for incomplete messages", "max_size", r.maxSize)
+		r.drop(id, now)
+		return nil
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	p.parts[index] = value
+	p.received++
+	p.buffered += len(value)
+	r.buffered += len(value)
+	if index == 0 {
+		p.first = msg
+	}
+	if p.received < count {
+		return nil
+	}
+
+	r.drop(id, now)
+	payload := make([]byte, 0, size)
+	for _, part := range p.parts {
+		payload = append(payload, part...)
+	}
+	if len(payload) != size {
+		logger.Warn("Dropping reassembled message with unexpected size", "size", len(payload), "want_size", size)
+		return nil
+	}
+	labels := make(map[string]string, len(p.first.GetLabels()))
+	for k, v := range p.first.GetLabels() {
+		switch k {
+		case ChunkIDLabel, ChunkIndexLabel, ChunkCountLabel, ChunkSizeLabel:
+		default:
+			labels[k] = v
+		}
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+	logger.Debug("Reassembled message", "count", count, "size", size)
+	return &acpb.MessageBody{Labels: labels, Body: &apb.Any{TypeUrl: p.first.GetBody().GetTypeUrl(), Value: payload}}
+}
@@ -0,0 +1,188 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+func TestSplitAndReassemble(t *testing.T) {
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{TypeUrl: "example.com/T", Value: []byte("0123456789abcdefghijklmno")}}
+	frags := splitMessage(msg, 10)
+	if len(frags) != 3 {
+		t.Fatalf("splitMessage() returned %d fragments, want 3", len(frags))
+	}
+	for i, frag := range frags {
+		if frag.GetLabels()[ChunkIndexLabel] != strconv.Itoa(i) || frag.GetLabels()[ChunkCountLabel] != "3" || frag.GetLabels()[ChunkSizeLabel] != "25" {
+			t.Errorf("fragment %d labels = %v, want index %d of 3 with size 25", i, frag.GetLabels(), i)
+		}
+	}
+
+	r := newReassembler(time.Minute, 100)
+	if got := r.add(msg, discardLogger); got != msg {
+		t.Errorf("add() of an unchunked message = %v, want it unchanged", got)
+	}
+	// Out of order, with a duplicate.
+	for _, i := range []int{2, 0, 2} {
+		if got := r.add(frags[i], discardLogger); got != nil {
+			t.Fatalf("add(fragment %d) = %v, want nil before the last fragment", i, got)
+		}
+	}
+	got := r.add(frags[1], discardLogger)
+	if diff := cmp.Diff(msg, got, protocmp.Transform()); diff != "" {
+		t.Errorf("reassembled message diff (-want +got):\n%s", diff)
+	}
+	// Redelivered fragments of a completed message are dropped.
+	if got := r.add(frags[0], discardLogger); got != nil {
+		t.Errorf("add() of a redelivered fragment = %v, want nil", got)
+	}
+	if len(r.partial) != 0 || r.buffered != 0 {
+		t.Errorf("reassembler holds %d partial messages and %d bytes, want none", len(r.partial), r.buffered)
+	}
+}
+
+func TestReassembler_Drops(t *testing.T) {
+	big := &acpb.MessageBody{Body: &apb.Any{Value: make([]byte, 50)}}
+	withLabel := func(frag *acpb.MessageBody, key, value string) *acpb.MessageBody {
+		frag = proto.Clone(frag).(*acpb.MessageBody)
+		frag.Labels[key] = value
+		return frag
+	}
+
+	tests := []struct {
+		name  string
+		frags func() []*acpb.MessageBody
+	}{
+		{"too large", func() []*acpb.MessageBody {
+			return splitMessage(&acpb.MessageBody{Body: &apb.Any{Value: make([]byte, 101)}}, 50)
+		}},
+		{"too many buffered bytes", func() []*acpb.MessageBody {
+			a, b, c := splitMessage(big, 45), splitMessage(big, 45), splitMessage(big, 45)
+			return []*acpb.MessageBody{a[0], b[0], c[0], c[1]}
+		}},
+		{"malformed", func() []*acpb.MessageBody {
+			frags := splitMessage(big, 25)
+			return []*acpb.MessageBody{withLabel(frags[0], ChunkIndexLabel, "2"), withLabel(frags[1], ChunkCountLabel, "x")}
+		}},
+		{"count larger than size", func() []*acpb.MessageBody {
+			frags := splitMessage(big, 25)
+			return []*acpb.MessageBody{withLabel(frags[0], ChunkCountLabel, "1000000000000")}
+		}},
+		{"count beyond max size", func() []*acpb.MessageBody {
+			frags := splitMessage(big, 25)
+			return []*acpb.MessageBody{withLabel(frags[0], ChunkCountLabel, "50")}
+		}},
+		{"inconsistent", func() []*acpb.MessageBody {
+			frags := splitMessage(big, 25)
+			return []*acpb.MessageBody{frags[0], withLabel(frags[1], ChunkSizeLabel, "49")}
+		}},
+		{"oversized fragment", func() []*acpb.MessageBody {
+			frags := splitMessage(big, 25)
+			frags[1].Body.Value = make([]byte, 26)
+			return frags
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newReassembler(time.Minute, 100)
+			for i, frag := range tc.frags() {
+				if got := r.add(frag, discardLogger); got != nil {
+					t.Errorf("add(%d) = %v, want the message dropped", i, got)
+				}
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		r := newReassembler(10*time.Millisecond, 100)
+		frags := splitMessage(big, 25)
+		r.add(frags[0], discardLogger)
+		time.Sleep(20 * time.Millisecond)
+		if got := r.add(frags[1], discardLogger); got != nil {
+			t.Errorf("add() after the reassembly timeout = %v, want nil", got)
+		}
+		if len(r.partial) != 0 || r.buffered != 0 {
+			t.Errorf("reassembler holds %d partial messages and %d bytes, want none", len(r.partial), r.buffered)
+		}
+	})
+}
+
+func TestChunking(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithChunking(10))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{TypeUrl: "example.com/T", Value: []byte("0123456789abcdefghijklmno")}}
+	if err := conn.SendMessageContext(ctx, msg); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	// Register plus three fragments.
+	waitForRequests(t, srv, 4)
+	srv.reqMx.Lock()
+	var frags []*acpb.MessageBody
+	for _, req := range srv.req[1:] {
+		frags = append(frags, req.GetMessageBody())
+	}
+	srv.reqMx.Unlock()
+	for i, frag := range frags {
+		if n := len(frag.GetBody().GetValue()); n > 10 {
+			t.Errorf("fragment %d has %d bytes, want at most 10", i, n)
+		}
+	}
+
+	// A short message is not chunked.
+	short := &acpb.MessageBody{Body: &apb.Any{Value: []byte("short")}}
+	if err := conn.SendMessageContext(ctx, short); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	waitForRequests(t, srv, 5)
+	srv.reqMx.Lock()
+	if labels := srv.req[4].GetMessageBody().GetLabels(); len(labels) != 0 {
+		t.Errorf("short message labels = %v, want none", labels)
+	}
+	srv.reqMx.Unlock()
+
+	for i, frag := range []*acpb.MessageBody{frags[1], frags[0], frags[1], frags[2]} {
+		pushMessage(srv, strconv.Itoa(i), frag)
+	}
+	got, err := conn.ReceiveContext(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveContext() failed: %v", err)
+	}
+	if diff := cmp.Diff(msg, got, protocmp.Transform()); diff != "" {
+		t.Errorf("ReceiveContext() diff (-want +got):\n%s", diff)
+	}
+
+	// The chunk labels must fit along with the labels of the message.
+	labeled := &acpb.MessageBody{Labels: map[string]string{}, Body: &apb.Any{Value: msg.GetBody().GetValue()}}
+	for i := range 10 {
+		labeled.Labels[strconv.Itoa(i)] = "value"
+	}
+	if err := conn.SendMessageContext(ctx, labeled); err == nil {
+		t.Error("SendMessageContext() of a chunked message with 10 labels succeeded, want error")
+	}
+}
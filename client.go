@@ -20,8 +20,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
+	"log/slog"
+	"maps"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,23 +35,23 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
 
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
 )
 
-func init() {
-	logger = log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Lshortfile)
-}
-
 const (
 	metadataMessageRateLimit = "agent-communication-message-rate-limit"
 	metadataBandwidthLimit   = "agent-communication-bandwidth-limit"
 )
 
 var (
-	// DebugLogging enables debug logging.
-	DebugLogging = false
 	// ErrConnectionClosed is an error indicating that the connection was closed by the caller.
 	ErrConnectionClosed = errors.New("connection closed")
 	// ErrMessageTimeout is an error indicating message send timed out.
@@ -66,14 +66,12 @@ var (
 	defaultOpts = []option.ClientOption{
 		option.WithoutAuthentication(), // Do not use oauth.
 		option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(nil))), // Because we disabled Auth we need to specifically enable TLS.
-		option.WithGRPCDialOption(grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: 60 * time.Second, Timeout: 10 * time.Second})),
 	}
 
-	logger *log.Logger
-
+	// Defaults for new connections, see WithMaxResourceExhaustedRetries and
+	// WithMaxUnavailableRetries.
 	maxResourceExhaustedRetries = 20
 	maxUnavailableRetries       = 5
-	timeSleep                   = time.Sleep
 )
 
 // ErrUnsupportedUniverse is an error indicating that ACS is not supported in
@@ -93,12 +91,6 @@ func (e *ErrUnsupportedUniverse) Is(target error) bool {
 	return ok
 }
 
-func loggerPrintf(format string, v ...any) {
-	if DebugLogging {
-		logger.Output(2, fmt.Sprintf(format, v...))
-	}
-}
-
 func getEndpoint(regional bool) (string, error) {
 	location := getZone()
 	if regional {
@@ -114,16 +106,22 @@ func getEndpoint(regional bool) (string, error) {
 
 // NewClient creates a new agent communication grpc client.
 // Caller must close the returned client when it is done being used to clean up its underlying
-// connections.
+// connections. Diagnostics are logged to the logger set with WithClientLogger, or SetLogger.
 func NewClient(ctx context.Context, regional bool, opts ...option.ClientOption) (*agentcommunication.Client, error) {
+	return newClient(ctx, regional, defaultKeepaliveParams, defaultLogger(), opts...)
+}
+
+// newClient creates a client like NewClient, logging to logger unless opts hold WithClientLogger.
+func newClient(ctx context.Context, regional bool, kp keepalive.ClientParameters, logger *slog.Logger, opts ...option.ClientOption) (*agentcommunication.Client, error) {
+	logger = clientLogger(opts, logger)
 	// Using VSOCK requires the vsockAvailable function to return true, it checks if VSOCK is
 	// available on the system and if DefaultAllowVSOCK is set to true.
 	// VSOCK connections do not require metadata initialization.
-	if vsockAvailable() {
-		return newVSOCKClient(ctx, vsockPort, opts...)
+	if vsockAvailable(logger) {
+		return newVSOCKClient(ctx, vsockPort, kp, opts...)
 	}
 
-	if err := metadataInit(); err != nil {
+	if err := metadataInit(logger); err != nil {
 		return nil, err
 	}
 
@@ -131,37 +129,36 @@ func NewClient(ctx context.Context, regional bool, opts ...option.ClientOption)
 	if err != nil {
 		return nil, err
 	}
-	optsWithEndpoint := append(defaultOpts, option.WithEndpoint(endpoint))
+	optsWithEndpoint := append(defaultOpts, option.WithGRPCDialOption(grpc.WithKeepaliveParams(kp)), option.WithEndpoint(endpoint))
 	client, err := agentcommunication.NewClient(ctx, append(optsWithEndpoint, opts...)...)
 	if err != nil {
 		return nil, err
 	}
-	loggerPrintf("Dialed Target: %q", client.Connection().Target())
+	logger.Debug("Dialed target", "target", client.Connection().Target(), logKeyTransport, transportNetwork)
 	return client, nil
 }
 
 // SendAgentMessage sends a message to the client. This is equivalent to sending a message via
 // StreamAgentMessages with a single message and waiting for the response.
 func SendAgentMessage(ctx context.Context, channelID string, client *agentcommunication.Client, msg *acpb.MessageBody) (*acpb.SendAgentMessageResponse, error) {
-	loggerPrintf("SendAgentMessage")
+	logger := defaultLogger().With(logKeyChannelID, channelID)
 
 	md := metadata.New(map[string]string{
 		"agent-communication-channel-id": channelID,
 	})
 
-	loggerPrintf("Using ChannelID %q", channelID)
 	ctx = metadata.NewOutgoingContext(ctx, md)
 
 	// VSOCK connections do not require metadata initialization, resource ID is set by the proxy.
 	if clientUsingVSOCK(client) {
-		loggerPrintf("Using VSOCK connection.")
+		logger.Debug("SendAgentMessage", logKeyTransport, transportVSOCK)
 		return client.SendAgentMessage(ctx, &acpb.SendAgentMessageRequest{
 			ChannelId:   channelID,
 			MessageBody: msg,
 		})
 	}
 
-	if err := metadataInit(); err != nil {
+	if err := metadataInit(logger); err != nil {
 		return nil, err
 	}
 	token, err := getIdentityToken()
@@ -170,7 +167,7 @@ func SendAgentMessage(ctx context.Context, channelID string, client *agentcommun
 	}
 	md.Set("authentication", "Bearer "+token)
 	md.Set("agent-communication-resource-id", getResourceID())
-	loggerPrintf("Using ResourceID %q", getResourceID())
+	logger.Debug("SendAgentMessage", logKeyTransport, transportNetwork, logKeyResourceID, getResourceID())
 
 	return client.SendAgentMessage(ctx, &acpb.SendAgentMessageRequest{
 		ChannelId:   channelID,
@@ -194,17 +191,83 @@ type Connection struct {
 	resourceID  string
 	channelID   string
 
-	messages     chan *acpb.MessageBody
+	messages     chan *Envelope
 	responseSubs map[string]chan *status.Status
 	responseMx   sync.Mutex
 
 	regional          bool
+	clientOpts        []option.ClientOption
+	keepaliveParams   keepalive.ClientParameters
 	timeToWaitForResp time.Duration
+	streamTimeout     time.Duration
+	receiveBufferSize int
 	usingVSOCK        bool
 
+	maxResourceExhaustedRetries int
+	maxUnavailableRetries       int
+	resourceExhaustedBackoff    BackoffPolicy
+	unavailableBackoff          BackoffPolicy
+	sendBackoff                 BackoffPolicy
+	maxRetryElapsedTime         time.Duration
+
+	stateMx      sync.Mutex
+	state        ConnectionState
+	generation   int
+	stateSubs    map[int]chan StateEvent
+	nextStateSub int
+	// In flight sends, waited on by Drain.
+	inflight sync.WaitGroup
+
 	limitsMx              sync.Mutex
 	messageRateLimit      int
 	messageBandwidthLimit int
+	// Paces sends to the limits above.
+	quota              *quotaLimiter
+	clientRateLimiting bool
+
+	meterProvider metric.MeterProvider
+	metrics       *connectionMetrics
+
+	tracer           trace.Tracer
+	tracePropagation bool
+
+	// Resolves the type URLs of received messages, see ReceiveProto.
+	typeResolver protoregistry.MessageTypeResolver
+
+	// Payloads larger than this are split into fragments, 0 disables chunking.
+	chunkThreshold    int
+	reassemblyTimeout time.Duration
+	maxMessageSize    int
+	reassembler       *reassembler
+
+	compression          Compression
+	compressionThreshold int
+	encryption           *encryptionCodec
+	signer               Signer
+	trustedKeys          *TrustedKeys
+	maxClockSkew         time.Duration
+	// Payload pipeline, see bodyCodec.
+	codecs []bodyCodec
+
+	outbox *Outbox
+	// Held while the outbox is replayed.
+	replayMx sync.Mutex
+	// Holds a token while a replay is requested, see replayOutbox.
+	replayPending chan struct{}
+
+	// Holds a token per outstanding SendAsync.
+	sendWindow     chan struct{}
+	sendWindowSize int
+
+	// Received messages are acknowledged by Envelope.Ack, see WithManualAck.
+	manualAck   bool
+	ackDeadline time.Duration
+	ackExpired  func(*Envelope)
+	// Received messages it selects are rejected instead of delivered, see WithRejectPolicy.
+	rejectPolicy *RejectPolicy
+
+	// Carries the channel ID, transport and resource ID attributes.
+	logger *slog.Logger
 }
 
 // MessageRateLimit returns the message limit (in messages/minute) for the connection.
@@ -226,12 +289,6 @@ func (c *Connection) Close() {
 	c.close(ErrConnectionClosed)
 }
 
-func (c *Connection) setCloseErr(err error) {
-	c.closeErrMx.Lock()
-	defer c.closeErrMx.Unlock()
-	c.closeErr = err
-}
-
 func (c *Connection) getCloseErr() error {
 	c.closeErrMx.RLock()
 	defer c.closeErrMx.RUnlock()
@@ -239,62 +296,86 @@ func (c *Connection) getCloseErr() error {
 }
 
 func (c *Connection) close(err error) {
-	loggerPrintf("closing connection with err: %v", err)
-	st, _ := status.FromError(err)
-	loggerPrintf("closing connection with status: %+v", st)
+	c.closeErrMx.Lock()
 	select {
 	case <-c.closed:
+		c.closeErrMx.Unlock()
 		return
 	default:
-		close(c.closed)
-		c.setCloseErr(err)
-		if !c.callerManagedClient {
-			c.client.Close()
-		}
+	}
+	if errors.Is(err, ErrConnectionClosed) {
+		c.logger.Info("Closing connection")
+	} else {
+		c.logger.Error("Closing connection", "error", err, "code", status.Code(err))
+	}
+	c.closeErr = err
+	close(c.closed)
+	c.closeErrMx.Unlock()
+	c.setState(StateClosed, err, 0)
+	c.metrics.close()
+	if !c.callerManagedClient {
+		c.client.Close()
 	}
 }
 
-func (c *Connection) waitForResponse(key string, channel chan *status.Status) error {
-	defer func() {
-		c.responseMx.Lock()
-		delete(c.responseSubs, key)
-		c.responseMx.Unlock()
-	}()
-
-	timer := time.NewTimer(c.timeToWaitForResp)
+func (c *Connection) waitForResponse(ctx context.Context, key string, channel chan *status.Status, timeout time.Duration) error {
+	sent := time.Now()
+	timer := time.NewTimer(timeout)
 	defer timer.Stop()
 	select {
 	case st := <-channel:
+		c.metrics.acked(time.Since(sent))
 		if st != nil {
 			switch st.Code() {
 			case codes.OK:
 			case codes.ResourceExhausted:
+				c.metrics.resourceExhaustedResponse()
 				return fmt.Errorf("%w: %s", ErrResourceExhausted, st.Message())
 			default:
-				return fmt.Errorf("unexpected status: %+v", st)
+				return fmt.Errorf("unexpected status: %w", st.Err())
 			}
 		}
 	case <-timer.C:
+		c.metrics.timeout()
 		return fmt.Errorf("%w: timed out waiting for response, MessageID: %q", ErrMessageTimeout, key)
+	case <-ctx.Done():
+		return ctx.Err()
 	case <-c.closed:
 		return fmt.Errorf("connection closed with err: %w", c.getCloseErr())
 	}
 	return nil
 }
 
-func (c *Connection) sendWithResp(req *acpb.StreamAgentMessagesRequest, channel chan *status.Status) error {
-	loggerPrintf("Sending message %+v", req)
+func (c *Connection) sendWithResp(ctx context.Context, req *acpb.StreamAgentMessagesRequest, channel chan *status.Status, o sendOptions) error {
+	c.logger.Debug("Sending message", logKeyMessageID, req.GetMessageId(), "size", proto.Size(req.GetMessageBody()))
 
+	if o.claim != nil && !o.claim() {
+		return ErrSendCanceled
+	}
 	select {
 	case <-c.closed:
 		return fmt.Errorf("connection closed with err: %w", c.getCloseErr())
+	case <-ctx.Done():
+		return ctx.Err()
 	case c.sends <- req:
 	}
+	c.metrics.sent(proto.Size(req.GetMessageBody()))
 
-	return c.waitForResponse(req.GetMessageId(), channel)
+	ctx, span := c.tracer.Start(ctx, "agentcommunication.WaitForAck", trace.WithAttributes(attrMessageID.String(req.GetMessageId())))
+	err := c.waitForResponse(ctx, req.GetMessageId(), channel, o.ackTimeout)
+	endSpan(span, err)
+	return err
 }
 
-func (c *Connection) sendMessage(msg *acpb.MessageBody) error {
+func (c *Connection) sendMessage(ctx context.Context, msg *acpb.MessageBody, o sendOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.clientRateLimiting {
+		if err := c.quota.wait(ctx, proto.Size(msg), c.logger); err != nil {
+			return err
+		}
+	}
 	req := &acpb.StreamAgentMessagesRequest{
 		MessageId: uuid.New().String(),
 		Type:      &acpb.StreamAgentMessagesRequest_MessageBody{MessageBody: msg},
@@ -304,46 +385,202 @@ func (c *Connection) sendMessage(msg *acpb.MessageBody) error {
 	c.responseMx.Lock()
 	c.responseSubs[req.GetMessageId()] = channel
 	c.responseMx.Unlock()
+	defer func() {
+		c.responseMx.Lock()
+		delete(c.responseSubs, req.GetMessageId())
+		c.responseMx.Unlock()
+	}()
 
 	select {
 	case <-c.closed:
 		return fmt.Errorf("connection closed with err: %w", c.getCloseErr())
+	case <-ctx.Done():
+		return ctx.Err()
 	case c.streamReady <- struct{}{}: // Only sends if the stream is ready to send.
 	}
 
-	return c.sendWithResp(req, channel)
+	return c.sendWithResp(ctx, req, channel, o)
+}
+
+// sleepContext sleeps for d or until ctx is done, whichever happens first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // SendMessage sends a message to the client. Will automatically retry on message timeout (temporary
-// disconnects) and in the case of ResourceExhausted with a backoff. Because retries are limited
-// the returned error can in some cases be one of ErrMessageTimeout or ErrResourceExhausted, in
-// which case send should be retried by the caller.
+// disconnects) and in the case of ResourceExhausted with a backoff (see WithSendBackoff). Because
+// retries are limited the returned error can in some cases be a *RetryError wrapping one of
+// ErrMessageTimeout or ErrResourceExhausted, in which case send should be retried by the caller.
+//
+// SendMessage is equivalent to SendMessageContext with a background context.
 func (c *Connection) SendMessage(msg *acpb.MessageBody) error {
-	var err error
-	// Retry 4 times.
-	for i := 1; i <= 5; i++ {
-		err := c.sendMessage(msg)
-		if errors.Is(err, ErrResourceExhausted) {
-			// Start with 250ms sleep, then simply multiply by iteration.
-			timeSleep(time.Duration(i*250) * time.Millisecond)
-			continue
+	return c.SendMessageContext(context.Background(), msg)
+}
+
+// SendMessageContext is like SendMessage but returns early with the context's error once ctx is
+// canceled or its deadline is exceeded, including while waiting between retries. Options apply to
+// this message only, the passed in msg is never modified.
+func (c *Connection) SendMessageContext(ctx context.Context, msg *acpb.MessageBody, opts ...SendOption) error {
+	if err := c.startSend(); err != nil {
+		return err
+	}
+	defer c.inflight.Done()
+	return c.sendStarted(ctx, msg, opts)
+}
+
+// sendStarted sends msg like SendMessageContext, the send must be registered with startSend.
+func (c *Connection) sendStarted(ctx context.Context, msg *acpb.MessageBody, opts []SendOption) (err error) {
+	o := c.sendOptions(opts)
+	ctx, span := c.tracer.Start(ctx, "agentcommunication.SendMessage", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attrChannelID.String(c.channelID)))
+	defer func() { endSpan(span, err) }()
+	msg = c.prepareMessage(ctx, msg, o)
+	if c.outbox != nil {
+		return c.sendOutboxed(ctx, msg, o)
+	}
+	return c.sendBody(ctx, msg, o)
+}
+
+func (c *Connection) sendOptions(opts []SendOption) sendOptions {
+	o := sendOptions{retries: defaultSendRetries, ackTimeout: c.timeToWaitForResp}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// prepareMessage adds the labels of o and the trace context of ctx to msg, it returns a copy if
+// msg needs changes.
+func (c *Connection) prepareMessage(ctx context.Context, msg *acpb.MessageBody, o sendOptions) *acpb.MessageBody {
+	if len(o.labels) > 0 {
+		msg = proto.Clone(msg).(*acpb.MessageBody)
+		if msg.Labels == nil {
+			msg.Labels = make(map[string]string, len(o.labels))
 		}
-		if errors.Is(err, ErrMessageTimeout) {
-			continue
+		maps.Copy(msg.Labels, o.labels)
+	}
+	if c.tracePropagation {
+		msg = injectTraceLabels(ctx, msg, c.logger)
+	}
+	return msg
+}
+
+// encodeFragments encodes msg and splits it into fragments if its payload exceeds the chunking
+// threshold.
+func (c *Connection) encodeFragments(msg *acpb.MessageBody) ([]*acpb.MessageBody, error) {
+	msg, err := c.encodeBody(msg)
+	if err != nil {
+		return nil, err
+	}
+	if c.chunkThreshold > 0 && len(msg.GetBody().GetValue()) > c.chunkThreshold {
+		frags := splitMessage(msg, c.chunkSize())
+		// The first fragment carries the chunk labels on top of the labels of msg.
+		if err := checkLabelLimits(frags[0].GetLabels()); err != nil {
+			return nil, fmt.Errorf("splitting message into fragments: %w", err)
 		}
+		c.logger.Debug("Sending message in fragments", "size", len(msg.GetBody().GetValue()), "count", len(frags), "chunk_id", frags[0].GetLabels()[ChunkIDLabel])
+		return frags, nil
+	}
+	return []*acpb.MessageBody{msg}, nil
+}
+
+// sendBody encodes msg and sends it, in fragments if its payload exceeds the chunking threshold.
+func (c *Connection) sendBody(ctx context.Context, msg *acpb.MessageBody, o sendOptions) error {
+	frags, err := c.encodeFragments(msg)
+	if err != nil {
 		return err
 	}
-	return err
+	if len(frags) == 1 {
+		return c.sendWithRetries(ctx, frags[0], o)
+	}
+	for i, frag := range frags {
+		if err := c.sendWithRetries(ctx, frag, o); err != nil {
+			return fmt.Errorf("sending fragment %d of %d: %w", i+1, len(frags), err)
+		}
+	}
+	return nil
+}
+
+// sendWithRetries sends msg, retrying as configured by o.
+func (c *Connection) sendWithRetries(ctx context.Context, msg *acpb.MessageBody, o sendOptions) error {
+	start := time.Now()
+	var attempts []RetryAttempt
+	for i := 1; ; i++ {
+		err := c.sendMessage(ctx, msg, o)
+		if err == nil {
+			return nil
+		}
+		var delay time.Duration
+		switch {
+		case errors.Is(err, ErrResourceExhausted):
+			delay = c.sendBackoff.Backoff(i)
+		case errors.Is(err, ErrMessageTimeout):
+			// Waiting for the ack already took the ack timeout, resend immediately.
+		default:
+			return err
+		}
+		attempts = append(attempts, RetryAttempt{Err: err, Code: errorCode(err), Delay: delay})
+		if i > o.retries || !c.withinRetryBudget(start, delay) {
+			return &RetryError{Attempts: attempts}
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
 }
 
 // Receive messages, Receive should be called continuously for the life of the stream connection,
 // any delay in Receive when there are queued messages will cause the server to disconnect the
 // stream. This means handling the MessageBody from Receive should not be blocking, offload message
-// handling to another goroutine and immediately call Receive again.
+// handling to another goroutine and immediately call Receive again, or use Serve which does so
+// with a bounded pool of workers.
+//
+// Receive is equivalent to ReceiveContext with a background context.
 func (c *Connection) Receive() (*acpb.MessageBody, error) {
+	return c.ReceiveContext(context.Background())
+}
+
+// ReceiveContext is like Receive but returns the context's error once ctx is canceled or its
+// deadline is exceeded. A message is never dropped because of a canceled ReceiveContext, it is
+// returned by the next call instead.
+//
+// Encrypted payloads are decrypted, compressed payloads are decompressed and signatures are
+// verified (see WithVerification), a message that cannot be decoded or is refused is returned as a
+// *DecodeError, after which the connection remains usable. Messages rejected by the reject policy
+// are not returned, see WithRejectPolicy.
+//
+// In manual acknowledgement mode (see WithManualAck) the message is acknowledged when returned.
+func (c *Connection) ReceiveContext(ctx context.Context) (*acpb.MessageBody, error) {
+	env, err := c.ReceiveEnvelope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.Ack(); err != nil && !errors.Is(err, ErrAutoAcked) {
+		c.logger.Debug("Error acknowledging message", logKeyMessageID, env.MessageID, "error", err)
+	}
+	return env.Body, nil
+}
+
+// ReceiveEnvelope is like ReceiveContext but returns the message along with the metadata of its
+// delivery, see Envelope. In manual acknowledgement mode the message must be acknowledged with
+// Envelope.Ack or rejected with Envelope.Nack, messages that cannot be decoded are acknowledged
+// before the *DecodeError is returned.
+func (c *Connection) ReceiveEnvelope(ctx context.Context) (*Envelope, error) {
 	select {
-	case msg := <-c.messages:
-		return msg, nil
+	case env := <-c.messages:
+		if env.err != nil {
+			return nil, env.err
+		}
+		c.startAckDeadline(env)
+		return env, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	case <-c.closed:
 		return nil, fmt.Errorf("connection closed with err: %w", c.getCloseErr())
 	}
@@ -359,7 +596,7 @@ func (c *Connection) streamSend(req *acpb.StreamAgentMessagesRequest, streamClos
 	if err := stream.Send(req); err != nil {
 		if err != io.EOF && !errors.Is(err, io.EOF) {
 			// Something is very broken, just close the stream here.
-			loggerPrintf("Unexpected send error, closing connection: %v", err)
+			c.logger.Error("Unexpected send error, closing connection", logKeyMessageID, req.GetMessageId(), "error", err)
 			c.close(err)
 			return err
 		}
@@ -368,7 +605,7 @@ func (c *Connection) streamSend(req *acpb.StreamAgentMessagesRequest, streamClos
 		// for response which will never come and auto retry. acknowledgeMessage will fail and prevent
 		// the message from being passed on to message handlers, allowing recv to handle the stream
 		// close error.
-		loggerPrintf("Error sending message, stream closed.")
+		c.logger.Debug("Error sending message, stream closed", logKeyMessageID, req.GetMessageId())
 		select {
 		case <-streamClosed:
 		default:
@@ -399,10 +636,16 @@ func (c *Connection) send(streamClosed, streamSendLock chan struct{}, stream acp
 	}
 }
 
-func (c *Connection) acknowledgeMessage(messageID string, streamClosed, streamSendLock chan struct{}, stream acpb.AgentCommunication_StreamAgentMessagesClient) error {
+// acknowledgeMessage sends the MessageResponse of a received message, with st as status or OK if
+// st is nil.
+func (c *Connection) acknowledgeMessage(messageID string, st *spb.Status, streamClosed, streamSendLock chan struct{}, stream acpb.AgentCommunication_StreamAgentMessagesClient) error {
+	resp := &acpb.StreamAgentMessagesRequest_MessageResponse{}
+	if st != nil {
+		resp.MessageResponse = &acpb.MessageResponse{Status: st}
+	}
 	ackReq := &acpb.StreamAgentMessagesRequest{
 		MessageId: messageID,
-		Type:      &acpb.StreamAgentMessagesRequest_MessageResponse{},
+		Type:      resp,
 	}
 	select {
 	case <-c.closed:
@@ -415,7 +658,7 @@ func (c *Connection) acknowledgeMessage(messageID string, streamClosed, streamSe
 func (c *Connection) readHeaders(stream acpb.AgentCommunication_StreamAgentMessagesClient) {
 	md, err := stream.Header()
 	if err != nil {
-		loggerPrintf("Error getting stream header: %v", err)
+		c.logger.Warn("Error getting stream header", "error", err)
 		return
 	}
 
@@ -426,33 +669,37 @@ func (c *Connection) readHeaders(stream acpb.AgentCommunication_StreamAgentMessa
 	if len(value) > 0 {
 		rateLimit, err := strconv.Atoi(value[0])
 		if err != nil {
-			loggerPrintf("Error parsing message rate limit: %v", err)
+			c.logger.Warn("Error parsing message rate limit", "value", value[0], "error", err)
 		} else {
 			c.messageRateLimit = rateLimit
-			loggerPrintf("Message rate limit: %d", c.messageRateLimit)
+			c.logger.Debug("Message rate limit", "limit", c.messageRateLimit)
 		}
 	} else {
-		loggerPrintf("No message rate limit")
+		c.logger.Debug("No message rate limit")
 	}
 	value = md.Get(metadataBandwidthLimit)
 	if len(value) > 0 {
 		bandwidthLimit, err := strconv.Atoi(value[0])
 		if err != nil {
-			loggerPrintf("Error parsing message bandwidth limit: %v", err)
+			c.logger.Warn("Error parsing message bandwidth limit", "value", value[0], "error", err)
 		} else {
 			c.messageBandwidthLimit = bandwidthLimit
-			loggerPrintf("Message bandwidth limit: %d", c.messageBandwidthLimit)
+			c.logger.Debug("Message bandwidth limit", "limit", c.messageBandwidthLimit)
 		}
 	} else {
-		loggerPrintf("No message bandwidth limit")
+		c.logger.Debug("No message bandwidth limit")
 	}
+	c.quota.setLimits(c.messageRateLimit, c.messageBandwidthLimit)
 }
 
 // recv keeps receiving and acknowledging new messages.
-func (c *Connection) recv(ctx context.Context, streamClosed, streamSendLock chan struct{}, stream acpb.AgentCommunication_StreamAgentMessagesClient) {
-	loggerPrintf("Receiving messages")
+// generation is the number of the stream, see nextGeneration.
+func (c *Connection) recv(ctx context.Context, generation int, streamClosed, streamSendLock chan struct{}, stream acpb.AgentCommunication_StreamAgentMessagesClient) {
+	logger := c.logger.With(logKeyGeneration, generation)
+	logger.Debug("Receiving messages")
 	for {
 		resp, err := stream.Recv()
+		received := time.Now()
 		if err != nil {
 			select {
 			case <-streamClosed:
@@ -463,19 +710,19 @@ func (c *Connection) recv(ctx context.Context, streamClosed, streamSendLock chan
 			select {
 			case <-c.closed:
 				// Connection is closed, return now.
-				loggerPrintf("Connection closed, recv returning")
+				logger.Debug("Connection closed, recv returning")
 				return
 			default:
 			}
 			st, ok := status.FromError(err)
 			if ok && st.Code() == codes.ResourceExhausted {
-				loggerPrintf("Connection closed due to resource exhausted: %v", err)
+				logger.Warn("Stream closed due to resource exhausted, will reconnect", "error", err)
 			} else if ok && st.Code() == codes.Unavailable {
-				loggerPrintf("Stream returned Unavailable, will reconnect: %v", err)
+				logger.Warn("Stream returned Unavailable, will reconnect", "error", err)
 			} else if err != io.EOF && !errors.Is(err, io.EOF) && (ok && st.Code() != codes.Canceled) && (ok && st.Code() != codes.DeadlineExceeded) {
 				// EOF is a normal stream close, Canceled will be set by the server when stream timeout is
 				// reached, DeadlineExceeded would be because of the client side deadline we set.
-				loggerPrintf("Unexpected error, closing connection: %v", err)
+				logger.Error("Unexpected stream error, closing connection", "error", err)
 				c.close(err)
 				return
 			}
@@ -483,9 +730,13 @@ func (c *Connection) recv(ctx context.Context, streamClosed, streamSendLock chan
 			// 1. Resource exhausted is returned but we have not exceeded the max number of retries.
 			// 2. Unavailable is returned but we have not exceeded the max number of retries.
 			// 3. A known "normal disconnect" error is returned.
-			loggerPrintf("Creating new stream")
-			if err := c.createStream(ctx); err != nil {
-				loggerPrintf("Error creating new stream: %v", err)
+			logger.Warn("Stream closed, reconnecting", "cause", reasonFromError(err))
+			c.setState(StateReconnecting, err, 0)
+			_, span := c.tracer.Start(ctx, "agentcommunication.Reconnect", trace.WithAttributes(attrChannelID.String(c.channelID), attrCause.String(reasonFromError(err))))
+			err := c.createStream(ctx)
+			endSpan(span, err)
+			if err != nil {
+				logger.Error("Error creating new stream", "error", err)
 				c.close(err)
 			}
 			// Always return here, createStream launches a new recv goroutine.
@@ -493,15 +744,54 @@ func (c *Connection) recv(ctx context.Context, streamClosed, streamSendLock chan
 		}
 		switch resp.GetType().(type) {
 		case *acpb.StreamAgentMessagesResponse_MessageBody:
-			// Acknowledge message first, if this ack fails dont forward the message on to the handling
-			// logic since that indicates a stream disconnect.
-			if err := c.acknowledgeMessage(resp.GetMessageId(), streamClosed, streamSendLock, stream); err != nil {
-				loggerPrintf("Error acknowledging message %q: %v", resp.GetMessageId(), err)
+			respond := func(st *spb.Status) error {
+				return c.acknowledgeMessage(resp.GetMessageId(), st, streamClosed, streamSendLock, stream)
+			}
+			logger.Debug("Received message", logKeyMessageID, resp.GetMessageId(), "size", proto.Size(resp.GetMessageBody()))
+			c.metrics.received(proto.Size(resp.GetMessageBody()))
+			msg := c.reassembler.add(resp.GetMessageBody(), logger)
+			if msg == nil {
+				// A fragment of a message that is not complete yet, only the last fragment waits for the
+				// reassembled message to be checked and acknowledged.
+				if err := respond(nil); err != nil {
+					logger.Debug("Error acknowledging fragment", logKeyMessageID, resp.GetMessageId(), "error", err)
+				}
 				continue
 			}
-			c.messages <- resp.GetMessageBody()
+			env := &Envelope{
+				MessageID:  resp.GetMessageId(),
+				ReceivedAt: received,
+				Generation: generation,
+				Transport:  transportName(c.usingVSOCK),
+				Body:       msg,
+			}
+			if decoded, err := c.decodeBody(msg); err != nil {
+				env.err = err
+			} else {
+				env.Body, env.Labels = decoded, decoded.GetLabels()
+			}
+			if st := c.rejectPolicy.check(env); st != nil {
+				reason := rejectReason(st)
+				logger.Warn("Rejecting message", logKeyMessageID, env.MessageID, "reason", reason, "error", status.ErrorProto(st))
+				c.metrics.rejected(reason)
+				if err := respond(st); err != nil {
+					logger.Debug("Error rejecting message", logKeyMessageID, env.MessageID, "error", err)
+				}
+				continue
+			}
+			if c.manualAck && env.err == nil {
+				c.watchAck(env, respond, logger)
+			} else if err := respond(nil); err != nil {
+				// Acknowledge message first, if this ack fails dont forward the message on to the handling
+				// logic since that indicates a stream disconnect. Redelivering a message that cannot be
+				// decoded would not help.
+				logger.Debug("Error acknowledging message", logKeyMessageID, env.MessageID, "error", err)
+				continue
+			}
+			c.messages <- env
 		case *acpb.StreamAgentMessagesResponse_MessageResponse:
 			st := resp.GetMessageResponse().GetStatus()
+			logger.Debug("Received message response", logKeyMessageID, resp.GetMessageId(), "code", codes.Code(st.GetCode()))
 			c.responseMx.Lock()
 			for key, sub := range c.responseSubs {
 				if key != resp.GetMessageId() {
@@ -517,11 +807,24 @@ func (c *Connection) recv(ctx context.Context, streamClosed, streamSendLock chan
 	}
 }
 
-func createStreamLoop(ctx context.Context, client *agentcommunication.Client, resourceID string, channelID string) (acpb.AgentCommunication_StreamAgentMessagesClient, error) {
+func (c *Connection) createStreamLoop(ctx context.Context) (acpb.AgentCommunication_StreamAgentMessagesClient, error) {
+	// Waits between attempts end once ctx is done or the connection is closed. Streams are created
+	// with ctx, which must outlive the loop.
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.closed:
+			cancel()
+		case <-waitCtx.Done():
+		}
+	}()
+	start := time.Now()
+	var attempts []RetryAttempt
 	resourceExhaustedRetries := 0
 	unavailableRetries := 0
 	for {
-		stream, err := client.StreamAgentMessages(ctx)
+		stream, err := c.client.StreamAgentMessages(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("error creating stream: %v", err)
 		}
@@ -530,7 +833,7 @@ func createStreamLoop(ctx context.Context, client *agentcommunication.Client, re
 		req := &acpb.StreamAgentMessagesRequest{
 			MessageId: uuid.New().String(),
 			Type: &acpb.StreamAgentMessagesRequest_RegisterConnection{
-				RegisterConnection: &acpb.RegisterConnection{ResourceId: resourceID, ChannelId: channelID}}}
+				RegisterConnection: &acpb.RegisterConnection{ResourceId: c.resourceID, ChannelId: c.channelID}}}
 
 		if err := stream.Send(req); err != nil {
 			return nil, fmt.Errorf("error sending register connection: %v", err)
@@ -549,58 +852,72 @@ func createStreamLoop(ctx context.Context, client *agentcommunication.Client, re
 			return stream, nil
 		}
 
+		retry := false
+		var delay time.Duration
 		st, ok := status.FromError(err)
 		if ok && st.Code() == codes.ResourceExhausted {
-			if resourceExhaustedRetries <= maxResourceExhaustedRetries {
-				loggerPrintf("Resource exhausted, sleeping before reconnect: %v", err)
-				sleep := time.Duration(resourceExhaustedRetries+1) * time.Second
-				if resourceExhaustedRetries > 9 {
-					sleep = 10 * time.Second
-				}
-				timeSleep(sleep)
+			if resourceExhaustedRetries <= c.maxResourceExhaustedRetries {
 				resourceExhaustedRetries++
-				continue
+				delay = c.resourceExhaustedBackoff.Backoff(resourceExhaustedRetries)
+				retry = true
+				c.logger.Warn("Stream returned ResourceExhausted, will reconnect", "delay", delay, "attempt", resourceExhaustedRetries, "error", err)
+			} else {
+				c.logger.Error("Stream returned ResourceExhausted, exceeded max number of reconnects, closing connection", "error", err)
 			}
-			loggerPrintf("Stream returned ResourceExhausted, exceeded max number of reconnects, closing connection: %v", err)
 		} else if ok && st.Code() == codes.Unavailable {
-			if unavailableRetries <= maxUnavailableRetries {
-				loggerPrintf("Stream returned Unavailable, will reconnect: %v", err)
-				// Sleep for 200ms * num of unavailableRetries, first retry is immediate.
-				timeSleep(time.Duration(unavailableRetries*200) * time.Millisecond)
+			if unavailableRetries <= c.maxUnavailableRetries {
 				unavailableRetries++
-				continue
+				delay = c.unavailableBackoff.Backoff(unavailableRetries)
+				retry = true
+				c.logger.Warn("Stream returned Unavailable, will reconnect", "delay", delay, "attempt", unavailableRetries, "error", err)
+			} else {
+				c.logger.Error("Stream returned Unavailable, exceeded max number of reconnects, closing connection", "error", err)
 			}
-			loggerPrintf("Stream returned Unavailable, exceeded max number of reconnects, closing connection: %v", err)
 		}
-		return nil, err
+		if !retry && len(attempts) == 0 {
+			return nil, err
+		}
+		attempts = append(attempts, RetryAttempt{Err: err, Code: st.Code(), Delay: delay})
+		c.reportAttempt(err, len(attempts))
+		if !retry {
+			return nil, &RetryError{Attempts: attempts}
+		}
+		if !c.withinRetryBudget(start, delay) {
+			c.logger.Error("Exceeded max retry elapsed time, closing connection", "max_elapsed", c.maxRetryElapsedTime, "error", err)
+			return nil, &RetryError{Attempts: attempts}
+		}
+		if err := sleepContext(waitCtx, delay); err != nil {
+			select {
+			case <-c.closed:
+				return nil, fmt.Errorf("connection closed with err: %w", c.getCloseErr())
+			default:
+			}
+			return nil, err
+		}
 	}
 }
 
 func (c *Connection) createStream(ctx context.Context) error {
-	loggerPrintf("Creating stream.")
+	c.logger.Debug("Creating stream")
 
 	md := metadata.New(map[string]string{
 		"agent-communication-channel-id": c.channelID,
 	})
 
-	if c.usingVSOCK {
-		loggerPrintf("Using VSOCK for stream connection.")
-	} else {
+	if !c.usingVSOCK {
 		token, err := getIdentityToken()
 		if err != nil {
 			return fmt.Errorf("%w: %v", ErrGettingInstanceToken, err)
 		}
 		md.Set("authentication", "Bearer "+token)
 		md.Set("agent-communication-resource-id", c.resourceID)
-		loggerPrintf("Using ResourceID %q", c.resourceID)
 	}
-	loggerPrintf("Using ChannelID %q", c.channelID)
 
 	ctx = metadata.NewOutgoingContext(ctx, md)
 
-	// Set a timeout for the stream, this is well above service side timeout.
-	cnclCtx, cancel := context.WithTimeout(ctx, 60*time.Minute)
-	stream, err := createStreamLoop(cnclCtx, c.client, c.resourceID, c.channelID)
+	// Set a timeout for the stream, by default this is well above service side timeout.
+	cnclCtx, cancel := context.WithTimeout(ctx, c.streamTimeout)
+	stream, err := c.createStreamLoop(cnclCtx)
 	if err != nil {
 		cancel()
 		c.close(err)
@@ -615,7 +932,8 @@ func (c *Connection) createStream(ctx context.Context) error {
 	streamClosed := make(chan struct{})
 	// This ensures that only one send is happening at a time.
 	streamSendLock := make(chan struct{}, 1)
-	go c.recv(ctx, streamClosed, streamSendLock, stream)
+	generation := c.nextGeneration()
+	go c.recv(ctx, generation, streamClosed, streamSendLock, stream)
 	go c.send(streamClosed, streamSendLock, stream)
 
 	go func() {
@@ -630,36 +948,96 @@ func (c *Connection) createStream(ctx context.Context) error {
 			}
 		}
 	}()
-	loggerPrintf("Stream established.")
+	c.setState(StateReady, nil, 0)
+	c.logger.Debug("Stream established", logKeyGeneration, generation)
+	if c.outbox != nil {
+		go c.replayOutbox()
+	}
 	return nil
 }
 
+func newConnection(channelID string, opts []ConnectionOption) *Connection {
+	conn := &Connection{
+		channelID:                   channelID,
+		closed:                      make(chan struct{}),
+		responseSubs:                make(map[string]chan *status.Status),
+		stateSubs:                   make(map[int]chan StateEvent),
+		streamReady:                 make(chan struct{}),
+		sends:                       make(chan *acpb.StreamAgentMessagesRequest),
+		keepaliveParams:             defaultKeepaliveParams,
+		timeToWaitForResp:           defaultTimeToWaitForResp,
+		streamTimeout:               defaultStreamTimeout,
+		maxResourceExhaustedRetries: maxResourceExhaustedRetries,
+		maxUnavailableRetries:       maxUnavailableRetries,
+		resourceExhaustedBackoff:    defaultResourceExhaustedBackoff,
+		unavailableBackoff:          defaultUnavailableBackoff,
+		sendBackoff:                 defaultSendBackoff,
+		quota:                       newQuotaLimiter(),
+		clientRateLimiting:          true,
+		tracer:                      noop.NewTracerProvider().Tracer(tracerName),
+		typeResolver:                protoregistry.GlobalTypes,
+		reassemblyTimeout:           defaultReassemblyTimeout,
+		maxMessageSize:              defaultMaxMessageSize,
+		maxClockSkew:                defaultMaxClockSkew,
+		sendWindowSize:              defaultSendWindow,
+		ackDeadline:                 defaultAckDeadline,
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+	if conn.logger == nil {
+		conn.logger = defaultLogger()
+	}
+	conn.logger = conn.logger.With(logKeyChannelID, channelID)
+	conn.reassembler = newReassembler(conn.reassemblyTimeout, conn.maxMessageSize)
+	if conn.signer != nil || conn.trustedKeys != nil {
+		conn.codecs = append(conn.codecs, &signingCodec{
+			signer:  conn.signer,
+			keys:    conn.trustedKeys,
+			maxSkew: conn.maxClockSkew,
+			replay:  &replayCache{maxSize: defaultMaxReplayCacheSize, seen: make(map[string]time.Time)},
+			now:     time.Now,
+		})
+	}
+	conn.codecs = append(conn.codecs, &compressionCodec{algo: conn.compression, threshold: conn.compressionThreshold, maxSize: conn.maxMessageSize})
+	if conn.encryption != nil {
+		conn.codecs = append(conn.codecs, conn.encryption)
+	}
+	conn.messages = make(chan *Envelope, conn.receiveBufferSize)
+	conn.sendWindow = make(chan struct{}, conn.sendWindowSize)
+	conn.replayPending = make(chan struct{}, 1)
+	return conn
+}
+
 // NewConnection creates a new streaming connection.
 // Caller is responsible for calling Close() on the connection when done, certain errors will cause
 // the connection to be closed automatically. The passed in client will not be closed and can be
-// reused.
-func NewConnection(ctx context.Context, channelID string, client *agentcommunication.Client) (*Connection, error) {
-	usingVSOCK := clientUsingVSOCK(client)
-
-	conn := &Connection{
-		channelID:           channelID,
-		closed:              make(chan struct{}),
-		messages:            make(chan *acpb.MessageBody),
-		responseSubs:        make(map[string]chan *status.Status),
-		streamReady:         make(chan struct{}),
-		sends:               make(chan *acpb.StreamAgentMessagesRequest),
-		timeToWaitForResp:   2 * time.Second,
-		client:              client,
-		callerManagedClient: true,
-		usingVSOCK:          usingVSOCK,
+// reused. If client is nil the connection creates its own client (see WithClientOptions and
+// WithKeepaliveParams) and closes it along with the connection.
+func NewConnection(ctx context.Context, channelID string, client *agentcommunication.Client, opts ...ConnectionOption) (*Connection, error) {
+	conn := newConnection(channelID, opts)
+	conn.client = client
+	conn.callerManagedClient = true
+	if client == nil {
+		var err error
+		conn.client, err = newClient(ctx, conn.regional, conn.keepaliveParams, conn.logger, conn.clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		conn.callerManagedClient = false
 	}
+	conn.usingVSOCK = clientUsingVSOCK(conn.client)
+	conn.logger = conn.logger.With(logKeyTransport, transportName(conn.usingVSOCK))
+	conn.initMetrics()
 
 	// VSOCK connections do not require metadata initialization, resource ID is set by the proxy.
-	if !usingVSOCK {
-		if err := metadataInit(); err != nil {
+	if !conn.usingVSOCK {
+		if err := metadataInit(conn.logger); err != nil {
+			conn.close(err)
 			return nil, err
 		}
 		conn.resourceID = getResourceID()
+		conn.logger = conn.logger.With(logKeyResourceID, conn.resourceID)
 	}
 
 	if err := conn.createStream(ctx); err != nil {
@@ -674,20 +1052,12 @@ func NewConnection(ctx context.Context, channelID string, client *agentcommunica
 // NOTE: CreateConnection does not support VSOCK connections.
 // DEPRECATED: Use NewConnection instead.
 func CreateConnection(ctx context.Context, channelID string, regional bool, opts ...option.ClientOption) (*Connection, error) {
-	if err := metadataInit(); err != nil {
+	logger := clientLogger(opts, defaultLogger())
+	if err := metadataInit(logger); err != nil {
 		return nil, err
 	}
 
-	conn := &Connection{
-		channelID:         channelID,
-		closed:            make(chan struct{}),
-		messages:          make(chan *acpb.MessageBody),
-		responseSubs:      make(map[string]chan *status.Status),
-		streamReady:       make(chan struct{}),
-		sends:             make(chan *acpb.StreamAgentMessagesRequest),
-		timeToWaitForResp: 2 * time.Second,
-		usingVSOCK:        false,
-	}
+	conn := newConnection(channelID, []ConnectionOption{WithLogger(logger)})
 
 	var err error
 	conn.client, err = NewClient(ctx, regional, opts...)
@@ -695,6 +1065,8 @@ func CreateConnection(ctx context.Context, channelID string, regional bool, opts
 		return nil, err
 	}
 	conn.resourceID = getResourceID()
+	conn.logger = conn.logger.With(logKeyTransport, transportNetwork, logKeyResourceID, conn.resourceID)
+	conn.initMetrics()
 
 	if err := conn.createStream(ctx); err != nil {
 		conn.close(err)
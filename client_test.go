@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -44,6 +45,7 @@ import (
 	"google.golang.org/protobuf/testing/protocmp"
 	"github.com/mdlayher/vsock"
 
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 	apb "google.golang.org/protobuf/types/known/anypb"
 	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
 )
@@ -103,6 +105,10 @@ type testSrv struct {
 	send          chan *acpb.StreamAgentMessagesResponse
 	recvErr       chan error
 	persistentErr error
+	// Status used to ack messages, OK if nil.
+	ackStatus *spb.Status
+	// Overrides ackStatus if set.
+	ackFunc func(*acpb.MessageBody) *spb.Status
 }
 
 func newTestSrv(*grpc.Server) *testSrv {
@@ -157,11 +163,21 @@ func (s *testSrv) StreamAgentMessages(stream acpb.AgentCommunication_StreamAgent
 			s.req = append(s.req, rec)
 			s.reqMx.Unlock()
 
+			var ack *acpb.MessageResponse
 			switch rec.GetType().(type) {
 			case *acpb.StreamAgentMessagesRequest_MessageResponse:
 				continue
+			case *acpb.StreamAgentMessagesRequest_MessageBody:
+				s.reqMx.Lock()
+				if s.ackStatus != nil {
+					ack = &acpb.MessageResponse{Status: s.ackStatus}
+				}
+				if s.ackFunc != nil {
+					ack = &acpb.MessageResponse{Status: s.ackFunc(rec.GetMessageBody())}
+				}
+				s.reqMx.Unlock()
 			}
-			if err := stream.Send(&acpb.StreamAgentMessagesResponse{MessageId: rec.GetMessageId(), Type: &acpb.StreamAgentMessagesResponse_MessageResponse{}}); err != nil {
+			if err := stream.Send(&acpb.StreamAgentMessagesResponse{MessageId: rec.GetMessageId(), Type: &acpb.StreamAgentMessagesResponse_MessageResponse{MessageResponse: ack}}); err != nil {
 				log.Printf("Server Send: %v\n", err)
 				s.recvErr <- err
 				return
@@ -232,10 +248,12 @@ func createTestSrv(t *testing.T) (*testSrv, *grpc.ClientConn, error) {
 func createTestSrvVSOCK(t *testing.T) (*testSrv, error) {
 	t.Helper()
 	DefaultAllowVSOCK = true
-	vsockAvailable = func() bool { return true }
+	available := vsockAvailable
+	vsockAvailable = func(*slog.Logger) bool { return true }
 	vsockTarget = fmt.Sprintf("passthrough:%d:%d", vsock.Local, vsockPort)
 	t.Cleanup(func() {
 		DefaultAllowVSOCK = false
+		vsockAvailable = available
 	})
 
 	lis, err := vsock.ListenContextID(vsock.Local, vsockPort, nil)
@@ -270,8 +288,8 @@ func createTestSrvVSOCK(t *testing.T) (*testSrv, error) {
 
 func TestGetEndpoint(t *testing.T) {
 	metadataInited = false
-	if err := metadataInit(); err != nil {
-		t.Fatalf("metadataInit() failed: %v", err)
+	if err := metadataInit(defaultLogger()); err != nil {
+		t.Fatalf("metadataInit(defaultLogger()) failed: %v", err)
 	}
 
 	tests := []struct {
@@ -385,10 +403,10 @@ func TestMetadataInit(t *testing.T) {
 		}
 		return mdData, nil
 	}
-	metadataInit()
+	metadataInit(defaultLogger())
 	defer func() {
 		MetadataInitFunc = initGCEMetadata
-		metadataInit()
+		metadataInit(defaultLogger())
 	}()
 
 	client, err := NewClient(ctx, false, option.WithGRPCConn(cc))
@@ -422,7 +440,7 @@ func TestMetadataInit(t *testing.T) {
 	}
 }
 
-func newTestConnection(ctx context.Context, t *testing.T) (*testSrv, *Connection, error) {
+func newTestConnection(ctx context.Context, t *testing.T, opts ...ConnectionOption) (*testSrv, *Connection, error) {
 	metadataInitMx.Lock()
 	metadataInited = false
 	metadataInitMx.Unlock()
@@ -435,7 +453,7 @@ func newTestConnection(ctx context.Context, t *testing.T) (*testSrv, *Connection
 	if err != nil {
 		return nil, nil, err
 	}
-	conn, err := NewConnection(ctx, testChannelID, client)
+	conn, err := NewConnection(ctx, testChannelID, client, opts...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -481,6 +499,58 @@ func TestNewConnection(t *testing.T) {
 	}
 }
 
+func TestNewConnectionOptions(t *testing.T) {
+	ctx := context.Background()
+	metadataInitMx.Lock()
+	metadataInited = false
+	metadataInitMx.Unlock()
+	srv, cc, err := createTestSrv(t)
+	if err != nil {
+		t.Fatalf("createTestSrv() failed: %v", err)
+	}
+
+	// The connection creates and owns its client.
+	conn, err := NewConnection(ctx, testChannelID, nil,
+		WithClientOptions(false, option.WithGRPCConn(cc)),
+		WithAckTimeout(time.Second),
+		WithStreamLifetime(time.Minute),
+		WithMaxResourceExhaustedRetries(1),
+		WithMaxUnavailableRetries(2),
+		WithReceiveBufferSize(2),
+	)
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+	defer conn.Close()
+
+	if conn.callerManagedClient {
+		t.Errorf("conn.callerManagedClient = true, want false")
+	}
+	if conn.timeToWaitForResp != time.Second {
+		t.Errorf("conn.timeToWaitForResp = %v, want %v", conn.timeToWaitForResp, time.Second)
+	}
+	if conn.streamTimeout != time.Minute {
+		t.Errorf("conn.streamTimeout = %v, want %v", conn.streamTimeout, time.Minute)
+	}
+	if conn.maxResourceExhaustedRetries != 1 || conn.maxUnavailableRetries != 2 {
+		t.Errorf("conn retries = (%d, %d), want (1, 2)", conn.maxResourceExhaustedRetries, conn.maxUnavailableRetries)
+	}
+	// Package defaults should not be affected by options.
+	if maxResourceExhaustedRetries != 20 || maxUnavailableRetries != 5 {
+		t.Errorf("package retries = (%d, %d), want (20, 5)", maxResourceExhaustedRetries, maxUnavailableRetries)
+	}
+
+	// With a receive buffer both messages are read from the stream before Receive is called.
+	body := &acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}}
+	srv.send <- &acpb.StreamAgentMessagesResponse{MessageId: "1", Type: &acpb.StreamAgentMessagesResponse_MessageBody{MessageBody: body}}
+	srv.send <- &acpb.StreamAgentMessagesResponse{MessageId: "2", Type: &acpb.StreamAgentMessagesResponse_MessageBody{MessageBody: body}}
+	// Register plus two acks.
+	waitForRequests(t, srv, 3)
+	if got := len(conn.messages); got != 2 {
+		t.Errorf("len(conn.messages) = %d, want 2", got)
+	}
+}
+
 func TestNewConnectionErrors(t *testing.T) {
 	metadataInitMx.Lock()
 	metadataInited = false
@@ -615,16 +685,13 @@ func TestNewConnection_VSOCK(t *testing.T) {
 func TestNewConnection_ExceededRetries(t *testing.T) {
 	oldMaxResource := maxResourceExhaustedRetries
 	oldMaxUnavailable := maxUnavailableRetries
-	oldSleep := timeSleep
 	maxResourceExhaustedRetries = 2
 	maxUnavailableRetries = 2
-	timeSleep = func(d time.Duration) {}
 
 	// Restore the old values after the test is done.
 	defer func() {
 		maxResourceExhaustedRetries = oldMaxResource
 		maxUnavailableRetries = oldMaxUnavailable
-		timeSleep = oldSleep
 	}()
 
 	tests := []struct {
@@ -658,17 +725,81 @@ func TestNewConnection_ExceededRetries(t *testing.T) {
 				t.Fatalf("NewClient() failed: %v", err)
 			}
 
-			_, err = NewConnection(ctx, testChannelID, client)
+			_, err = NewConnection(ctx, testChannelID, client, WithReconnectBackoff(ConstantBackoff{}))
 			if err == nil {
 				t.Fatal("NewConnection() expected to fail due to exceeded retries")
 			}
 			if !strings.Contains(err.Error(), tc.name) {
 				t.Errorf("Unexpected error message: %v", err)
 			}
+			var retryErr *RetryError
+			if !errors.As(err, &retryErr) {
+				t.Fatalf("NewConnection() error = %v, want *RetryError", err)
+			}
+			// Initial attempt plus maxRetries + 1 retries.
+			if len(retryErr.Attempts) != 4 {
+				t.Errorf("len(RetryError.Attempts) = %d, want 4", len(retryErr.Attempts))
+			}
+			for i, a := range retryErr.Attempts {
+				if a.Code != tc.code {
+					t.Errorf("RetryError.Attempts[%d].Code = %v, want %v", i, a.Code, tc.code)
+				}
+			}
 		})
 	}
 }
 
+func TestNewConnection_CanceledDuringBackoff(t *testing.T) {
+	srv, cc, err := createTestSrv(t)
+	if err != nil {
+		t.Fatalf("createTestSrv() failed: %v", err)
+	}
+	srv.reqMx.Lock()
+	srv.persistentErr = status.Error(codes.Unavailable, "unavailable")
+	srv.reqMx.Unlock()
+	client, err := NewClient(context.Background(), false, option.WithGRPCConn(cc))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = NewConnection(ctx, testChannelID, client, WithReconnectBackoff(ConstantBackoff{Delay: time.Hour}))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("NewConnection() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("NewConnection() returned after %v, want the backoff interrupted by the context", elapsed)
+	}
+}
+
+func TestNewConnection_MaxRetryElapsedTime(t *testing.T) {
+	ctx := context.Background()
+	srv, cc, err := createTestSrv(t)
+	if err != nil {
+		t.Fatalf("createTestSrv() failed: %v", err)
+	}
+	srv.reqMx.Lock()
+	srv.persistentErr = status.Error(codes.Unavailable, "Unavailable")
+	srv.reqMx.Unlock()
+
+	client, err := NewClient(ctx, false, option.WithGRPCConn(cc))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+
+	// The first backoff exceeds the budget, so no retry should happen.
+	_, err = NewConnection(ctx, testChannelID, client, WithReconnectBackoff(ConstantBackoff{Delay: time.Hour}), WithMaxRetryElapsedTime(time.Minute))
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("NewConnection() error = %v, want *RetryError", err)
+	}
+	if len(retryErr.Attempts) != 1 {
+		t.Errorf("len(RetryError.Attempts) = %d, want 1", len(retryErr.Attempts))
+	}
+}
+
 func TestSendMessage(t *testing.T) {
 	ctx := context.Background()
 	srv, conn, err := newTestConnection(ctx, t)
@@ -692,6 +823,81 @@ func TestSendMessage(t *testing.T) {
 	}
 }
 
+func TestSendMessageContext(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: []byte("test-body")}}
+	if err := conn.SendMessageContext(ctx, msg, WithSendLabels(map[string]string{"extra": "label"}), WithSendRetries(0), WithSendAckTimeout(time.Second)); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	waitForRequests(t, srv, 2)
+
+	wantMsg := &acpb.MessageBody{Labels: map[string]string{"key": "value", "extra": "label"}, Body: &apb.Any{Value: []byte("test-body")}}
+	wantReq := &acpb.StreamAgentMessagesRequest{Type: &acpb.StreamAgentMessagesRequest_MessageBody{MessageBody: wantMsg}}
+	srv.reqMx.Lock()
+	gotReq := srv.req[1]
+	srv.reqMx.Unlock()
+	if diff := cmp.Diff(wantReq, gotReq, protocmp.Transform(), protocmp.IgnoreFields(&acpb.StreamAgentMessagesRequest{}, "message_id")); diff != "" {
+		t.Errorf("srv.req[1] diff (-want +got):\n%s", diff)
+	}
+	// The caller's message should not be modified.
+	if len(msg.GetLabels()) != 1 {
+		t.Errorf("msg.Labels = %v, want 1 label", msg.GetLabels())
+	}
+
+	cnclCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := conn.SendMessageContext(cnclCtx, msg); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendMessageContext() with canceled context returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestSendMessage_ExceededRetries(t *testing.T) {
+	ctx := context.Background()
+	metadataInitMx.Lock()
+	metadataInited = false
+	metadataInitMx.Unlock()
+	srv, cc, err := createTestSrv(t)
+	if err != nil {
+		t.Fatalf("createTestSrv() failed: %v", err)
+	}
+	client, err := NewClient(ctx, false, option.WithGRPCConn(cc))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	conn, err := NewConnection(ctx, testChannelID, client, WithSendBackoff(ConstantBackoff{Delay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+	defer conn.Close()
+
+	srv.reqMx.Lock()
+	srv.ackStatus = &spb.Status{Code: int32(codes.ResourceExhausted), Message: "slow down"}
+	srv.reqMx.Unlock()
+
+	msg := &acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}}
+	err = conn.SendMessageContext(ctx, msg, WithSendRetries(2))
+	if !errors.Is(err, ErrResourceExhausted) {
+		t.Fatalf("SendMessageContext() = %v, want %v", err, ErrResourceExhausted)
+	}
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("SendMessageContext() = %v, want *RetryError", err)
+	}
+	if len(retryErr.Attempts) != 3 {
+		t.Fatalf("len(RetryError.Attempts) = %d, want 3", len(retryErr.Attempts))
+	}
+	for i, a := range retryErr.Attempts {
+		if a.Code != codes.ResourceExhausted || a.Delay != time.Millisecond {
+			t.Errorf("RetryError.Attempts[%d] = %+v, want code %v and delay %v", i, a, codes.ResourceExhausted, time.Millisecond)
+		}
+	}
+}
+
 func TestSendMessage_VSOCK(t *testing.T) {
 	ctx := context.Background()
 
@@ -924,6 +1130,31 @@ func TestReceive(t *testing.T) {
 	}
 }
 
+func TestReceiveContext(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	tCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := conn.ReceiveContext(tCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReceiveContext() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The connection should still be usable after a canceled receive.
+	body := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: []byte("test-body")}}
+	srv.send <- &acpb.StreamAgentMessagesResponse{MessageId: "test-message-id", Type: &acpb.StreamAgentMessagesResponse_MessageBody{MessageBody: body}}
+	msg, err := conn.ReceiveContext(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveContext() failed: %v", err)
+	}
+	if diff := cmp.Diff(body, msg, protocmp.Transform()); diff != "" {
+		t.Errorf("ReceiveContext() diff (-want +got):\n%s", diff)
+	}
+}
+
 func TestGetIdentityToken(t *testing.T) {
 	// Setup Token
 	future := time.Now().Add(time.Hour)
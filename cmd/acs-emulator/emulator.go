@@ -0,0 +1,201 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	client "github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/GoogleCloudPlatform/agentcommunication_client/acstest"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// emulator serves AgentCommunication with an acstest.Server, routing SendAgentMessage calls to
+// the connected agents.
+type emulator struct {
+	srv          *acstest.Server
+	replyTimeout time.Duration
+}
+
+func newEmulator(messageRateLimit, bandwidthLimit int, replyTimeout time.Duration, history int) *emulator {
+	e := &emulator{srv: acstest.NewServer(), replyTimeout: replyTimeout}
+	e.srv.SetHistoryLimit(history)
+	e.srv.SetRateLimits(messageRateLimit, bandwidthLimit)
+	e.srv.EnforceRateLimits(true)
+	e.srv.HandleSendAgentMessage(e.sendAgentMessage)
+	return e
+}
+
+// firstOf returns the first non empty value.
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// sendAgentMessage pushes the request's message to the stream connected for its resource and
+// channel as a call request, and returns the response the agent sends for that call as the reply.
+// An error response is returned as the error of the call.
+func (e *emulator) sendAgentMessage(ctx context.Context, req *acpb.SendAgentMessageRequest) (*acpb.SendAgentMessageResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := acstest.StreamKey{
+		ResourceID: firstOf(req.GetResourceId(), append(md.Get("agent-communication-resource-id"), "")[0]),
+		ChannelID:  firstOf(req.GetChannelId(), append(md.Get("agent-communication-channel-id"), "")[0]),
+	}
+	if !slices.Contains(e.srv.Streams(), key) {
+		return nil, status.Errorf(codes.NotFound, "no agent connected for resource %q on channel %q", key.ResourceID, key.ChannelID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.replyTimeout)
+	defer cancel()
+	id := uuid.New().String()
+	msg := proto.Clone(req.GetMessageBody()).(*acpb.MessageBody)
+	if msg.Labels == nil {
+		msg.Labels = make(map[string]string)
+	}
+	msg.Labels[client.RPCIDLabel] = id
+	msg.Labels[client.RPCKindLabel] = client.RPCKindRequest
+	if _, err := e.srv.PushTo(ctx, key, msg); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	reply, err := e.srv.WaitForMessage(ctx, func(m *acstest.Message) bool {
+		kind := m.Body.GetLabels()[client.RPCKindLabel]
+		return m.StreamKey == key && m.Body.GetLabels()[client.RPCIDLabel] == id && (kind == client.RPCKindResponse || kind == client.RPCKindError)
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.DeadlineExceeded, "no reply from agent for resource %q on channel %q: %v", key.ResourceID, key.ChannelID, err)
+	}
+	body := proto.Clone(reply.Body).(*acpb.MessageBody)
+	if body.GetLabels()[client.RPCKindLabel] == client.RPCKindError {
+		st := &spb.Status{}
+		if err := body.GetBody().UnmarshalTo(st); err != nil {
+			return nil, status.Errorf(codes.Unknown, "malformed error reply from agent: %v", err)
+		}
+		return nil, status.ErrorProto(st)
+	}
+	delete(body.Labels, client.RPCIDLabel)
+	delete(body.Labels, client.RPCKindLabel)
+	return &acpb.SendAgentMessageResponse{MessageBody: body}, nil
+}
+
+// connectionJSON is a connected stream in the control API.
+type connectionJSON struct {
+	ResourceID string `json:"resource_id"`
+	ChannelID  string `json:"channel_id"`
+}
+
+// messageJSON is a message sent by an agent in the control API, the body is a MessageBody in
+// protojson.
+type messageJSON struct {
+	connectionJSON
+	MessageID   string          `json:"message_id"`
+	MessageBody json.RawMessage `json:"message_body"`
+}
+
+// controlHandler returns the HTTP control API:
+//
+//	GET  /v1/connections                               lists the connected streams
+//	GET  /v1/sent                                      lists the messages sent by agents
+//	POST /v1/messages?channel_id=...[&resource_id=...] sends the MessageBody (protojson) in the
+//	                                                   request body to an agent
+func (e *emulator) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/connections", e.listConnections)
+	mux.HandleFunc("GET /v1/sent", e.listSent)
+	mux.HandleFunc("POST /v1/messages", e.injectMessage)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *emulator) listConnections(w http.ResponseWriter, _ *http.Request) {
+	conns := []connectionJSON{}
+	for _, key := range e.srv.Streams() {
+		conns = append(conns, connectionJSON{ResourceID: key.ResourceID, ChannelID: key.ChannelID})
+	}
+	writeJSON(w, conns)
+}
+
+func (e *emulator) listSent(w http.ResponseWriter, _ *http.Request) {
+	msgs := []messageJSON{}
+	for _, m := range e.srv.Sent() {
+		body, err := protojson.Marshal(m.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		msgs = append(msgs, messageJSON{
+			connectionJSON: connectionJSON{ResourceID: m.ResourceID, ChannelID: m.ChannelID},
+			MessageID:      m.MessageID,
+			MessageBody:    body,
+		})
+	}
+	writeJSON(w, msgs)
+}
+
+func (e *emulator) injectMessage(w http.ResponseWriter, r *http.Request) {
+	channelID := r.URL.Query().Get("channel_id")
+	if channelID == "" {
+		http.Error(w, "channel_id is required", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg := &acpb.MessageBody{}
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		http.Error(w, fmt.Sprintf("invalid MessageBody: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Do not wait for an agent to connect.
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+	var id string
+	if resourceID := r.URL.Query().Get("resource_id"); resourceID != "" {
+		id, err = e.srv.PushTo(ctx, acstest.StreamKey{ResourceID: resourceID, ChannelID: channelID}, msg)
+	} else {
+		id, err = e.srv.Push(ctx, channelID, msg)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("no agent connected on channel %q: %v", channelID, err), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]string{"message_id": id})
+}
@@ -0,0 +1,206 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/GoogleCloudPlatform/agentcommunication_client/acstest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const testChannelID = "test-channel"
+
+// startAgent connects an agent to e.
+func startAgent(t *testing.T, ctx context.Context, e *emulator) *client.Connection {
+	t.Helper()
+	c, err := e.srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	conn, err := client.NewConnection(ctx, testChannelID, c, client.WithClientRateLimiting(false))
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+// reply responds to the calls received by conn with their body reversed, and to "silent" with no
+// reply at all. Each call first sends an unrelated message, which must not be taken as the reply.
+func reply(ctx context.Context, conn *client.Connection) {
+	responder := client.NewResponder(conn, func(ctx context.Context, req *acpb.MessageBody) (*acpb.MessageBody, error) {
+		conn.SendMessageContext(ctx, &acpb.MessageBody{Labels: map[string]string{"telemetry": "true"}, Body: &apb.Any{}})
+		body := []byte(req.GetBody().GetValue())
+		if string(body) == "silent" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		for i, j := 0, len(body)-1; i < j; i, j = i+1, j-1 {
+			body[i], body[j] = body[j], body[i]
+		}
+		return &acpb.MessageBody{Body: &apb.Any{Value: body}}, nil
+	})
+	client.ReceiveLoop(ctx, conn, nil, responder)
+}
+
+func TestSendAgentMessage(t *testing.T) {
+	ctx := context.Background()
+	e := newEmulator(0, 0, time.Second, 100)
+	defer e.srv.Close()
+	caller, err := e.srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer caller.Close()
+
+	msg := &acpb.MessageBody{Body: &apb.Any{Value: []byte("ping")}}
+	if _, err := client.SendAgentMessage(ctx, testChannelID, caller, msg); status.Code(err) != codes.NotFound {
+		t.Errorf("SendAgentMessage() without agent = %v, want NotFound", err)
+	}
+
+	conn := startAgent(t, ctx, e)
+	agentCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go reply(agentCtx, conn)
+
+	// Concurrent calls each get the reply to their own message.
+	values := []string{"ping", "pong", "abc"}
+	errs := make(chan error, len(values))
+	for _, v := range values {
+		go func() {
+			resp, err := client.SendAgentMessage(ctx, testChannelID, caller, &acpb.MessageBody{Body: &apb.Any{Value: []byte(v)}})
+			if err != nil {
+				errs <- err
+				return
+			}
+			want := []rune(v)
+			slices.Reverse(want)
+			if got := string(resp.GetMessageBody().GetBody().GetValue()); got != string(want) || len(resp.GetMessageBody().GetLabels()) != 0 {
+				errs <- fmt.Errorf("SendAgentMessage(%q) = %v, want %q without labels", v, resp.GetMessageBody(), string(want))
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for range values {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The agent does not reply.
+	silent := &acpb.MessageBody{Body: &apb.Any{Value: []byte("silent")}}
+	if _, err := client.SendAgentMessage(ctx, testChannelID, caller, silent); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("SendAgentMessage() without reply = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	e := newEmulator(1, 0, time.Second, 100)
+	defer e.srv.Close()
+	conn := startAgent(t, ctx, e)
+	if conn.MessageRateLimit() != 1 {
+		t.Errorf("MessageRateLimit() = %d, want 1", conn.MessageRateLimit())
+	}
+
+	msg := &acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}}
+	if err := conn.SendMessageContext(ctx, msg); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	if err := conn.SendMessageContext(ctx, msg, client.WithSendRetries(0)); !errors.Is(err, client.ErrResourceExhausted) {
+		t.Errorf("SendMessageContext() over quota = %v, want ErrResourceExhausted", err)
+	}
+}
+
+func TestControlAPI(t *testing.T) {
+	ctx := context.Background()
+	e := newEmulator(0, 0, time.Second, 100)
+	defer e.srv.Close()
+	conn := startAgent(t, ctx, e)
+	ts := httptest.NewServer(e.controlHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/connections")
+	if err != nil {
+		t.Fatalf("GET /v1/connections failed: %v", err)
+	}
+	var conns []connectionJSON
+	if err := json.NewDecoder(resp.Body).Decode(&conns); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	resp.Body.Close()
+	if want := (connectionJSON{ResourceID: acstest.ResourceID, ChannelID: testChannelID}); len(conns) != 1 || conns[0] != want {
+		t.Errorf("GET /v1/connections = %v, want [%v]", conns, want)
+	}
+
+	body := `{"labels": {"key": "value"}}`
+	resp, err = http.Post(ts.URL+"/v1/messages?channel_id="+testChannelID, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /v1/messages failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /v1/messages status = %d, want 200", resp.StatusCode)
+	}
+	msg, err := conn.Receive()
+	if err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+	if msg.GetLabels()["key"] != "value" {
+		t.Errorf("Receive() = %v, want the injected message", msg)
+	}
+
+	resp, err = http.Post(ts.URL+"/v1/messages?channel_id=other-channel", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /v1/messages failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("POST /v1/messages without agent status = %d, want 404", resp.StatusCode)
+	}
+
+	if err := conn.SendMessage(&acpb.MessageBody{Labels: map[string]string{"sent": "true"}}); err != nil {
+		t.Fatalf("SendMessage() failed: %v", err)
+	}
+	resp, err = http.Get(ts.URL + "/v1/sent")
+	if err != nil {
+		t.Fatalf("GET /v1/sent failed: %v", err)
+	}
+	var sent []messageJSON
+	if err := json.NewDecoder(resp.Body).Decode(&sent); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	resp.Body.Close()
+	if len(sent) != 1 || !strings.Contains(string(sent[0].MessageBody), `"sent"`) {
+		t.Errorf("GET /v1/sent = %v, want the sent message", sent)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// acs-emulator is a local AgentCommunication service for development and CI.
+//
+// It serves the AgentCommunication gRPC API in plaintext over TCP and/or a unix socket. Agents
+// register streams as with the real service, SendAgentMessage calls are delivered to the agent
+// connected for the request's resource and channel as a call of the client package's RPC protocol
+// (see client.Caller), and the response the agent sends for that call, for example with
+// client.Responder, is returned as the reply. Message rate and bandwidth limits are advertised in
+// the stream headers and enforced.
+//
+// A small HTTP control API lists connections and sent messages and injects messages, for
+// example:
+//
+//	curl localhost:8791/v1/connections
+//	curl -d '{"body": {"@type": "type.googleapis.com/google.protobuf.StringValue", "value": "hi"}}' \
+//	  'localhost:8791/v1/messages?channel_id=my-channel'
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	tcpAddr = flag.String("tcp", "localhost:8790",
+		"TCP address to serve the gRPC API on, empty to disable")
+	unixSocket = flag.String("unix", "",
+		"unix socket path to serve the gRPC API on, empty to disable")
+	controlAddr = flag.String("control", "localhost:8791",
+		"TCP address to serve the HTTP control API on, empty to disable")
+	messageRateLimit = flag.Int("message-rate-limit", 0,
+		"message rate limit in messages/minute, 0 for no limit")
+	bandwidthLimit = flag.Int("bandwidth-limit", 0,
+		"bandwidth limit in bytes/minute, 0 for no limit")
+	replyTimeout = flag.Duration("reply-timeout", 30*time.Second,
+		"how long SendAgentMessage waits for the agent's reply")
+	history = flag.Int("history", 10000,
+		"sent messages and acknowledgements remembered for the control API, 0 for no limit")
+)
+
+func serve(e *emulator, network, addr string) {
+	lis, err := net.Listen(network, addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Serving AgentCommunication on %s %s", network, lis.Addr())
+	go func() {
+		if err := e.srv.Serve(lis); err != nil {
+			log.Printf("Serving on %s %s: %v", network, addr, err)
+		}
+	}()
+}
+
+func main() {
+	flag.Parse()
+	if *tcpAddr == "" && *unixSocket == "" {
+		log.Fatal("one of -tcp or -unix is required")
+	}
+
+	e := newEmulator(*messageRateLimit, *bandwidthLimit, *replyTimeout, *history)
+	defer e.srv.Close()
+	if *tcpAddr != "" {
+		serve(e, "tcp", *tcpAddr)
+	}
+	if *unixSocket != "" {
+		// Remove a stale socket from a previous run.
+		os.Remove(*unixSocket)
+		defer os.Remove(*unixSocket)
+		serve(e, "unix", *unixSocket)
+	}
+	if *controlAddr != "" {
+		log.Printf("Serving control API on %s", *controlAddr)
+		go func() {
+			if err := http.ListenAndServe(*controlAddr, e.controlHandler()); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	log.Print("Shutting down")
+}
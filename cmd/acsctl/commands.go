@@ -0,0 +1,382 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	client "github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/GoogleCloudPlatform/agentcommunication_client/gapic"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+
+	// Register the well known types for -type-url.
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// An unsigned identity token expiring in 2100, sent with -plaintext when -token is not set. Local
+// test servers do not verify it.
+var fakeIDToken = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+	base64.RawURLEncoding.EncodeToString([]byte(`{"exp":4102444800}`)) + "."
+
+// commonFlags are the connection flags shared by all commands.
+type commonFlags struct {
+	channel    string
+	endpoint   string
+	plaintext  bool
+	regional   bool
+	vsock      bool
+	zone       string
+	resourceID string
+	token      string
+	timeout    time.Duration
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cf := &commonFlags{}
+	fs.StringVar(&cf.channel, "channel", "", "channel ID (required)")
+	fs.StringVar(&cf.endpoint, "endpoint", "", "endpoint override, host:port or unix:///path")
+	fs.BoolVar(&cf.plaintext, "plaintext", false, "connect to -endpoint without TLS and a fake identity token unless -token is set, for local test servers")
+	fs.BoolVar(&cf.regional, "regional", false, "use the regional instead of the zonal endpoint")
+	fs.BoolVar(&cf.vsock, "vsock", false, "allow connecting over VSOCK when available")
+	fs.StringVar(&cf.zone, "zone", "", "zone override, instead of reading it from the metadata server")
+	fs.StringVar(&cf.resourceID, "resource-id", "", "resource ID override, instead of reading it from the metadata server")
+	fs.StringVar(&cf.token, "token", "", "identity token override, instead of reading it from the metadata server")
+	fs.DurationVar(&cf.timeout, "timeout", 30*time.Second, "timeout of send and call")
+	return fs, cf
+}
+
+func (cf *commonFlags) validate() error {
+	if cf.channel == "" {
+		return errors.New("-channel is required")
+	}
+	if cf.plaintext && cf.endpoint == "" {
+		return errors.New("-plaintext requires -endpoint")
+	}
+	return nil
+}
+
+// newClient creates a client as configured by the flags.
+func (cf *commonFlags) newClient(ctx context.Context) (*agentcommunication.Client, error) {
+	if cf.vsock {
+		client.DefaultAllowVSOCK = true
+	}
+	if cf.zone != "" || cf.resourceID != "" || cf.token != "" || cf.plaintext {
+		client.MetadataInitFunc = cf.metadataInit(client.MetadataInitFunc)
+	}
+	var opts []option.ClientOption
+	var cc *grpc.ClientConn
+	switch {
+	case cf.plaintext:
+		var err error
+		cc, err = grpc.NewClient(cf.endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithGRPCConn(cc))
+	case cf.endpoint != "":
+		opts = append(opts, option.WithEndpoint(cf.endpoint))
+	}
+	c, err := client.NewClient(ctx, cf.regional, opts...)
+	if err != nil {
+		if cc != nil {
+			cc.Close()
+		}
+		return nil, err
+	}
+	// The client closes cc with its connection pool.
+	return c, nil
+}
+
+// metadataInit returns a client.MetadataInitFunc overriding the values read by base with the flags
+// that are set. base is only called if some values are not overridden.
+func (cf *commonFlags) metadataInit(base func() (*client.MetadataInitData, error)) func() (*client.MetadataInitData, error) {
+	return func() (*client.MetadataInitData, error) {
+		// The universe domain the client defaults to, when no metadata server is read.
+		data := &client.MetadataInitData{UniverseDomain: "googleapis.com"}
+		if cf.zone == "" || cf.resourceID == "" || (cf.token == "" && !cf.plaintext) {
+			var err error
+			if data, err = base(); err != nil || data == nil {
+				return data, err
+			}
+		}
+		if cf.zone != "" {
+			data.Zone = cf.zone
+		}
+		if cf.resourceID != "" {
+			data.ResourceID = cf.resourceID
+		}
+		switch {
+		case cf.token != "":
+			token := cf.token
+			data.TokenGetter = func() (string, error) { return token, nil }
+		case cf.plaintext:
+			data.TokenGetter = func() (string, error) { return fakeIDToken, nil }
+		}
+		return data, nil
+	}
+}
+
+// labelsFlag collects repeated -label key=value flags.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	var kvs []string
+	for k, v := range l {
+		kvs = append(kvs, k+"="+v)
+	}
+	return strings.Join(kvs, ",")
+}
+
+func (l labelsFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("label %q is not key=value", s)
+	}
+	l[k] = v
+	return nil
+}
+
+// messageFlags describe the message to send.
+type messageFlags struct {
+	data    string
+	file    string
+	typeURL string
+	format  string
+	labels  labelsFlag
+}
+
+func addMessageFlags(fs *flag.FlagSet) *messageFlags {
+	mf := &messageFlags{labels: labelsFlag{}}
+	fs.StringVar(&mf.data, "data", "", "payload, read from -file or stdin if empty")
+	fs.StringVar(&mf.file, "file", "", `file to read the payload from, "-" for stdin`)
+	fs.StringVar(&mf.typeURL, "type-url", "", "type URL of the payload, required for -format json and text")
+	fs.StringVar(&mf.format, "format", "raw", "payload format: raw (sent as is), json or text (proto JSON or text of -type-url)")
+	fs.Var(mf.labels, "label", "message label as key=value, can be repeated")
+	return mf
+}
+
+// build returns the MessageBody described by the flags.
+func (mf *messageFlags) build(stdin io.Reader) (*acpb.MessageBody, error) {
+	var payload []byte
+	var err error
+	switch {
+	case mf.data != "":
+		payload = []byte(mf.data)
+	case mf.file != "" && mf.file != "-":
+		payload, err = os.ReadFile(mf.file)
+	default:
+		payload, err = io.ReadAll(stdin)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading payload: %w", err)
+	}
+
+	value := payload
+	switch mf.format {
+	case "raw":
+	case "json", "text":
+		if mf.typeURL == "" {
+			return nil, fmt.Errorf("-format %s requires -type-url", mf.format)
+		}
+		mt, err := protoregistry.GlobalTypes.FindMessageByURL(mf.typeURL)
+		if err != nil {
+			return nil, fmt.Errorf("unknown -type-url %q: %w", mf.typeURL, err)
+		}
+		m := mt.New().Interface()
+		if mf.format == "json" {
+			err = protojson.Unmarshal(payload, m)
+		} else {
+			err = prototext.Unmarshal(payload, m)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing payload as %s: %w", mf.typeURL, err)
+		}
+		if value, err = proto.Marshal(m); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown -format %q", mf.format)
+	}
+
+	msg := &acpb.MessageBody{Body: &apb.Any{TypeUrl: mf.typeURL, Value: value}}
+	if len(mf.labels) > 0 {
+		msg.Labels = mf.labels
+	}
+	return msg, nil
+}
+
+// messageJSON is the JSON output for a message. Body holds the decoded payload if its type is
+// known, Value always holds the raw payload.
+type messageJSON struct {
+	Labels  map[string]string `json:"labels,omitempty"`
+	TypeURL string            `json:"type_url,omitempty"`
+	Value   []byte            `json:"value,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+func toJSON(msg *acpb.MessageBody) messageJSON {
+	out := messageJSON{
+		Labels:  msg.GetLabels(),
+		TypeURL: msg.GetBody().GetTypeUrl(),
+		Value:   msg.GetBody().GetValue(),
+	}
+	if out.TypeURL != "" {
+		if m, err := msg.GetBody().UnmarshalNew(); err == nil {
+			if b, err := protojson.Marshal(m); err == nil {
+				out.Body = b
+			}
+		}
+	}
+	return out
+}
+
+func parse(fs *flag.FlagSet, cf *commonFlags, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return cf.validate()
+}
+
+func listen(ctx context.Context, args []string, _ io.Reader, stdout io.Writer) error {
+	fs, cf := newFlagSet("listen")
+	count := fs.Int("count", 0, "exit after receiving this many messages, 0 to listen until interrupted")
+	if err := parse(fs, cf, args); err != nil {
+		return err
+	}
+	conn, err := cf.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	enc := json.NewEncoder(stdout)
+	for i := 0; *count == 0 || i < *count; i++ {
+		msg, err := conn.ReceiveContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := enc.Encode(toJSON(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func send(ctx context.Context, args []string, stdin io.Reader, _ io.Writer) error {
+	fs, cf := newFlagSet("send")
+	mf := addMessageFlags(fs)
+	if err := parse(fs, cf, args); err != nil {
+		return err
+	}
+	msg, err := mf.build(stdin)
+	if err != nil {
+		return err
+	}
+	conn, err := cf.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(ctx, cf.timeout)
+	defer cancel()
+	return conn.SendMessageContext(ctx, msg)
+}
+
+func call(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs, cf := newFlagSet("call")
+	mf := addMessageFlags(fs)
+	if err := parse(fs, cf, args); err != nil {
+		return err
+	}
+	msg, err := mf.build(stdin)
+	if err != nil {
+		return err
+	}
+	c, err := cf.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(ctx, cf.timeout)
+	defer cancel()
+	resp, err := client.SendAgentMessage(ctx, cf.channel, c, msg)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(stdout).Encode(toJSON(resp.GetMessageBody()))
+}
+
+func limits(ctx context.Context, args []string, _ io.Reader, stdout io.Writer) error {
+	fs, cf := newFlagSet("limits")
+	if err := parse(fs, cf, args); err != nil {
+		return err
+	}
+	conn, err := cf.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return json.NewEncoder(stdout).Encode(map[string]int{
+		"message_rate_limit": conn.MessageRateLimit(),
+		"bandwidth_limit":    conn.MessageBandwidthLimit(),
+	})
+}
+
+// connect opens a Connection on the channel, it owns its client.
+func (cf *commonFlags) connect(ctx context.Context) (*client.Connection, error) {
+	c, err := cf.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.NewConnection(ctx, cf.channel, c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Done()
+		c.Close()
+	}()
+	return conn, nil
+}
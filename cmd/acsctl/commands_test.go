@@ -0,0 +1,257 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/GoogleCloudPlatform/agentcommunication_client"
+	"github.com/GoogleCloudPlatform/agentcommunication_client/acstest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const testChannelID = "test-channel"
+
+// startServer serves a fake server over TCP and returns the common flags to reach it.
+func startServer(t *testing.T) (*acstest.Server, []string) {
+	t.Helper()
+	srv := acstest.NewServer()
+	t.Cleanup(srv.Close)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	go srv.Serve(lis)
+	return srv, []string{"-endpoint", lis.Addr().String(), "-plaintext", "-zone", acstest.Zone, "-resource-id", acstest.ResourceID, "-channel", testChannelID}
+}
+
+func runCommand(t *testing.T, ctx context.Context, stdin string, args ...string) string {
+	t.Helper()
+	var stdout bytes.Buffer
+	if err := run(ctx, args, strings.NewReader(stdin), &stdout); err != nil {
+		t.Fatalf("run(%v) failed: %v", args, err)
+	}
+	return stdout.String()
+}
+
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestBuildMessage(t *testing.T) {
+	typeURL := "type.googleapis.com/google.protobuf.StringValue"
+	want, err := proto.Marshal(wpb.String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		flags messageFlags
+		stdin string
+		want  *acpb.MessageBody
+	}{
+		{
+			name:  "raw data",
+			flags: messageFlags{data: "hello", format: "raw", labels: labelsFlag{"k": "v"}},
+			want:  &acpb.MessageBody{Labels: map[string]string{"k": "v"}, Body: &apb.Any{Value: []byte("hello")}},
+		},
+		{
+			name:  "raw stdin",
+			flags: messageFlags{format: "raw", typeURL: "example.com/Raw"},
+			stdin: "from stdin",
+			want:  &acpb.MessageBody{Body: &apb.Any{TypeUrl: "example.com/Raw", Value: []byte("from stdin")}},
+		},
+		{
+			name:  "json",
+			flags: messageFlags{data: `"hello"`, format: "json", typeURL: typeURL},
+			want:  &acpb.MessageBody{Body: &apb.Any{TypeUrl: typeURL, Value: want}},
+		},
+		{
+			name:  "text",
+			flags: messageFlags{format: "text", typeURL: typeURL},
+			stdin: `value: "hello"`,
+			want:  &acpb.MessageBody{Body: &apb.Any{TypeUrl: typeURL, Value: want}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.flags.build(strings.NewReader(tc.stdin))
+			if err != nil {
+				t.Fatalf("build() failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("build() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, mf := range []messageFlags{
+		{data: "x", format: "json"},
+		{data: "x", format: "json", typeURL: "example.com/Unknown"},
+		{data: "x", format: "yaml"},
+	} {
+		if _, err := mf.build(strings.NewReader("")); err == nil {
+			t.Errorf("build(%+v) succeeded, want error", mf)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	ctx := testContext(t)
+	srv, common := startServer(t)
+	srv.SetRateLimits(100, 2000)
+
+	var got map[string]int
+	if err := json.Unmarshal([]byte(runCommand(t, ctx, "", append([]string{"limits"}, common...)...)), &got); err != nil {
+		t.Fatalf("limits output is not JSON: %v", err)
+	}
+	if got["message_rate_limit"] != 100 || got["bandwidth_limit"] != 2000 {
+		t.Errorf("limits = %v, want 100 and 2000", got)
+	}
+
+	runCommand(t, ctx, "", append([]string{"send", "-data", "hello", "-label", "k=v"}, common...)...)
+	sent, err := srv.WaitForSent(ctx, 1)
+	if err != nil {
+		t.Fatalf("WaitForSent() failed: %v", err)
+	}
+	if string(sent[0].Body.GetBody().GetValue()) != "hello" || sent[0].Body.GetLabels()["k"] != "v" {
+		t.Errorf("sent = %v, want hello with label k=v", sent[0].Body)
+	}
+
+	out := runCommand(t, ctx, "", append([]string{"call", "-format", "json", "-type-url", "type.googleapis.com/google.protobuf.StringValue", "-data", `"ping"`}, common...)...)
+	var called messageJSON
+	if err := json.Unmarshal([]byte(out), &called); err != nil {
+		t.Fatalf("call output %q is not JSON: %v", out, err)
+	}
+	if string(called.Body) != `"ping"` {
+		t.Errorf("call body = %s, want the echoed \"ping\"", called.Body)
+	}
+
+	go func() {
+		if _, err := srv.Push(ctx, testChannelID, &acpb.MessageBody{Labels: map[string]string{"pushed": "true"}}); err != nil {
+			t.Errorf("Push() failed: %v", err)
+		}
+	}()
+	out = runCommand(t, ctx, "", append([]string{"listen", "-count", "1"}, common...)...)
+	var received messageJSON
+	if err := json.Unmarshal([]byte(out), &received); err != nil {
+		t.Fatalf("listen output %q is not JSON: %v", out, err)
+	}
+	if received.Labels["pushed"] != "true" {
+		t.Errorf("listen = %+v, want the pushed message", received)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	ctx := testContext(t)
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"listen"},
+		{"send", "-channel", "c", "-plaintext"},
+		{"limits", "-channel", "c", "extra"},
+	} {
+		if err := run(ctx, args, strings.NewReader(""), &bytes.Buffer{}); err == nil {
+			t.Errorf("run(%v) succeeded, want error", args)
+		}
+	}
+}
+
+func TestMetadataInit(t *testing.T) {
+	base := func() (*client.MetadataInitData, error) {
+		return &client.MetadataInitData{
+			Zone:           "md-zone",
+			ResourceID:     "md-resource",
+			UniverseDomain: "example.com",
+			TokenGetter:    func() (string, error) { return "md-token", nil },
+		}, nil
+	}
+	noMetadata := func() (*client.MetadataInitData, error) {
+		return nil, errors.New("no metadata server")
+	}
+	tests := []struct {
+		name      string
+		flags     commonFlags
+		base      func() (*client.MetadataInitData, error)
+		want      client.MetadataInitData
+		wantToken string
+	}{
+		{
+			name:      "zone only",
+			flags:     commonFlags{zone: "zone"},
+			base:      base,
+			want:      client.MetadataInitData{Zone: "zone", ResourceID: "md-resource", UniverseDomain: "example.com"},
+			wantToken: "md-token",
+		},
+		{
+			name:      "token only",
+			flags:     commonFlags{token: "token"},
+			base:      base,
+			want:      client.MetadataInitData{Zone: "md-zone", ResourceID: "md-resource", UniverseDomain: "example.com"},
+			wantToken: "token",
+		},
+		{
+			name:      "plaintext",
+			flags:     commonFlags{plaintext: true},
+			base:      base,
+			want:      client.MetadataInitData{Zone: "md-zone", ResourceID: "md-resource", UniverseDomain: "example.com"},
+			wantToken: fakeIDToken,
+		},
+		{
+			name:      "plaintext without metadata server",
+			flags:     commonFlags{plaintext: true, zone: "zone", resourceID: "resource"},
+			base:      noMetadata,
+			want:      client.MetadataInitData{Zone: "zone", ResourceID: "resource", UniverseDomain: "googleapis.com"},
+			wantToken: fakeIDToken,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.flags.metadataInit(tc.base)()
+			if err != nil {
+				t.Fatalf("metadataInit() failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, *got, cmpopts.IgnoreFields(client.MetadataInitData{}, "TokenGetter")); diff != "" {
+				t.Errorf("metadataInit() diff (-want +got):\n%s", diff)
+			}
+			if token, err := got.TokenGetter(); err != nil || token != tc.wantToken {
+				t.Errorf("TokenGetter() = %q, %v, want %q", token, err, tc.wantToken)
+			}
+		})
+	}
+
+	// Without -plaintext a fake token is never sent, the metadata server is required.
+	cf := commonFlags{zone: "zone", resourceID: "resource"}
+	if _, err := cf.metadataInit(noMetadata)(); err == nil {
+		t.Error("metadataInit() without a metadata server nor -plaintext succeeded, want error")
+	}
+}
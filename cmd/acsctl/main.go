@@ -0,0 +1,89 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// acsctl sends and receives messages on AgentCommunication channels.
+//
+// Usage:
+//
+//	acsctl listen -channel my-channel [-count n]
+//	acsctl send -channel my-channel [-data payload | -file path] [-type-url url] [-format raw|json|text] [-label k=v]...
+//	acsctl call -channel my-channel [-data payload | -file path] [-type-url url] [-format raw|json|text] [-label k=v]...
+//	acsctl limits -channel my-channel
+//
+// listen streams received messages to stdout as JSON, one per line. send sends a message on a
+// streaming connection, call sends it with SendAgentMessage and prints the response, limits
+// prints the rate and bandwidth limits advertised by the service.
+//
+// The payload is read from -data, -file ("-" for stdin) or stdin. With -format json or text it is
+// parsed as the proto message identified by -type-url and sent serialized, with -format raw
+// (the default) it is sent as is.
+//
+// Every command accepts -endpoint, -plaintext, -regional, -vsock and the metadata overrides
+// -zone, -resource-id and -token. Values that are not overridden are read from the metadata
+// server, except the identity token with -plaintext which is replaced by a fake one. For example
+// to use a local acs-emulator:
+//
+//	acsctl listen -endpoint localhost:8790 -plaintext -zone test-zone -resource-id my-vm -channel my-channel
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `usage: acsctl <command> [flags]
+
+commands:
+  listen  stream received messages as JSON
+  send    send a message on a streaming connection
+  call    send a message with SendAgentMessage and print the response
+  limits  print the negotiated rate and bandwidth limits
+
+Run "acsctl <command> -h" for the flags of a command.
+`
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing command\n%s", usage)
+	}
+	commands := map[string]func(context.Context, []string, io.Reader, io.Writer) error{
+		"listen": listen,
+		"send":   send,
+		"call":   call,
+		"limits": limits,
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+	return cmd(ctx, args[1:], stdin, stdout)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "acsctl:", err)
+		os.Exit(1)
+	}
+}
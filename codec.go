@@ -0,0 +1,122 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// ErrMessageTooLarge is returned when a received payload exceeds the max message size once
+// decoded, see WithMaxMessageSize.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// DecodeError is returned by Receive for a message whose payload could not be decoded, for example
+// because it is not valid compressed data. The connection remains usable, the next call to Receive
+// returns the next message.
+type DecodeError struct {
+	// Message is the message as received.
+	Message *acpb.MessageBody
+	Err     error
+}
+
+// Error returns the error message for DecodeError.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding received message: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// bodyCodec is a stage of the payload pipeline of a Connection. Stages encode messages in order
+// before they are split into fragments, and decode them in reverse order once reassembled. A stage
+// marks the messages it encoded with its own reserved labels and removes them when decoding, and
+// never modifies the passed in message.
+type bodyCodec interface {
+	encode(msg *acpb.MessageBody) (*acpb.MessageBody, error)
+	decode(msg *acpb.MessageBody) (*acpb.MessageBody, error)
+}
+
+// withPayload returns a copy of msg with the given payload and labels added or, for empty values,
+// removed. Only the labels and body are copied.
+func withPayload(msg *acpb.MessageBody, value []byte, labels map[string]string) *acpb.MessageBody {
+	out := &acpb.MessageBody{Labels: maps.Clone(msg.GetLabels()), Body: &apb.Any{TypeUrl: msg.GetBody().GetTypeUrl(), Value: value}}
+	for k, v := range labels {
+		if v == "" {
+			delete(out.Labels, k)
+			continue
+		}
+		if out.Labels == nil {
+			out.Labels = make(map[string]string)
+		}
+		out.Labels[k] = v
+	}
+	if len(out.Labels) == 0 {
+		out.Labels = nil
+	}
+	return out
+}
+
+// encodeBody runs msg through the codecs of the connection.
+func (c *Connection) encodeBody(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	raw := len(msg.GetBody().GetValue())
+	for _, codec := range c.codecs {
+		var err error
+		if msg, err = codec.encode(msg); err != nil {
+			return nil, err
+		}
+	}
+	c.metrics.payload(directionSent, msg.GetLabels()[EncodingLabel], raw, len(msg.GetBody().GetValue()))
+	return msg, nil
+}
+
+// decodeBody undoes encodeBody, it returns a *DecodeError if msg cannot be decoded.
+func (c *Connection) decodeBody(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	wire, encoding := len(msg.GetBody().GetValue()), msg.GetLabels()[EncodingLabel]
+	decoded := msg
+	for i := len(c.codecs) - 1; i >= 0; i-- {
+		var err error
+		if decoded, err = c.codecs[i].decode(decoded); err != nil {
+			c.logger.Warn("Error decoding received message", "error", err)
+			return nil, &DecodeError{Message: msg, Err: err}
+		}
+	}
+	c.metrics.payload(directionReceived, encoding, len(decoded.GetBody().GetValue()), wire)
+	return decoded, nil
+}
+
+// authenticatedData returns a canonical encoding of the type URL and labels of msg, covered by
+// encryption and signatures. Each string is prefixed with its length, labels are sorted by key.
+func authenticatedData(msg *acpb.MessageBody) []byte {
+	var b []byte
+	appendString := func(s string) {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+	appendString(msg.GetBody().GetTypeUrl())
+	for _, k := range slices.Sorted(maps.Keys(msg.GetLabels())) {
+		appendString(k)
+		appendString(msg.GetLabels()[k])
+	}
+	return b
+}
@@ -0,0 +1,156 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// EncodingLabel is a reserved label holding the compression of the payload, its value is one of
+// the Compression constants. Messages with this label are decompressed by Receive.
+const EncodingLabel = "acs-encoding"
+
+// Compression is a payload compression algorithm, see WithCompression.
+type Compression string
+
+const (
+	// CompressionNone disables compression.
+	CompressionNone Compression = ""
+	// CompressionGzip compresses payloads with gzip.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses payloads with zstd.
+	CompressionZstd Compression = "zstd"
+)
+
+// zstdEncoder is shared by all connections, EncodeAll is safe for concurrent use.
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil)
+})
+
+// compressionCodec compresses payloads larger than threshold with algo, and decompresses received
+// payloads up to maxSize bytes whatever algo is.
+type compressionCodec struct {
+	algo      Compression
+	threshold int
+	maxSize   int
+
+	// zstdDecoders holds idle *zstd.Decoder whose window and memory are limited to maxSize.
+	zstdDecoders sync.Pool
+}
+
+func (cc *compressionCodec) zstdDecoder() (*zstd.Decoder, error) {
+	if zr, ok := cc.zstdDecoders.Get().(*zstd.Decoder); ok {
+		return zr, nil
+	}
+	return zstd.NewReader(nil,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(uint64(max(cc.maxSize, zstd.MinWindowSize))),
+		zstd.WithDecoderMaxMemory(uint64(cc.maxSize)+1))
+}
+
+func compress(algo Compression, value []byte) ([]byte, error) {
+	switch algo {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(value, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", algo)
+}
+
+func (cc *compressionCodec) encode(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	if _, ok := msg.GetLabels()[EncodingLabel]; ok {
+		return nil, fmt.Errorf("label %q is reserved", EncodingLabel)
+	}
+	value := msg.GetBody().GetValue()
+	if cc.algo == CompressionNone || len(value) <= cc.threshold {
+		return msg, nil
+	}
+	compressed, err := compress(cc.algo, value)
+	if err != nil {
+		return nil, fmt.Errorf("compressing payload: %w", err)
+	}
+	if len(compressed) >= len(value) {
+		// Incompressible, send as is.
+		return msg, nil
+	}
+	return withPayload(msg, compressed, map[string]string{EncodingLabel: string(cc.algo)}), nil
+}
+
+func (cc *compressionCodec) decode(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	encoding, ok := msg.GetLabels()[EncodingLabel]
+	if !ok {
+		return msg, nil
+	}
+	var r io.Reader
+	switch Compression(encoding) {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(msg.GetBody().GetValue()))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+		}
+		r = zr
+	case CompressionZstd:
+		zr, err := cc.zstdDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd decoder: %w", err)
+		}
+		if err := zr.Reset(bytes.NewReader(msg.GetBody().GetValue())); err != nil {
+			zr.Close()
+			return nil, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+		}
+		defer func() {
+			// Drop the reference to the payload before pooling the decoder.
+			zr.Reset(nil)
+			cc.zstdDecoders.Put(zr)
+		}()
+		r = zr
+	default:
+		return nil, fmt.Errorf("%w: unsupported encoding %q", ErrMalformedPayload, encoding)
+	}
+	// Read one byte more than allowed to detect decompression bombs without buffering them.
+	value, err := io.ReadAll(io.LimitReader(r, int64(cc.maxSize)+1))
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, fmt.Errorf("%w: %s payload decompresses to more than %d bytes", ErrMessageTooLarge, encoding, cc.maxSize)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: decompressing %s payload: %v", ErrMalformedPayload, encoding, err)
+	}
+	if len(value) > cc.maxSize {
+		return nil, fmt.Errorf("%w: %s payload decompresses to more than %d bytes", ErrMessageTooLarge, encoding, cc.maxSize)
+	}
+	return withPayload(msg, value, map[string]string{EncodingLabel: ""}), nil
+}
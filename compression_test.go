@@ -0,0 +1,183 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+func TestCompressionCodec(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"key": "value"}`), 100)
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{TypeUrl: "example.com/T", Value: payload}}
+
+	for _, algo := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(algo), func(t *testing.T) {
+			cc := &compressionCodec{algo: algo, threshold: 100, maxSize: len(payload)}
+			encoded, err := cc.encode(msg)
+			if err != nil {
+				t.Fatalf("encode() failed: %v", err)
+			}
+			if encoded.GetLabels()[EncodingLabel] != string(algo) || len(encoded.GetBody().GetValue()) >= len(payload) {
+				t.Errorf("encode() = %d bytes with labels %v, want fewer than %d bytes marked %s", len(encoded.GetBody().GetValue()), encoded.GetLabels(), len(payload), algo)
+			}
+			if len(msg.GetLabels()) != 1 {
+				t.Errorf("encode() modified the message labels: %v", msg.GetLabels())
+			}
+			decoded, err := cc.decode(encoded)
+			if err != nil {
+				t.Fatalf("decode() failed: %v", err)
+			}
+			if diff := cmp.Diff(msg, decoded, protocmp.Transform()); diff != "" {
+				t.Errorf("decode() diff (-want +got):\n%s", diff)
+			}
+
+			// Decompression bombs are refused.
+			small := &compressionCodec{maxSize: len(payload) - 1}
+			if _, err := small.decode(encoded); !errors.Is(err, ErrMessageTooLarge) {
+				t.Errorf("decode() over the max size = %v, want %v", err, ErrMessageTooLarge)
+			}
+			corrupt := withPayload(encoded, []byte("not compressed"), nil)
+			if _, err := cc.decode(corrupt); !errors.Is(err, ErrMalformedPayload) {
+				t.Errorf("decode() of corrupt data = %v, want %v", err, ErrMalformedPayload)
+			}
+		})
+	}
+
+	cc := &compressionCodec{algo: CompressionGzip, threshold: 100, maxSize: 1 << 20}
+	short := &acpb.MessageBody{Body: &apb.Any{Value: payload[:100]}}
+	if got, err := cc.encode(short); err != nil || got != short {
+		t.Errorf("encode() under the threshold = %v, %v, want the message unchanged", got, err)
+	}
+	random := make([]byte, 1000)
+	rand.Read(random)
+	incompressible := &acpb.MessageBody{Body: &apb.Any{Value: random}}
+	if got, err := cc.encode(incompressible); err != nil || got != incompressible {
+		t.Errorf("encode() of incompressible data = %v, want the message unchanged", err)
+	}
+	reserved := &acpb.MessageBody{Labels: map[string]string{EncodingLabel: "gzip"}, Body: &apb.Any{Value: payload}}
+	if _, err := cc.encode(reserved); err == nil {
+		t.Error("encode() with the reserved label succeeded, want error")
+	}
+	unknown := &acpb.MessageBody{Labels: map[string]string{EncodingLabel: "br"}, Body: &apb.Any{Value: payload}}
+	if _, err := cc.decode(unknown); !errors.Is(err, ErrMalformedPayload) {
+		t.Errorf("decode() of an unknown encoding = %v, want %v", err, ErrMalformedPayload)
+	}
+}
+
+func TestCompressionCodec_ZstdWindow(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"key": "value"}`), 100)
+	// Flushing before the end leaves the frame without a content size, so it declares the full window.
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf, zstd.WithWindowSize(1<<20))
+	if err != nil {
+		t.Fatalf("zstd.NewWriter() failed: %v", err)
+	}
+	for _, part := range [][]byte{payload[:800], payload[800:]} {
+		if _, err := zw.Write(part); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+		if err := zw.Flush(); err != nil {
+			t.Fatalf("Flush() failed: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	// The frame fits the max size once decompressed, but declares a window needing far more memory.
+	wide := &acpb.MessageBody{Labels: map[string]string{EncodingLabel: string(CompressionZstd)}, Body: &apb.Any{Value: buf.Bytes()}}
+	cc := &compressionCodec{maxSize: 4 * len(payload)}
+	if _, err := cc.decode(wide); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("decode() with a window over the max size = %v, want %v", err, ErrMessageTooLarge)
+	}
+
+	// Pooled decoders keep working after a failure.
+	encoded, err := (&compressionCodec{algo: CompressionZstd}).encode(&acpb.MessageBody{Body: &apb.Any{Value: payload}})
+	if err != nil {
+		t.Fatalf("encode() failed: %v", err)
+	}
+	for i := range 3 {
+		decoded, err := cc.decode(encoded)
+		if err != nil {
+			t.Fatalf("decode() #%d failed: %v", i, err)
+		}
+		if !bytes.Equal(decoded.GetBody().GetValue(), payload) {
+			t.Errorf("decode() #%d = %d bytes, want the original %d bytes", i, len(decoded.GetBody().GetValue()), len(payload))
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	// Chunking applies to the compressed payload.
+	srv, conn, err := newTestConnection(ctx, t, WithCompression(CompressionZstd, 100), WithChunking(20))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: bytes.Repeat([]byte("compressible "), 100)}}
+	if err := conn.SendMessageContext(ctx, msg); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	srv.reqMx.Lock()
+	var frags []*acpb.MessageBody
+	var wire int
+	for _, req := range srv.req[1:] {
+		frags = append(frags, req.GetMessageBody())
+		wire += len(req.GetMessageBody().GetBody().GetValue())
+	}
+	srv.reqMx.Unlock()
+	if frags[0].GetLabels()[EncodingLabel] != string(CompressionZstd) || wire >= len(msg.GetBody().GetValue()) {
+		t.Errorf("sent %d bytes in %d fragments with labels %v, want a zstd compressed payload", wire, len(frags), frags[0].GetLabels())
+	}
+
+	for i, frag := range frags {
+		pushMessage(srv, string(rune('a'+i)), frag)
+	}
+	got, err := conn.ReceiveContext(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveContext() failed: %v", err)
+	}
+	if diff := cmp.Diff(msg, got, protocmp.Transform()); diff != "" {
+		t.Errorf("ReceiveContext() diff (-want +got):\n%s", diff)
+	}
+
+	// A message that cannot be decompressed is returned as a DecodeError, the connection remains
+	// usable.
+	corrupt := &acpb.MessageBody{Labels: map[string]string{EncodingLabel: string(CompressionGzip)}, Body: &apb.Any{Value: []byte("corrupt")}}
+	pushMessage(srv, "corrupt", corrupt)
+	var decodeErr *DecodeError
+	if _, err := conn.ReceiveContext(ctx); !errors.As(err, &decodeErr) || !errors.Is(err, ErrMalformedPayload) {
+		t.Fatalf("ReceiveContext() = %v, want a DecodeError wrapping %v", err, ErrMalformedPayload)
+	}
+	if diff := cmp.Diff(corrupt, decodeErr.Message, protocmp.Transform()); diff != "" {
+		t.Errorf("DecodeError.Message diff (-want +got):\n%s", diff)
+	}
+	plain := &acpb.MessageBody{Body: &apb.Any{Value: []byte("plain")}}
+	pushMessage(srv, "plain", plain)
+	if got, err := conn.ReceiveContext(ctx); err != nil || string(got.GetBody().GetValue()) != "plain" {
+		t.Errorf("ReceiveContext() after a DecodeError = %v, %v, want the next message", got, err)
+	}
+}
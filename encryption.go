@@ -0,0 +1,155 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	// KeyIDLabel is a reserved label holding the ID of the key an encrypted payload was encrypted
+	// with, see KeyProvider.
+	KeyIDLabel = "acs-key-id"
+	// CipherLabel is a reserved label holding the Cipher of an encrypted payload.
+	CipherLabel = "acs-cipher"
+)
+
+// ErrDecryption is returned when a received message cannot be decrypted, because it is not
+// encrypted, its key is unknown or it was tampered with.
+var ErrDecryption = errors.New("message decryption failed")
+
+// Cipher is a payload encryption algorithm, see WithEncryption.
+type Cipher string
+
+const (
+	// CipherAESGCM encrypts payloads with AES-GCM, the key size (16, 24 or 32 bytes) selects
+	// AES-128, AES-192 or AES-256.
+	CipherAESGCM Cipher = "aes-gcm"
+	// CipherXChaCha20Poly1305 encrypts payloads with XChaCha20-Poly1305, keys are 32 bytes.
+	CipherXChaCha20Poly1305 Cipher = "xchacha20-poly1305"
+)
+
+// KeyProvider supplies the keys used to encrypt and decrypt payloads. To rotate keys, change the
+// current key while keeping the previous ones available to Key until the messages encrypted with
+// them were received.
+type KeyProvider interface {
+	// CurrentKey returns the ID and key to encrypt sent messages with.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given ID, to decrypt received messages.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider with a fixed set of keys.
+type StaticKeys struct {
+	// Current is the ID of the key sent messages are encrypted with.
+	Current string
+	// Keys are the keys by ID.
+	Keys map[string][]byte
+}
+
+// CurrentKey implements KeyProvider.
+func (s *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := s.Key(s.Current)
+	return s.Current, key, err
+}
+
+// Key implements KeyProvider.
+func (s *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := s.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	return key, nil
+}
+
+func newAEAD(c Cipher, key []byte) (cipher.AEAD, error) {
+	switch c {
+	case CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("unsupported cipher %q", c)
+}
+
+// encryptionCodec encrypts payloads with keys from keys, and requires received payloads to be
+// encrypted. The labels and type URL are authenticated as associated data.
+type encryptionCodec struct {
+	cipher Cipher
+	keys   KeyProvider
+}
+
+func (ec *encryptionCodec) encode(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	for _, label := range []string{KeyIDLabel, CipherLabel} {
+		if _, ok := msg.GetLabels()[label]; ok {
+			return nil, fmt.Errorf("label %q is reserved", label)
+		}
+	}
+	id, key, err := ec.keys.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("getting encryption key: %w", err)
+	}
+	aead, err := newAEAD(ec.cipher, key)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting with key %q: %w", id, err)
+	}
+	out := withPayload(msg, nil, map[string]string{KeyIDLabel: id, CipherLabel: string(ec.cipher)})
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(msg.GetBody().GetValue())+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out.Body.Value = aead.Seal(nonce, nonce, msg.GetBody().GetValue(), authenticatedData(out))
+	return out, nil
+}
+
+func (ec *encryptionCodec) decode(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	id, ok := msg.GetLabels()[KeyIDLabel]
+	if !ok {
+		return nil, fmt.Errorf("%w: message is not encrypted", ErrDecryption)
+	}
+	// The sender does not choose the cipher, only the configured one is accepted.
+	if c := Cipher(msg.GetLabels()[CipherLabel]); c != ec.cipher {
+		return nil, fmt.Errorf("%w: message encrypted with %q, want %q", ErrDecryption, c, ec.cipher)
+	}
+	key, err := ec.keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: getting key %q: %v", ErrDecryption, id, err)
+	}
+	aead, err := newAEAD(ec.cipher, key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+	value := msg.GetBody().GetValue()
+	if len(value) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: payload too short", ErrDecryption)
+	}
+	plaintext, err := aead.Open(nil, value[:aead.NonceSize()], value[aead.NonceSize():], authenticatedData(msg))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+	return withPayload(msg, plaintext, map[string]string{KeyIDLabel: "", CipherLabel: ""}), nil
+}
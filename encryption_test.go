@@ -0,0 +1,144 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+func testKeys(current string) *StaticKeys {
+	return &StaticKeys{Current: current, Keys: map[string][]byte{
+		"key-1": bytes.Repeat([]byte{1}, 32),
+		"key-2": bytes.Repeat([]byte{2}, 32),
+	}}
+}
+
+func TestEncryptionCodec(t *testing.T) {
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{TypeUrl: "example.com/T", Value: []byte("secret command")}}
+
+	for _, c := range []Cipher{CipherAESGCM, CipherXChaCha20Poly1305} {
+		t.Run(string(c), func(t *testing.T) {
+			ec := &encryptionCodec{cipher: c, keys: testKeys("key-1")}
+			encrypted, err := ec.encode(msg)
+			if err != nil {
+				t.Fatalf("encode() failed: %v", err)
+			}
+			if encrypted.GetLabels()[KeyIDLabel] != "key-1" || encrypted.GetLabels()[CipherLabel] != string(c) {
+				t.Errorf("encode() labels = %v, want key-1 and %s", encrypted.GetLabels(), c)
+			}
+			if bytes.Contains(encrypted.GetBody().GetValue(), []byte("secret")) {
+				t.Errorf("encode() payload contains the plaintext")
+			}
+
+			// Rotated keys still decrypt messages encrypted with the previous key.
+			rotated := &encryptionCodec{cipher: c, keys: testKeys("key-2")}
+			decrypted, err := rotated.decode(encrypted)
+			if err != nil {
+				t.Fatalf("decode() failed: %v", err)
+			}
+			if diff := cmp.Diff(msg, decrypted, protocmp.Transform()); diff != "" {
+				t.Errorf("decode() diff (-want +got):\n%s", diff)
+			}
+
+			tamper := map[string]func(*acpb.MessageBody){
+				"payload":       func(m *acpb.MessageBody) { m.Body.Value[len(m.Body.Value)-1] ^= 1 },
+				"label":         func(m *acpb.MessageBody) { m.Labels["key"] = "other" },
+				"type URL":      func(m *acpb.MessageBody) { m.Body.TypeUrl = "example.com/Other" },
+				"key ID":        func(m *acpb.MessageBody) { m.Labels[KeyIDLabel] = "key-2" },
+				"unknown key":   func(m *acpb.MessageBody) { m.Labels[KeyIDLabel] = "key-3" },
+				"not encrypted": func(m *acpb.MessageBody) { delete(m.Labels, KeyIDLabel) },
+				"truncated":     func(m *acpb.MessageBody) { m.Body.Value = m.Body.Value[:4] },
+			}
+			for name, f := range tamper {
+				m := proto.Clone(encrypted).(*acpb.MessageBody)
+				f(m)
+				if _, err := ec.decode(m); !errors.Is(err, ErrDecryption) {
+					t.Errorf("decode() with tampered %s = %v, want %v", name, err, ErrDecryption)
+				}
+			}
+		})
+	}
+
+	ec := &encryptionCodec{cipher: CipherAESGCM, keys: testKeys("key-3")}
+	if _, err := ec.encode(msg); err == nil {
+		t.Error("encode() with an unknown current key succeeded, want error")
+	}
+	ec = &encryptionCodec{cipher: CipherAESGCM, keys: testKeys("key-1")}
+	reserved := &acpb.MessageBody{Labels: map[string]string{CipherLabel: "none"}}
+	if _, err := ec.encode(reserved); err == nil {
+		t.Error("encode() with a reserved label succeeded, want error")
+	}
+
+	// Messages encrypted with another cipher than the configured one are refused, even with a
+	// valid key and payload.
+	other := &encryptionCodec{cipher: CipherXChaCha20Poly1305, keys: testKeys("key-1")}
+	encrypted, err := other.encode(msg)
+	if err != nil {
+		t.Fatalf("encode() failed: %v", err)
+	}
+	if _, err := ec.decode(encrypted); !errors.Is(err, ErrDecryption) {
+		t.Errorf("decode() with a mismatched cipher = %v, want %v", err, ErrDecryption)
+	}
+	mislabeled := proto.Clone(encrypted).(*acpb.MessageBody)
+	mislabeled.Labels[CipherLabel] = string(CipherAESGCM)
+	if _, err := other.decode(mislabeled); !errors.Is(err, ErrDecryption) {
+		t.Errorf("decode() with a mismatched cipher label = %v, want %v", err, ErrDecryption)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithCompression(CompressionGzip, 0), WithEncryption(CipherAESGCM, testKeys("key-1")))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: bytes.Repeat([]byte("secret "), 20)}}
+	if err := conn.SendMessageContext(ctx, msg); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	waitForRequests(t, srv, 2)
+	srv.reqMx.Lock()
+	sent := srv.req[1].GetMessageBody()
+	srv.reqMx.Unlock()
+	if sent.GetLabels()[EncodingLabel] != string(CompressionGzip) || sent.GetLabels()[KeyIDLabel] != "key-1" || bytes.Contains(sent.GetBody().GetValue(), []byte("secret")) {
+		t.Errorf("sent message = %v, want a compressed and encrypted payload", sent)
+	}
+
+	pushMessage(srv, "1", sent)
+	got, err := conn.ReceiveContext(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveContext() failed: %v", err)
+	}
+	if diff := cmp.Diff(msg, got, protocmp.Transform()); diff != "" {
+		t.Errorf("ReceiveContext() diff (-want +got):\n%s", diff)
+	}
+
+	pushMessage(srv, "2", &acpb.MessageBody{Body: &apb.Any{Value: []byte("plaintext")}})
+	var decodeErr *DecodeError
+	if _, err := conn.ReceiveContext(ctx); !errors.As(err, &decodeErr) || !errors.Is(err, ErrDecryption) {
+		t.Errorf("ReceiveContext() of a plaintext message = %v, want a DecodeError wrapping %v", err, ErrDecryption)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const defaultAckDeadline = time.Minute
+
+// ErrorInfoDomain is the domain of the ErrorInfo details of the statuses sent for messages
+// rejected by a RejectPolicy.
+const ErrorInfoDomain = "agentcommunication.googleapis.com"
+
+// ErrorInfo reasons of the statuses sent for messages rejected by a RejectPolicy.
+const (
+	RejectReasonTooLarge    = "MESSAGE_TOO_LARGE"
+	RejectReasonUndecodable = "UNDECODABLE_MESSAGE"
+	RejectReasonInvalid     = "INVALID_MESSAGE"
+)
+
+// Reason attribute of the rejections metric for messages rejected with Envelope.Nack.
+const rejectReasonNack = "NACK"
+
+// ErrAutoAcked is returned by Envelope.Ack and Envelope.Nack for a message received in automatic
+// acknowledgement mode, which was already acknowledged when received.
+var ErrAutoAcked = errors.New("message acknowledged automatically")
+
+// Envelope is a received message along with the metadata of its delivery, see ReceiveEnvelope.
+type Envelope struct {
+	// MessageID is the ID the service delivered the message with, to correlate it with service
+	// side records and to detect messages delivered more than once. For a message received in
+	// fragments, the ID of its last fragment.
+	MessageID string
+	// ReceivedAt is when the message, or its last fragment, was read from the stream.
+	ReceivedAt time.Time
+	// Generation is the generation of the stream the message was received on, see StateEvent.
+	Generation int
+	// Transport is the transport of the connection, "network" or "vsock".
+	Transport string
+	// Labels are the labels of Body.
+	Labels map[string]string
+	// Body is the decoded message.
+	Body *acpb.MessageBody
+
+	// Set in manual acknowledgement mode.
+	ack *ackHandle
+	// The *DecodeError of a message that could not be decoded, Body is then the message as received.
+	err error
+}
+
+// Ack acknowledges the message in manual acknowledgement mode, see WithManualAck. It returns an
+// error if the stream the message was received on was closed meanwhile, the service then delivers
+// the message again. Acknowledging a message more than once does nothing. Without WithManualAck
+// the message was acknowledged automatically and Ack returns ErrAutoAcked.
+func (e *Envelope) Ack() error {
+	if e.ack == nil {
+		return ErrAutoAcked
+	}
+	_, err := e.ack.do(nil)
+	return err
+}
+
+// Nack rejects the message in manual acknowledgement mode, see WithManualAck: instead of OK, the
+// MessageResponse of the message carries st, which tells the service and the sender why the message
+// was rejected, for example status.New(codes.InvalidArgument, "unknown command"). Use
+// status.Status.WithDetails to add an ErrorInfo. A rejected message is not delivered again. Nack
+// with a nil or OK status is the same as Ack. Like Ack, Nack does nothing if the message was
+// already acknowledged or rejected, and returns ErrAutoAcked in automatic acknowledgement mode.
+func (e *Envelope) Nack(st *status.Status) error {
+	if e.ack == nil {
+		return ErrAutoAcked
+	}
+	if st.Code() == codes.OK {
+		return e.Ack()
+	}
+	sent, err := e.ack.do(st.Proto())
+	if sent {
+		e.ack.rejected(rejectReasonNack)
+	}
+	return err
+}
+
+// RejectPolicy selects the received messages that are rejected automatically, see WithRejectPolicy.
+// Rejected messages are not returned by Receive, their MessageResponse carries an InvalidArgument
+// status with an ErrorInfo whose domain is ErrorInfoDomain and whose reason is one of the
+// RejectReason constants.
+type RejectPolicy struct {
+	// MaxSize rejects messages whose payload is larger than MaxSize bytes once decoded with reason
+	// RejectReasonTooLarge. Zero disables the check.
+	MaxSize int
+	// Undecodable rejects messages that cannot be decoded with reason RejectReasonUndecodable,
+	// instead of returning them as a *DecodeError.
+	Undecodable bool
+	// Validate, if set, is called with each decoded message, a message for which it returns an
+	// error is rejected with reason RejectReasonInvalid and the error as status message. If the error
+	// is a gRPC status error (see status.Error), its status is sent as is instead.
+	Validate func(*acpb.MessageBody) error
+}
+
+// check returns the status to reject env with, or nil if env is accepted.
+func (p *RejectPolicy) check(env *Envelope) *spb.Status {
+	if p == nil {
+		return nil
+	}
+	if env.err != nil {
+		if !p.Undecodable {
+			return nil
+		}
+		return rejectStatus(RejectReasonUndecodable, env.err.Error(), map[string]string{"message_id": env.MessageID})
+	}
+	if size := len(env.Body.GetBody().GetValue()); p.MaxSize > 0 && size > p.MaxSize {
+		return rejectStatus(RejectReasonTooLarge, fmt.Sprintf("message size %d exceeds the max size %d", size, p.MaxSize),
+			map[string]string{"message_id": env.MessageID, "size": strconv.Itoa(size), "max_size": strconv.Itoa(p.MaxSize)})
+	}
+	if p.Validate == nil {
+		return nil
+	}
+	err := p.Validate(env.Body)
+	if err == nil {
+		return nil
+	}
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) && se.GRPCStatus().Code() != codes.OK {
+		return se.GRPCStatus().Proto()
+	}
+	return rejectStatus(RejectReasonInvalid, err.Error(), map[string]string{"message_id": env.MessageID})
+}
+
+// rejectStatus returns an InvalidArgument status with an ErrorInfo detail.
+func rejectStatus(reason, message string, metadata map[string]string) *spb.Status {
+	st, err := status.New(codes.InvalidArgument, message).WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorInfoDomain, Metadata: metadata})
+	if err != nil {
+		// Only fails for an OK code.
+		return status.New(codes.InvalidArgument, message).Proto()
+	}
+	return st.Proto()
+}
+
+// rejectReason returns the ErrorInfo reason of st, or its code if it has none.
+func rejectReason(st *spb.Status) string {
+	for _, d := range status.FromProto(st).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetReason() != "" {
+			return info.GetReason()
+		}
+	}
+	return codes.Code(st.GetCode()).String()
+}
+
+// ackHandle acknowledges a received message once, on the stream it was received on.
+type ackHandle struct {
+	mu    sync.Mutex
+	done  bool
+	timer *time.Timer
+	// send sends the MessageResponse with the given status, nil for OK.
+	send     func(*spb.Status) error
+	rejected func(reason string)
+	logger   *slog.Logger
+}
+
+// do sends the ack, or the rejection if st is not nil, unless one was already sent, it reports
+// whether it sent it.
+func (h *ackHandle) do(st *spb.Status) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		return false, nil
+	}
+	h.done = true
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	return true, h.send(st)
+}
+
+func (h *ackHandle) pending() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.done
+}
+
+// watchAck sets up the manual acknowledgement of env with send. Its ack deadline starts once env is
+// returned by ReceiveEnvelope, see startAckDeadline, so that messages waiting to be received are
+// not acknowledged before the application saw them.
+func (c *Connection) watchAck(env *Envelope, send func(*spb.Status) error, logger *slog.Logger) {
+	env.ack = &ackHandle{send: send, rejected: c.metrics.rejected, logger: logger}
+}
+
+// startAckDeadline starts the ack deadline of env, when it is handed out to the application.
+func (c *Connection) startAckDeadline(env *Envelope) {
+	h := env.ack
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		return
+	}
+	h.timer = time.AfterFunc(c.ackDeadline, func() {
+		if c.ackExpired != nil {
+			if h.pending() {
+				c.ackExpired(env)
+			}
+			return
+		}
+		if sent, err := h.do(nil); sent {
+			h.logger.Warn("Message not acknowledged before the ack deadline, acknowledging it", logKeyMessageID, env.MessageID, "deadline", c.ackDeadline, "error", err)
+		}
+	})
+}
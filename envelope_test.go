@@ -0,0 +1,330 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+func TestReceiveEnvelope(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithCompression(CompressionGzip, 0))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	events, stop := conn.Subscribe()
+	defer stop()
+
+	msg := testMessage("body")
+	encoded, err := conn.encodeBody(msg)
+	if err != nil {
+		t.Fatalf("encodeBody() failed: %v", err)
+	}
+	for generation := 1; generation <= 2; generation++ {
+		before := time.Now()
+		pushMessage(srv, "message-1", encoded)
+		got, err := conn.ReceiveEnvelope(ctx)
+		if err != nil {
+			t.Fatalf("ReceiveEnvelope() failed: %v", err)
+		}
+		want := &Envelope{MessageID: "message-1", Generation: generation, Transport: transportNetwork, Labels: msg.GetLabels(), Body: msg}
+		if diff := cmp.Diff(want, got, protocmp.Transform(), cmpopts.IgnoreFields(Envelope{}, "ReceivedAt"), cmpopts.IgnoreUnexported(Envelope{})); diff != "" {
+			t.Errorf("ReceiveEnvelope() diff (-want +got):\n%s", diff)
+		}
+		if got.ReceivedAt.Before(before) || got.ReceivedAt.After(time.Now()) {
+			t.Errorf("ReceiveEnvelope() ReceivedAt = %v, want between %v and now", got.ReceivedAt, before)
+		}
+
+		// Redelivered on the next stream.
+		srv.recvErr <- nil
+		for ev := nextEvent(t, events); ev.To != StateReady; ev = nextEvent(t, events) {
+		}
+	}
+}
+
+// acked reports whether the message with the given ID was acknowledged.
+func acked(srv *testSrv, id string) bool {
+	srv.reqMx.Lock()
+	defer srv.reqMx.Unlock()
+	for _, req := range srv.req {
+		if req.GetMessageResponse() != nil && req.GetMessageId() == id {
+			return true
+		}
+	}
+	return false
+}
+
+func waitForAck(t *testing.T, srv *testSrv, id string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	for !acked(srv, id) {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for the ack of %s", id)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestManualAck(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var expired []string
+	srv, conn, err := newTestConnection(ctx, t, WithManualAck(100*time.Millisecond, func(env *Envelope) {
+		mu.Lock()
+		defer mu.Unlock()
+		expired = append(expired, env.MessageID)
+	}))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	pushMessage(srv, "handled", testMessage("a"))
+	env, err := conn.ReceiveEnvelope(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveEnvelope() failed: %v", err)
+	}
+	if acked(srv, "handled") {
+		t.Error("message acknowledged before Ack()")
+	}
+	if err := env.Ack(); err != nil {
+		t.Errorf("Ack() failed: %v", err)
+	}
+	if err := env.Ack(); err != nil {
+		t.Errorf("second Ack() failed: %v", err)
+	}
+	waitForAck(t, srv, "handled")
+
+	// Past the deadline the message is reported and stays unacknowledged.
+	pushMessage(srv, "slow", testMessage("b"))
+	env, err = conn.ReceiveEnvelope(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveEnvelope() failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	if len(expired) != 1 || expired[0] != "slow" {
+		t.Errorf("expired messages = %v, want [slow]", expired)
+	}
+	mu.Unlock()
+	if acked(srv, "slow") {
+		t.Error("expired message acknowledged, want it left unacknowledged")
+	}
+	if err := env.Ack(); err != nil {
+		t.Errorf("Ack() after the deadline failed: %v", err)
+	}
+	waitForAck(t, srv, "slow")
+
+	// ReceiveContext acknowledges the messages it returns.
+	pushMessage(srv, "auto", testMessage("c"))
+	if _, err := conn.ReceiveContext(ctx); err != nil {
+		t.Fatalf("ReceiveContext() failed: %v", err)
+	}
+	waitForAck(t, srv, "auto")
+
+	// Messages can only be acknowledged on the stream they were received on.
+	pushMessage(srv, "stale", testMessage("d"))
+	env, err = conn.ReceiveEnvelope(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveEnvelope() failed: %v", err)
+	}
+	events, stop := conn.Subscribe()
+	defer stop()
+	srv.recvErr <- nil
+	for ev := nextEvent(t, events); ev.To != StateReady; ev = nextEvent(t, events) {
+	}
+	if err := env.Ack(); err == nil {
+		t.Error("Ack() on a closed stream succeeded, want error")
+	}
+}
+
+func TestManualAck_Deadline(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithManualAck(50*time.Millisecond, nil))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	pushMessage(srv, "forgotten", testMessage("a"))
+	// The deadline starts once the message is received.
+	time.Sleep(100 * time.Millisecond)
+	if acked(srv, "forgotten") {
+		t.Error("message acknowledged before it was received")
+	}
+	if _, err := conn.ReceiveEnvelope(ctx); err != nil {
+		t.Fatalf("ReceiveEnvelope() failed: %v", err)
+	}
+	waitForAck(t, srv, "forgotten")
+}
+
+// ackStatus returns the status of the MessageResponse sent for id, nil if none was sent.
+func ackStatus(srv *testSrv, id string) *spb.Status {
+	srv.reqMx.Lock()
+	defer srv.reqMx.Unlock()
+	for _, req := range srv.req {
+		if req.GetMessageResponse() != nil && req.GetMessageId() == id {
+			return req.GetMessageResponse().GetStatus()
+		}
+	}
+	return nil
+}
+
+func TestNack(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithManualAck(time.Minute, nil))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	pushMessage(srv, "rejected", testMessage("a"))
+	env, err := conn.ReceiveEnvelope(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveEnvelope() failed: %v", err)
+	}
+	if err := env.Nack(status.New(codes.InvalidArgument, "unknown command")); err != nil {
+		t.Errorf("Nack() failed: %v", err)
+	}
+	// The first response wins.
+	if err := env.Ack(); err != nil {
+		t.Errorf("Ack() after Nack() failed: %v", err)
+	}
+	waitForAck(t, srv, "rejected")
+	want := &spb.Status{Code: int32(codes.InvalidArgument), Message: "unknown command"}
+	if diff := cmp.Diff(want, ackStatus(srv, "rejected"), protocmp.Transform()); diff != "" {
+		t.Errorf("MessageResponse status diff (-want +got):\n%s", diff)
+	}
+
+	// Nack with an OK status acknowledges the message.
+	pushMessage(srv, "ok", testMessage("b"))
+	env, err = conn.ReceiveEnvelope(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveEnvelope() failed: %v", err)
+	}
+	if err := env.Nack(nil); err != nil {
+		t.Errorf("Nack(nil) failed: %v", err)
+	}
+	waitForAck(t, srv, "ok")
+	if st := ackStatus(srv, "ok"); st != nil {
+		t.Errorf("MessageResponse status = %v, want OK", st)
+	}
+}
+
+func TestAck_AutoAcked(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	pushMessage(srv, "auto", testMessage("a"))
+	env, err := conn.ReceiveEnvelope(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveEnvelope() failed: %v", err)
+	}
+	if err := env.Ack(); !errors.Is(err, ErrAutoAcked) {
+		t.Errorf("Ack() = %v, want %v", err, ErrAutoAcked)
+	}
+	if err := env.Nack(status.New(codes.InvalidArgument, "unknown command")); !errors.Is(err, ErrAutoAcked) {
+		t.Errorf("Nack() = %v, want %v", err, ErrAutoAcked)
+	}
+	waitForAck(t, srv, "auto")
+	if st := ackStatus(srv, "auto"); st != nil {
+		t.Errorf("MessageResponse status = %v, want OK", st)
+	}
+}
+
+func TestRejectPolicy(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	srv, conn, err := newTestConnection(ctx, t, WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithRejectPolicy(RejectPolicy{
+			MaxSize:     4,
+			Undecodable: true,
+			Validate: func(msg *acpb.MessageBody) error {
+				switch string(msg.GetBody().GetValue()) {
+				case "bad":
+					return errors.New("bad command")
+				case "deny":
+					return status.Error(codes.PermissionDenied, "not allowed")
+				}
+				return nil
+			},
+		}))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	corrupt := &acpb.MessageBody{Labels: map[string]string{EncodingLabel: string(CompressionGzip)}, Body: &apb.Any{Value: []byte("corrupt")}}
+	pushMessage(srv, "large", testMessage("too large"))
+	pushMessage(srv, "corrupt", corrupt)
+	pushMessage(srv, "bad", testMessage("bad"))
+	pushMessage(srv, "deny", testMessage("deny"))
+	pushMessage(srv, "good", testMessage("good"))
+	got, err := conn.ReceiveEnvelope(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveEnvelope() failed: %v", err)
+	}
+	if got.MessageID != "good" {
+		t.Errorf("ReceiveEnvelope() = %s, want only the accepted message", got.MessageID)
+	}
+	// Responses are sent in order.
+	waitForAck(t, srv, "good")
+	if st := ackStatus(srv, "good"); st != nil {
+		t.Errorf("MessageResponse status of the accepted message = %v, want OK", st)
+	}
+
+	for id, want := range map[string]struct {
+		code   codes.Code
+		reason string
+	}{
+		"large":   {codes.InvalidArgument, RejectReasonTooLarge},
+		"corrupt": {codes.InvalidArgument, RejectReasonUndecodable},
+		"bad":     {codes.InvalidArgument, RejectReasonInvalid},
+		"deny":    {codes.PermissionDenied, ""},
+	} {
+		st := status.FromProto(ackStatus(srv, id))
+		if st.Code() != want.code {
+			t.Errorf("MessageResponse status of %s = %v, want code %v", id, st, want.code)
+		}
+		if want.reason == "" {
+			continue
+		}
+		var info *errdetails.ErrorInfo
+		if details := st.Details(); len(details) == 1 {
+			info, _ = details[0].(*errdetails.ErrorInfo)
+		}
+		if info == nil || info.GetReason() != want.reason || info.GetDomain() != ErrorInfoDomain || info.GetMetadata()["message_id"] != id {
+			t.Errorf("MessageResponse details of %s = %v, want an ErrorInfo with reason %s", id, st.Details(), want.reason)
+		}
+	}
+	if got := collectMetrics(t, reader)["agentcommunication.client.messages.rejected"]; got != 4 {
+		t.Errorf("metric agentcommunication.client.messages.rejected = %d, want 4", got)
+	}
+}
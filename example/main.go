@@ -17,15 +17,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	client "github.com/GoogleCloudPlatform/agentcommunication_client"
 	"google.golang.org/api/option"
 
-	anypb "google.golang.org/protobuf/types/known/anypb"
-	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 var (
@@ -37,7 +39,7 @@ func main() {
 	flag.Parse()
 	ctx := context.Background()
 	client.DefaultAllowVSOCK = true
-	client.DebugLogging = true
+	client.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
 
 	var opts []option.ClientOption
 	if *endpoint != "" {
@@ -54,20 +56,24 @@ func main() {
 
 	go func() {
 		for {
-			msg, err := conn.Receive()
+			m, msg, err := conn.ReceiveProto(ctx)
+			if errors.Is(err, client.ErrUnknownType) || errors.Is(err, client.ErrMalformedPayload) {
+				log.Printf("Got undecodable message %+v: %v", msg, err)
+				continue
+			}
 			if err != nil {
 				log.Println(err)
 				return
 			}
-			log.Printf("Got message: %+v", msg)
+			log.Printf("Got message %s with labels %v", m, msg.GetLabels())
 		}
 	}()
 
-	if err := conn.SendMessage(&acpb.MessageBody{Body: &anypb.Any{Value: []byte("hello world")}}); err != nil {
+	if err := conn.SendProto(ctx, wpb.String("hello world"), nil); err != nil {
 		log.Fatal(err)
 	}
 	time.Sleep(5 * time.Second)
-	if err := conn.SendMessage(&acpb.MessageBody{Body: &anypb.Any{Value: []byte("hello world")}}); err != nil {
+	if err := conn.SendProto(ctx, wpb.String("hello world"), map[string]string{"greeting": "true"}); err != nil {
 		log.Fatal(err)
 	}
 	time.Sleep(60 * time.Second)
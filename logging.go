@@ -0,0 +1,102 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/option/internaloption"
+)
+
+// Attribute keys used in log records.
+const (
+	logKeyChannelID  = "channel_id"
+	logKeyResourceID = "resource_id"
+	logKeyMessageID  = "message_id"
+	logKeyGeneration = "generation"
+	logKeyTransport  = "transport"
+)
+
+var (
+	// DebugLogging enables debug logging to stderr when no logger was set with SetLogger or
+	// WithLogger.
+	//
+	// Deprecated: Use SetLogger or WithLogger instead.
+	DebugLogging = false
+
+	packageLoggerMx sync.RWMutex
+	packageLogger   *slog.Logger
+
+	discardLogger = slog.New(slog.DiscardHandler)
+	debugLogger   = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}))
+)
+
+// SetLogger sets the logger used by package level functions such as SendAgentMessage, by clients
+// created without WithClientLogger and by connections created without WithLogger. Passing nil
+// restores the default, which discards all records unless DebugLogging is set.
+func SetLogger(l *slog.Logger) {
+	packageLoggerMx.Lock()
+	defer packageLoggerMx.Unlock()
+	packageLogger = l
+}
+
+// WithClientLogger returns an option.ClientOption setting the logger of NewClient, or of
+// NewConnection when passed with WithClientOptions: VSOCK detection, metadata initialization and
+// dialing are logged to l instead of the logger set with SetLogger.
+func WithClientLogger(l *slog.Logger) option.ClientOption {
+	return &clientLoggerOption{logger: l}
+}
+
+type clientLoggerOption struct {
+	internaloption.EmbeddableAdapter
+	logger *slog.Logger
+}
+
+// clientLogger returns the logger set by the last WithClientLogger of opts, or fallback if none
+// is.
+func clientLogger(opts []option.ClientOption, fallback *slog.Logger) *slog.Logger {
+	logger := fallback
+	for _, opt := range opts {
+		if o, ok := opt.(*clientLoggerOption); ok && o.logger != nil {
+			logger = o.logger
+		}
+	}
+	return logger
+}
+
+// defaultLogger returns the logger set with SetLogger, falling back to stderr if DebugLogging is
+// set and discarding otherwise.
+func defaultLogger() *slog.Logger {
+	packageLoggerMx.RLock()
+	defer packageLoggerMx.RUnlock()
+	if packageLogger != nil {
+		return packageLogger
+	}
+	if DebugLogging {
+		return debugLogger
+	}
+	return discardLogger
+}
+
+// transportName returns the transport attribute value for a client using VSOCK or not.
+func transportName(usingVSOCK bool) string {
+	if usingVSOCK {
+		return transportVSOCK
+	}
+	return transportNetwork
+}
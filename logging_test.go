@@ -0,0 +1,181 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/option"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes from the connection goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes the JSON log records written so far.
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var recs []map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	for dec.More() {
+		rec := make(map[string]any)
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("Decode() failed: %v", err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func findRecord(recs []map[string]any, msg string) map[string]any {
+	for _, rec := range recs {
+		if rec[slog.MessageKey] == msg {
+			return rec
+		}
+	}
+	return nil
+}
+
+func TestWithLogger(t *testing.T) {
+	ctx := context.Background()
+	metadataInitMx.Lock()
+	metadataInited = false
+	metadataInitMx.Unlock()
+	srv, cc, err := createTestSrv(t)
+	if err != nil {
+		t.Fatalf("createTestSrv() failed: %v", err)
+	}
+	client, err := NewClient(ctx, false, option.WithGRPCConn(cc))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	buf := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	conn, err := NewConnection(ctx, testChannelID, client, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+
+	if err := conn.SendMessage(&acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}}); err != nil {
+		t.Fatalf("SendMessage() failed: %v", err)
+	}
+	events, stop := conn.Subscribe()
+	defer stop()
+	srv.recvErr <- nil
+	for ev := nextEvent(t, events); ev.To != StateReady; ev = nextEvent(t, events) {
+	}
+	conn.Close()
+
+	recs := buf.records(t)
+	for _, rec := range recs {
+		if rec[logKeyChannelID] != testChannelID || rec[logKeyTransport] != transportNetwork || rec[logKeyResourceID] != testResourceID {
+			t.Errorf("record %v is missing connection attributes", rec)
+		}
+	}
+	tests := []struct {
+		msg   string
+		level slog.Level
+		key   string
+	}{
+		{msg: "Sending message", level: slog.LevelDebug, key: logKeyMessageID},
+		{msg: "Received message response", level: slog.LevelDebug, key: logKeyMessageID},
+		{msg: "Stream closed, reconnecting", level: slog.LevelWarn, key: logKeyGeneration},
+		{msg: "Closing connection", level: slog.LevelInfo},
+	}
+	for _, tc := range tests {
+		rec := findRecord(recs, tc.msg)
+		if rec == nil {
+			t.Errorf("no %q record logged", tc.msg)
+			continue
+		}
+		if rec[slog.LevelKey] != tc.level.String() {
+			t.Errorf("%q logged at %v, want %v", tc.msg, rec[slog.LevelKey], tc.level)
+		}
+		if _, ok := rec[tc.key]; tc.key != "" && !ok {
+			t.Errorf("%q record %v is missing %q", tc.msg, rec, tc.key)
+		}
+	}
+}
+
+func TestWithClientLogger(t *testing.T) {
+	ctx := context.Background()
+	metadataInitMx.Lock()
+	metadataInited = false
+	metadataInitMx.Unlock()
+	_, cc, err := createTestSrv(t)
+	if err != nil {
+		t.Fatalf("createTestSrv() failed: %v", err)
+	}
+	var bufs []*syncBuffer
+	for range 2 {
+		buf := &syncBuffer{}
+		logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		if _, err := NewClient(ctx, false, option.WithGRPCConn(cc), WithClientLogger(logger)); err != nil {
+			t.Fatalf("NewClient() failed: %v", err)
+		}
+		bufs = append(bufs, buf)
+	}
+
+	// Metadata is initialized once per process, by the first client.
+	first, second := bufs[0].records(t), bufs[1].records(t)
+	for _, msg := range []string{"Running in GCE", "Dialed target"} {
+		if findRecord(first, msg) == nil {
+			t.Errorf("no %q record logged by the first client", msg)
+		}
+	}
+	if findRecord(second, "Dialed target") == nil {
+		t.Error("no \"Dialed target\" record logged by the second client")
+	}
+	if rec := findRecord(second, "Running in GCE"); rec != nil {
+		t.Errorf("second client logged %v, want metadata initialized once", rec)
+	}
+}
+
+func TestDefaultLogger(t *testing.T) {
+	defer func(d bool) { DebugLogging = d }(DebugLogging)
+	defer SetLogger(nil)
+
+	DebugLogging = false
+	if got := defaultLogger(); got != discardLogger {
+		t.Errorf("defaultLogger() = %v, want discard logger", got)
+	}
+	DebugLogging = true
+	if got := defaultLogger(); got != debugLogger {
+		t.Errorf("defaultLogger() with DebugLogging = %v, want stderr logger", got)
+	}
+	logger := slog.New(slog.DiscardHandler)
+	SetLogger(logger)
+	if got := defaultLogger(); got != logger {
+		t.Errorf("defaultLogger() after SetLogger() = %v, want %v", got, logger)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
 	"time"
@@ -44,9 +45,14 @@ var (
 	protectedResourceID     string
 	protectedUniverseDomain string
 	protectedIDToken        = &cachedIDToken{}
+
+	// metadataLogger is the logger of the client initializing the metadata, set while
+	// MetadataInitFunc runs.
+	metadataLogger *slog.Logger
 )
 
-func metadataInit() error {
+// metadataInit initializes the metadata once per process, logging to logger.
+func metadataInit(logger *slog.Logger) error {
 	metadataInitMx.Lock()
 	defer metadataInitMx.Unlock()
 
@@ -54,9 +60,11 @@ func metadataInit() error {
 		return nil
 	}
 
+	metadataLogger = logger
 	metadataInitData, err := MetadataInitFunc()
+	metadataLogger = nil
 	if err != nil {
-		loggerPrintf("Failed to initialize metadata: %v", err)
+		logger.Error("Failed to initialize metadata", "error", err)
 		return err
 	}
 	if metadataInitData == nil {
@@ -100,7 +108,11 @@ func getUniverseDomain() string {
 }
 
 func initGCEMetadata() (*MetadataInitData, error) {
-	loggerPrintf("Running in GCE")
+	logger := metadataLogger
+	if logger == nil {
+		logger = defaultLogger()
+	}
+	logger.Debug("Running in GCE")
 	ctx := context.Background()
 
 	zone, err := getGCEZone(ctx)
@@ -111,7 +123,7 @@ func initGCEMetadata() (*MetadataInitData, error) {
 	if err != nil {
 		return nil, err
 	}
-	universeDomain, err := getGCEUniverseDomain(ctx)
+	universeDomain, err := getGCEUniverseDomain(ctx, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -148,12 +160,12 @@ func getGCEResourceID(ctx context.Context, zone string) (string, error) {
 	return fmt.Sprintf("projects/%s/zones/%s/instances/%s", projectNum, zone, instanceID), nil
 }
 
-func getGCEUniverseDomain(ctx context.Context) (string, error) {
+func getGCEUniverseDomain(ctx context.Context, logger *slog.Logger) (string, error) {
 	universeDomain, err := metadata.GetWithContext(ctx, "universe/universe-domain")
 	// For now fail open if the universe domain is not set, this should be moved to a checking the
 	// HTTP response in the future (only fail open on 404).
 	if err != nil || universeDomain == "" {
-		loggerPrintf("Universe domain is not set, using googleapis.com")
+		logger.Debug("Universe domain is not set, using googleapis.com")
 		universeDomain = defaultUniverseDomain
 	}
 
@@ -162,7 +174,7 @@ func getGCEUniverseDomain(ctx context.Context) (string, error) {
 		return "", &ErrUnsupportedUniverse{universe: universeDomain}
 	}
 
-	loggerPrintf("Universe domain is set to %q", universeDomain)
+	logger.Debug("Universe domain is set", "universe_domain", universeDomain)
 	return universeDomain, nil
 }
 
@@ -0,0 +1,206 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	meterName = "github.com/GoogleCloudPlatform/agentcommunication_client"
+
+	attrChannelID = attribute.Key("agentcommunication.channel_id")
+	attrTransport = attribute.Key("agentcommunication.transport")
+	attrCause     = attribute.Key("agentcommunication.reconnect.cause")
+	attrMessageID = attribute.Key("agentcommunication.message_id")
+	attrDirection = attribute.Key("agentcommunication.direction")
+	attrEncoding  = attribute.Key("agentcommunication.encoding")
+	attrBatchSize = attribute.Key("agentcommunication.batch.size")
+	attrReason    = attribute.Key("agentcommunication.reject.reason")
+
+	transportVSOCK   = "vsock"
+	transportNetwork = "network"
+
+	directionSent     = "sent"
+	directionReceived = "received"
+	// Encoding attribute of payloads without EncodingLabel.
+	encodingIdentity = "identity"
+)
+
+// connectionMetrics records OpenTelemetry metrics for a single Connection. A nil
+// *connectionMetrics records nothing.
+type connectionMetrics struct {
+	attrs  metric.MeasurementOption
+	logger *slog.Logger
+
+	messagesSent      metric.Int64Counter
+	messagesReceived  metric.Int64Counter
+	bytesSent         metric.Int64Counter
+	bytesReceived     metric.Int64Counter
+	ackLatency        metric.Float64Histogram
+	resourceExhausted metric.Int64Counter
+	timeouts          metric.Int64Counter
+	reconnects        metric.Int64Counter
+	payloadRawBytes   metric.Int64Counter
+	payloadWireBytes  metric.Int64Counter
+	rejections        metric.Int64Counter
+
+	registration metric.Registration
+}
+
+// newConnectionMetrics creates the instruments for c, the limit gauges read c's current limits.
+func newConnectionMetrics(mp metric.MeterProvider, c *Connection) (*connectionMetrics, error) {
+	m := &connectionMetrics{
+		attrs:  metric.WithAttributeSet(attribute.NewSet(attrChannelID.String(c.channelID), attrTransport.String(transportName(c.usingVSOCK)))),
+		logger: c.logger,
+	}
+	meter := mp.Meter(meterName)
+
+	var err, errs error
+	m.messagesSent, err = meter.Int64Counter("agentcommunication.client.messages.sent", metric.WithDescription("Messages written to the stream, including retries."), metric.WithUnit("{message}"))
+	errs = errors.Join(errs, err)
+	m.messagesReceived, err = meter.Int64Counter("agentcommunication.client.messages.received", metric.WithDescription("Messages received from the stream."), metric.WithUnit("{message}"))
+	errs = errors.Join(errs, err)
+	m.bytesSent, err = meter.Int64Counter("agentcommunication.client.bytes.sent", metric.WithDescription("Serialized size of messages written to the stream."), metric.WithUnit("By"))
+	errs = errors.Join(errs, err)
+	m.bytesReceived, err = meter.Int64Counter("agentcommunication.client.bytes.received", metric.WithDescription("Serialized size of messages received from the stream."), metric.WithUnit("By"))
+	errs = errors.Join(errs, err)
+	m.ackLatency, err = meter.Float64Histogram("agentcommunication.client.ack.latency", metric.WithDescription("Time between writing a message and receiving its MessageResponse."), metric.WithUnit("s"))
+	errs = errors.Join(errs, err)
+	m.resourceExhausted, err = meter.Int64Counter("agentcommunication.client.resource_exhausted", metric.WithDescription("Messages the service responded to with ResourceExhausted."), metric.WithUnit("{message}"))
+	errs = errors.Join(errs, err)
+	m.timeouts, err = meter.Int64Counter("agentcommunication.client.timeouts", metric.WithDescription("Messages that timed out waiting for a MessageResponse."), metric.WithUnit("{message}"))
+	errs = errors.Join(errs, err)
+	m.reconnects, err = meter.Int64Counter("agentcommunication.client.reconnects", metric.WithDescription("Stream reconnects by cause."), metric.WithUnit("{reconnect}"))
+	errs = errors.Join(errs, err)
+	m.payloadRawBytes, err = meter.Int64Counter("agentcommunication.client.payload.raw_bytes", metric.WithDescription("Payload size of messages before compression when sent and after decompression when received."), metric.WithUnit("By"))
+	errs = errors.Join(errs, err)
+	m.payloadWireBytes, err = meter.Int64Counter("agentcommunication.client.payload.wire_bytes", metric.WithDescription("Payload size of messages as written to or read from the stream."), metric.WithUnit("By"))
+	errs = errors.Join(errs, err)
+	m.rejections, err = meter.Int64Counter("agentcommunication.client.messages.rejected", metric.WithDescription("Received messages rejected with a non OK MessageResponse, by reason."), metric.WithUnit("{message}"))
+	errs = errors.Join(errs, err)
+	rateLimit, err := meter.Int64ObservableGauge("agentcommunication.client.rate_limit", metric.WithDescription("Message rate limit advertised by the service."), metric.WithUnit("{message}/min"))
+	errs = errors.Join(errs, err)
+	bandwidthLimit, err := meter.Int64ObservableGauge("agentcommunication.client.bandwidth_limit", metric.WithDescription("Message bandwidth limit advertised by the service."), metric.WithUnit("By/min"))
+	errs = errors.Join(errs, err)
+	if errs != nil {
+		return nil, errs
+	}
+
+	m.registration, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(rateLimit, int64(c.MessageRateLimit()), m.attrs)
+		o.ObserveInt64(bandwidthLimit, int64(c.MessageBandwidthLimit()), m.attrs)
+		return nil
+	}, rateLimit, bandwidthLimit)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// initMetrics sets up metrics if a meter provider was configured, it must be called once the
+// transport is known.
+func (c *Connection) initMetrics() {
+	if c.meterProvider == nil {
+		return
+	}
+	m, err := newConnectionMetrics(c.meterProvider, c)
+	if err != nil {
+		c.logger.Warn("Error creating metrics, metrics are disabled", "error", err)
+		return
+	}
+	c.metrics = m
+}
+
+func (m *connectionMetrics) close() {
+	if m == nil {
+		return
+	}
+	if err := m.registration.Unregister(); err != nil {
+		m.logger.Warn("Error unregistering metrics callback", "error", err)
+	}
+}
+
+func (m *connectionMetrics) sent(size int) {
+	if m == nil {
+		return
+	}
+	ctx := context.Background()
+	m.messagesSent.Add(ctx, 1, m.attrs)
+	m.bytesSent.Add(ctx, int64(size), m.attrs)
+}
+
+func (m *connectionMetrics) received(size int) {
+	if m == nil {
+		return
+	}
+	ctx := context.Background()
+	m.messagesReceived.Add(ctx, 1, m.attrs)
+	m.bytesReceived.Add(ctx, int64(size), m.attrs)
+}
+
+func (m *connectionMetrics) acked(latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.ackLatency.Record(context.Background(), latency.Seconds(), m.attrs)
+}
+
+func (m *connectionMetrics) resourceExhaustedResponse() {
+	if m == nil {
+		return
+	}
+	m.resourceExhausted.Add(context.Background(), 1, m.attrs)
+}
+
+func (m *connectionMetrics) timeout() {
+	if m == nil {
+		return
+	}
+	m.timeouts.Add(context.Background(), 1, m.attrs)
+}
+
+func (m *connectionMetrics) reconnect(cause string) {
+	if m == nil {
+		return
+	}
+	m.reconnects.Add(context.Background(), 1, m.attrs, metric.WithAttributes(attrCause.String(cause)))
+}
+
+func (m *connectionMetrics) rejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejections.Add(context.Background(), 1, m.attrs, metric.WithAttributes(attrReason.String(reason)))
+}
+
+func (m *connectionMetrics) payload(direction, encoding string, raw, wire int) {
+	if m == nil {
+		return
+	}
+	if encoding == "" {
+		encoding = encodingIdentity
+	}
+	ctx := context.Background()
+	attrs := metric.WithAttributes(attrDirection.String(direction), attrEncoding.String(encoding))
+	m.payloadRawBytes.Add(ctx, int64(raw), m.attrs, attrs)
+	m.payloadWireBytes.Add(ctx, int64(wire), m.attrs, attrs)
+}
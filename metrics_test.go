@@ -0,0 +1,133 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/api/option"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// collectMetrics returns the int64 sum or gauge value, or the histogram count, of each metric
+// recorded by reader, keyed by metric name.
+func collectMetrics(t *testing.T, reader sdkmetric.Reader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() failed: %v", err)
+	}
+	got := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					if v, ok := dp.Attributes.Value(attrChannelID); !ok || v.AsString() != testChannelID {
+						t.Errorf("%s attributes = %v, want channel ID %q", m.Name, dp.Attributes, testChannelID)
+					}
+					if v, ok := dp.Attributes.Value(attrTransport); !ok || v.AsString() != transportNetwork {
+						t.Errorf("%s attributes = %v, want transport %q", m.Name, dp.Attributes, transportNetwork)
+					}
+					got[m.Name] += dp.Value
+				}
+			case metricdata.Gauge[int64]:
+				for _, dp := range data.DataPoints {
+					got[m.Name] += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					got[m.Name] += int64(dp.Count)
+				}
+			}
+		}
+	}
+	return got
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	metadataInitMx.Lock()
+	metadataInited = false
+	metadataInitMx.Unlock()
+	srv, cc, err := createTestSrv(t)
+	if err != nil {
+		t.Fatalf("createTestSrv() failed: %v", err)
+	}
+	client, err := NewClient(ctx, false, option.WithGRPCConn(cc))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	conn, err := NewConnection(ctx, testChannelID, client, WithMeterProvider(mp))
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+	defer conn.Close()
+
+	msg := &acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}}
+	if err := conn.SendMessage(msg); err != nil {
+		t.Fatalf("SendMessage() failed: %v", err)
+	}
+	srv.send <- &acpb.StreamAgentMessagesResponse{MessageId: "test-message-id", Type: &acpb.StreamAgentMessagesResponse_MessageBody{MessageBody: msg}}
+	if _, err := conn.Receive(); err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+	events, stop := conn.Subscribe()
+	defer stop()
+	srv.recvErr <- nil
+	for ev := nextEvent(t, events); ev.To != StateReady; ev = nextEvent(t, events) {
+	}
+
+	got := collectMetrics(t, reader)
+	want := map[string]int64{
+		"agentcommunication.client.messages.sent":     1,
+		"agentcommunication.client.messages.received": 1,
+		"agentcommunication.client.bytes.sent":        int64(len("test-body") + 4),
+		"agentcommunication.client.bytes.received":    int64(len("test-body") + 4),
+		"agentcommunication.client.ack.latency":       1,
+		"agentcommunication.client.reconnects":        1,
+		"agentcommunication.client.rate_limit":        metadataMessageRateLimitValue,
+		"agentcommunication.client.bandwidth_limit":   metadataBandwidthLimitValue,
+		// Uncompressed, sent and received.
+		"agentcommunication.client.payload.raw_bytes":  int64(2 * len("test-body")),
+		"agentcommunication.client.payload.wire_bytes": int64(2 * len("test-body")),
+	}
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("metric %s = %d, want %d", name, got[name], w)
+		}
+	}
+}
+
+func TestMetrics_Disabled(t *testing.T) {
+	// A nil *connectionMetrics must be safe to use.
+	var m *connectionMetrics
+	m.sent(1)
+	m.received(1)
+	m.acked(0)
+	m.resourceExhaustedResponse()
+	m.timeout()
+	m.reconnect("EOF")
+	m.payload(directionSent, "", 1, 1)
+	m.rejected(RejectReasonInvalid)
+	m.close()
+}
@@ -0,0 +1,374 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"log/slog"
+	"maps"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	defaultTimeToWaitForResp = 2 * time.Second
+	// The stream timeout is well above the service side timeout, the service is expected to close
+	// the stream first.
+	defaultStreamTimeout = 60 * time.Minute
+	// Number of times SendMessage retries a message on timeout or ResourceExhausted.
+	defaultSendRetries = 4
+)
+
+var defaultKeepaliveParams = keepalive.ClientParameters{Time: 60 * time.Second, Timeout: 10 * time.Second}
+
+// ConnectionOption configures a Connection. Options only affect the Connection they are passed to.
+type ConnectionOption func(*Connection)
+
+// WithAckTimeout sets how long a send waits for the service to acknowledge a message before
+// timing out with ErrMessageTimeout. Defaults to 2 seconds.
+func WithAckTimeout(d time.Duration) ConnectionOption {
+	return func(c *Connection) {
+		if d > 0 {
+			c.timeToWaitForResp = d
+		}
+	}
+}
+
+// WithStreamLifetime sets the client side timeout of each underlying stream, once reached the
+// stream is closed and a new one is created. Defaults to 60 minutes.
+func WithStreamLifetime(d time.Duration) ConnectionOption {
+	return func(c *Connection) {
+		if d > 0 {
+			c.streamTimeout = d
+		}
+	}
+}
+
+// WithMaxResourceExhaustedRetries sets how many times stream creation is retried when the service
+// returns ResourceExhausted before the connection is closed. Defaults to 20.
+func WithMaxResourceExhaustedRetries(n int) ConnectionOption {
+	return func(c *Connection) {
+		if n >= 0 {
+			c.maxResourceExhaustedRetries = n
+		}
+	}
+}
+
+// WithMaxUnavailableRetries sets how many times stream creation is retried when the service
+// returns Unavailable before the connection is closed. Defaults to 5.
+func WithMaxUnavailableRetries(n int) ConnectionOption {
+	return func(c *Connection) {
+		if n >= 0 {
+			c.maxUnavailableRetries = n
+		}
+	}
+}
+
+// WithReconnectBackoff sets the backoff used between stream reconnect attempts after the service
+// returned ResourceExhausted or Unavailable. By default ResourceExhausted waits 1s more on every
+// attempt up to 10s and Unavailable 200ms more on every attempt starting with an immediate retry.
+func WithReconnectBackoff(policy BackoffPolicy) ConnectionOption {
+	return func(c *Connection) {
+		if policy != nil {
+			c.resourceExhaustedBackoff = policy
+			c.unavailableBackoff = policy
+		}
+	}
+}
+
+// WithSendBackoff sets the backoff used between send attempts after the service responded to a
+// message with ResourceExhausted. By default the delay starts at 250ms and grows by 250ms on every
+// attempt. Sends that timed out waiting for an ack are retried immediately.
+func WithSendBackoff(policy BackoffPolicy) ConnectionOption {
+	return func(c *Connection) {
+		if policy != nil {
+			c.sendBackoff = policy
+		}
+	}
+}
+
+// WithMaxRetryElapsedTime limits the total time spent retrying a single reconnect or send, once
+// the next backoff would exceed it a *RetryError is returned. Defaults to 0 (no limit), retries are
+// then only limited by count.
+func WithMaxRetryElapsedTime(d time.Duration) ConnectionOption {
+	return func(c *Connection) {
+		if d >= 0 {
+			c.maxRetryElapsedTime = d
+		}
+	}
+}
+
+// WithReceiveBufferSize sets the number of received messages that are buffered before Receive is
+// called. Defaults to 0 (unbuffered), meaning each message must be picked up by Receive before the
+// next one is read from the stream.
+func WithReceiveBufferSize(n int) ConnectionOption {
+	return func(c *Connection) {
+		if n >= 0 {
+			c.receiveBufferSize = n
+		}
+	}
+}
+
+// WithSendWindow sets how many messages sent with SendAsync or SendMessages can be outstanding,
+// that is queued or waiting for their ack, at once. Defaults to 64.
+func WithSendWindow(n int) ConnectionOption {
+	return func(c *Connection) {
+		if n > 0 {
+			c.sendWindowSize = n
+		}
+	}
+}
+
+// WithManualAck stops acknowledging received messages as soon as they are read from the stream,
+// the application acknowledges them with Envelope.Ack once handled instead, see ReceiveEnvelope.
+// Messages that are not acknowledged, for example because the process crashed while handling them,
+// are delivered again by the service, giving at-least-once delivery. Receive and ReceiveContext
+// acknowledge the messages they return, Serve acknowledges messages once their handler returned.
+//
+// A message not acknowledged within deadline after ReceiveEnvelope returned it is acknowledged
+// automatically and a warning is logged, unless expired is set, in which case expired is called
+// with the message instead and the message remains unacknowledged. Time spent waiting to be
+// received does not count towards the deadline. deadline defaults to 1 minute. The fragments of
+// chunked messages are acknowledged as they arrive, except the last one which is acknowledged
+// along with the reassembled message.
+func WithManualAck(deadline time.Duration, expired func(*Envelope)) ConnectionOption {
+	return func(c *Connection) {
+		c.manualAck = true
+		if deadline > 0 {
+			c.ackDeadline = deadline
+		}
+		c.ackExpired = expired
+	}
+}
+
+// WithRejectPolicy rejects the received messages selected by p automatically: instead of being
+// returned by Receive, they are answered with a non OK MessageResponse telling the service and the
+// sender why, see RejectPolicy. Rejections are counted by reason in the
+// agentcommunication.client.messages.rejected metric, see WithMeterProvider. Messages can also be
+// rejected individually with Envelope.Nack in manual acknowledgement mode.
+func WithRejectPolicy(p RejectPolicy) ConnectionOption {
+	return func(c *Connection) {
+		c.rejectPolicy = &p
+	}
+}
+
+// WithClientRateLimiting enables or disables pacing of sends to the message rate and bandwidth
+// limits advertised by the service. Defaults to enabled, when disabled exceeding the limits results
+// in ResourceExhausted responses from the service.
+func WithClientRateLimiting(enabled bool) ConnectionOption {
+	return func(c *Connection) {
+		c.clientRateLimiting = enabled
+	}
+}
+
+// WithMeterProvider enables OpenTelemetry metrics for the connection using mp. Metrics are
+// attributed by channel ID and transport (vsock or network). Metrics are disabled by default.
+func WithMeterProvider(mp metric.MeterProvider) ConnectionOption {
+	return func(c *Connection) {
+		c.meterProvider = mp
+	}
+}
+
+// WithTracerProvider enables OpenTelemetry tracing for the connection using tp. Spans are created
+// around sends, waiting for acks and reconnects, and the span context of each send is propagated
+// to the receiver in the TraceParentLabel and TraceStateLabel labels, see ReceiveTraced.
+// Tracing is disabled by default.
+func WithTracerProvider(tp trace.TracerProvider) ConnectionOption {
+	return func(c *Connection) {
+		if tp != nil {
+			c.tracer = tp.Tracer(tracerName)
+			c.tracePropagation = true
+		}
+	}
+}
+
+// WithLogger sets the logger for the connection, and for its client if NewConnection is passed a
+// nil client. Records carry the channel ID, transport and resource ID, per message records are
+// logged at debug level, reconnects at warn and closes caused by errors at error level. Defaults to
+// the logger set with SetLogger.
+func WithLogger(l *slog.Logger) ConnectionOption {
+	return func(c *Connection) {
+		c.logger = l
+	}
+}
+
+// WithTypeResolver sets the resolver used by ReceiveProto to find the message type of a received
+// type URL. Defaults to protoregistry.GlobalTypes, which holds every linked in generated message.
+func WithTypeResolver(r protoregistry.MessageTypeResolver) ConnectionOption {
+	return func(c *Connection) {
+		if r != nil {
+			c.typeResolver = r
+		}
+	}
+}
+
+// WithChunking splits the payload of sent messages larger than threshold bytes into fragments
+// of at most threshold bytes, tracked with the ChunkIDLabel, ChunkIndexLabel, ChunkCountLabel and
+// ChunkSizeLabel labels, and reassembled by the receiving Connection. Fragments are sent one
+// after the other and each is paced under the bandwidth limit like any other message, a fragment
+// is never larger than the bandwidth limit. Chunking is disabled by default, reassembly is always
+// enabled.
+func WithChunking(threshold int) ConnectionOption {
+	return func(c *Connection) {
+		if threshold >= 0 {
+			c.chunkThreshold = threshold
+		}
+	}
+}
+
+// WithReassemblyTimeout sets how long received fragments of a message are kept waiting for the
+// missing ones before the message is dropped. Defaults to 1 minute.
+func WithReassemblyTimeout(d time.Duration) ConnectionOption {
+	return func(c *Connection) {
+		if d > 0 {
+			c.reassemblyTimeout = d
+		}
+	}
+}
+
+// WithMaxMessageSize caps the payload size of reassembled and decompressed messages, and the total
+// size of the fragments buffered for incomplete messages. Larger messages are dropped, or returned
+// by Receive as a *DecodeError wrapping ErrMessageTooLarge once decompressed. Defaults to 64 MiB.
+func WithMaxMessageSize(n int) ConnectionOption {
+	return func(c *Connection) {
+		if n > 0 {
+			c.maxMessageSize = n
+		}
+	}
+}
+
+// WithCompression compresses the payload of sent messages larger than threshold bytes with algo,
+// and marks them with the EncodingLabel label. Payloads that do not shrink are sent as is.
+// Compression happens before chunking, see WithChunking. Compression is disabled by default,
+// received payloads are always decompressed.
+func WithCompression(algo Compression, threshold int) ConnectionOption {
+	return func(c *Connection) {
+		c.compression = algo
+		c.compressionThreshold = max(threshold, 0)
+	}
+}
+
+// WithEncryption encrypts the payload of sent messages with c and the current key of keys, and
+// decrypts received messages with c and the key named by their KeyIDLabel label. The labels and
+// type URL of messages are not encrypted but authenticated. Received messages that are not
+// encrypted, whose cipher is not c, whose key is unknown or that were tampered with are returned
+// by Receive as a *DecodeError wrapping ErrDecryption. Encryption happens after compression and
+// before chunking.
+func WithEncryption(c Cipher, keys KeyProvider) ConnectionOption {
+	return func(conn *Connection) {
+		conn.encryption = &encryptionCodec{cipher: c, keys: keys}
+	}
+}
+
+// WithSigning signs sent messages with s. The signature covers the payload, labels and type URL
+// of a message as well as a timestamp and a random nonce, see WithVerification. Signing happens
+// before compression and encryption.
+func WithSigning(s Signer) ConnectionOption {
+	return func(c *Connection) {
+		c.signer = s
+	}
+}
+
+// WithVerification requires received messages to be signed by one of keys, see WithSigning.
+// Messages signed more than maxSkew before or after the local time, and messages whose nonce was
+// already received, are refused; maxSkew defaults to 5 minutes. Nonces are recorded until their
+// message would be stale, up to 100000 of them, further messages are refused until some expire.
+// Refused messages are returned by Receive as a *DecodeError wrapping ErrUnsignedMessage,
+// ErrInvalidSignature, ErrStaleMessage, ErrReplayedMessage or ErrReplayCacheFull.
+func WithVerification(keys *TrustedKeys, maxSkew time.Duration) ConnectionOption {
+	return func(c *Connection) {
+		c.trustedKeys = keys
+		if maxSkew > 0 {
+			c.maxClockSkew = maxSkew
+		}
+	}
+}
+
+// WithOutbox records sent messages in o before sending them, see Outbox. A message whose send
+// fails because the stream is down, the server is overloaded or ctx is done stays in the outbox,
+// SendMessage returns an error wrapping ErrOutboxed, and the message is resent after the next
+// stream is established, by this connection or by the next connection using the outbox after a
+// restart. Messages are recorded before they are signed, compressed or encrypted, so that they
+// are encoded again when resent. Resent messages may be delivered twice and may be overtaken by
+// newer messages. The outbox is not closed with the connection.
+func WithOutbox(o *Outbox) ConnectionOption {
+	return func(c *Connection) {
+		c.outbox = o
+	}
+}
+
+// WithKeepaliveParams sets the gRPC keepalive parameters. Keepalive is a property of the
+// underlying gRPC connection, so this option only takes effect when the Connection creates its
+// own client, that is when NewConnection is passed a nil client.
+func WithKeepaliveParams(kp keepalive.ClientParameters) ConnectionOption {
+	return func(c *Connection) {
+		c.keepaliveParams = kp
+	}
+}
+
+// WithClientOptions sets the options and endpoint type used when the Connection creates its own
+// client, that is when NewConnection is passed a nil client. See NewClient.
+func WithClientOptions(regional bool, opts ...option.ClientOption) ConnectionOption {
+	return func(c *Connection) {
+		c.regional = regional
+		c.clientOpts = opts
+	}
+}
+
+// SendOption configures a single call to SendMessageContext.
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	retries    int
+	ackTimeout time.Duration
+	labels     map[string]string
+	// Called before the message is handed to the stream, the send fails with ErrSendCanceled if
+	// it returns false. See PendingSend.
+	claim func() bool
+}
+
+// WithSendRetries sets how many times the message is resent on timeout or ResourceExhausted,
+// 0 disables retries. Defaults to 4.
+func WithSendRetries(n int) SendOption {
+	return func(o *sendOptions) {
+		if n >= 0 {
+			o.retries = n
+		}
+	}
+}
+
+// WithSendAckTimeout overrides the Connection's ack timeout (see WithAckTimeout) for this message.
+// The timeout applies to each attempt.
+func WithSendAckTimeout(d time.Duration) SendOption {
+	return func(o *sendOptions) {
+		if d > 0 {
+			o.ackTimeout = d
+		}
+	}
+}
+
+// WithSendLabels adds labels to the message, overriding any message labels with the same key.
+func WithSendLabels(labels map[string]string) SendOption {
+	return func(o *sendOptions) {
+		if o.labels == nil {
+			o.labels = make(map[string]string, len(labels))
+		}
+		maps.Copy(o.labels, labels)
+	}
+}
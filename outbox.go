@@ -0,0 +1,667 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	defaultSegmentSize   = 4 << 20
+	defaultOutboxMaxSize = 64 << 20
+	defaultOutboxMaxAge  = 24 * time.Hour
+	defaultSyncInterval  = time.Second
+
+	segmentExt = ".seg"
+
+	// Record kinds, a put records a message and an ack removes it.
+	recordPut byte = 1
+	recordAck byte = 2
+	// Length and CRC-32C of the record payload.
+	recordHeaderSize = 8
+)
+
+var (
+	// ErrOutboxed is returned by SendMessage, along with the send error, when a message could not be
+	// sent but remains in the outbox of the connection and will be resent, see WithOutbox. The
+	// message must not be sent again by the caller.
+	ErrOutboxed = errors.New("message kept in outbox")
+	// ErrOutboxFull is passed to the eviction callback for messages evicted because the outbox
+	// exceeded its max size, and returned when a message is larger than the max size.
+	ErrOutboxFull = errors.New("outbox full")
+	// ErrOutboxExpired is passed to the eviction callback for messages evicted because they
+	// exceeded the max age of the outbox.
+	ErrOutboxExpired = errors.New("outbox message expired")
+	// ErrOutboxClosed is returned when recording a message in a closed outbox.
+	ErrOutboxClosed = errors.New("outbox closed")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SyncPolicy sets when the outbox flushes its writes to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs after every write, no acknowledged send is lost on a crash.
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodic syncs at a fixed interval, messages recorded since the last sync can be lost
+	// if the machine crashes.
+	SyncPeriodic
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+type outboxOptions struct {
+	sync         SyncPolicy
+	syncInterval time.Duration
+	segmentSize  int64
+	maxSize      int
+	maxAge       time.Duration
+	onEvict      func(msg *acpb.MessageBody, reason error)
+}
+
+// OutboxOption configures an Outbox.
+type OutboxOption func(*outboxOptions)
+
+// WithSyncPolicy sets when writes are synced, interval applies to SyncPeriodic only and defaults
+// to 1 second. Defaults to SyncAlways.
+func WithSyncPolicy(p SyncPolicy, interval time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.sync = p
+		if interval > 0 {
+			o.syncInterval = interval
+		}
+	}
+}
+
+// WithSegmentSize sets the size past which a new segment file is started. Defaults to 4 MiB.
+func WithSegmentSize(n int) OutboxOption {
+	return func(o *outboxOptions) {
+		if n > 0 {
+			o.segmentSize = int64(n)
+		}
+	}
+}
+
+// WithOutboxMaxSize caps the total size of the messages in the outbox, the oldest messages are
+// evicted to make room for new ones. Messages being sent are not evicted, so the outbox can
+// temporarily exceed the cap. Defaults to 64 MiB.
+func WithOutboxMaxSize(n int) OutboxOption {
+	return func(o *outboxOptions) {
+		if n > 0 {
+			o.maxSize = n
+		}
+	}
+}
+
+// WithOutboxMaxAge sets how long a message stays in the outbox before it is evicted. Defaults to
+// 24 hours.
+func WithOutboxMaxAge(d time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		if d > 0 {
+			o.maxAge = d
+		}
+	}
+}
+
+// WithEvictionCallback sets a function called with every message evicted from the outbox before
+// it was acknowledged, reason is ErrOutboxFull or ErrOutboxExpired. f is called synchronously and
+// must not use the outbox.
+func WithEvictionCallback(f func(msg *acpb.MessageBody, reason error)) OutboxOption {
+	return func(o *outboxOptions) {
+		o.onEvict = f
+	}
+}
+
+// outboxEntry is a message in the outbox.
+type outboxEntry struct {
+	seq     uint64
+	created time.Time
+	msg     *acpb.MessageBody
+	size    int
+	seg     *segment
+	elem    *list.Element
+	// Set while a send of the message is in progress, the message is not replayed meanwhile.
+	owned bool
+}
+
+// segment is a segment file, named after the sequence number it starts at.
+type segment struct {
+	path string
+	size int64
+	// Number of entries recorded in the segment and not removed yet.
+	live int
+}
+
+// Outbox is a durable store of the messages sent on a Connection, see WithOutbox. Messages are
+// appended to segment files in a directory before they are sent and removed once the server
+// acknowledged them, so that messages whose send failed, including because the process stopped,
+// are resent. Segment files are deleted once all their messages are removed.
+//
+// Outbox is safe for concurrent use, a directory must only be used by a single Outbox at a time.
+type Outbox struct {
+	dir  string
+	opts outboxOptions
+
+	mu       sync.Mutex
+	closed   bool
+	entries  map[uint64]*outboxEntry
+	order    *list.List
+	size     int
+	nextSeq  uint64
+	segments []*segment
+	// The last segment, open for appending.
+	file  *os.File
+	dirty bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// OpenOutbox opens the outbox stored in dir, creating dir if it does not exist, and loads the
+// messages left by a previous process. A partially written record at the end of the last segment,
+// left by a crash, is discarded.
+func OpenOutbox(dir string, opts ...OutboxOption) (*Outbox, error) {
+	o := &Outbox{
+		dir: dir,
+		opts: outboxOptions{
+			syncInterval: defaultSyncInterval,
+			segmentSize:  defaultSegmentSize,
+			maxSize:      defaultOutboxMaxSize,
+			maxAge:       defaultOutboxMaxAge,
+		},
+		entries: make(map[uint64]*outboxEntry),
+		order:   list.New(),
+		nextSeq: 1,
+	}
+	for _, opt := range opts {
+		opt(&o.opts)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	if len(o.segments) == 0 {
+		if err := o.startSegment(); err != nil {
+			return nil, err
+		}
+	} else {
+		seg := o.segments[len(o.segments)-1]
+		f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		o.file = f
+	}
+
+	o.mu.Lock()
+	evicted, err := o.evictLocked(time.Now(), 0)
+	o.mu.Unlock()
+	o.notify(evicted)
+	if err != nil {
+		o.file.Close()
+		return nil, fmt.Errorf("evicting outbox messages: %w", err)
+	}
+
+	if o.opts.sync == SyncPeriodic {
+		o.stop, o.done = make(chan struct{}), make(chan struct{})
+		go o.syncLoop()
+	}
+	return o, nil
+}
+
+// load reads the segment files in order and replays their records. A segment abandoned after a
+// failed write (see writeLocked) ends with a partial record, which is discarded like a torn write
+// at the end of the last segment.
+func (o *Outbox) load() error {
+	paths, err := filepath.Glob(filepath.Join(o.dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	// Names are zero padded, sorting them sorts the segments by sequence number.
+	slices.Sort(paths)
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		seg := &segment{path: path}
+		o.segments = append(o.segments, seg)
+		for off := 0; off < len(data); {
+			n, err := o.apply(seg, data[off:])
+			if err != nil {
+				if i < len(paths)-1 && !errors.Is(err, errTornRecord) {
+					return fmt.Errorf("reading outbox segment %s at offset %d: %w", path, off, err)
+				}
+				// A torn write at the end of the segment.
+				if err := os.Truncate(path, int64(off)); err != nil {
+					return err
+				}
+				break
+			}
+			off += n
+			seg.size = int64(off)
+		}
+	}
+	return nil
+}
+
+// errTornRecord is returned by apply for a record that was not completely written.
+var errTornRecord = errors.New("torn record")
+
+// apply replays the record at the start of data, it returns the size of the record.
+func (o *Outbox) apply(seg *segment, data []byte) (int, error) {
+	if len(data) < recordHeaderSize {
+		return 0, fmt.Errorf("%w: truncated record header", errTornRecord)
+	}
+	n := int(binary.BigEndian.Uint32(data))
+	if len(data) < recordHeaderSize+n {
+		return 0, fmt.Errorf("%w: truncated record", errTornRecord)
+	}
+	payload := data[recordHeaderSize : recordHeaderSize+n]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(data[4:]) {
+		return 0, fmt.Errorf("%w: record checksum mismatch", errTornRecord)
+	}
+	if len(payload) < 9 {
+		return 0, errors.New("malformed record")
+	}
+	seq := binary.BigEndian.Uint64(payload[1:])
+	switch payload[0] {
+	case recordPut:
+		if len(payload) < 17 {
+			return 0, errors.New("malformed record")
+		}
+		msg := &acpb.MessageBody{}
+		if err := proto.Unmarshal(payload[17:], msg); err != nil {
+			return 0, err
+		}
+		created := time.Unix(0, int64(binary.BigEndian.Uint64(payload[9:])))
+		o.insertLocked(&outboxEntry{seq: seq, created: created, msg: msg, size: len(payload) - 17, seg: seg})
+		o.nextSeq = max(o.nextSeq, seq+1)
+	case recordAck:
+		if e, ok := o.entries[seq]; ok {
+			o.unlinkLocked(e)
+		}
+	default:
+		return 0, fmt.Errorf("unknown record kind %d", payload[0])
+	}
+	return recordHeaderSize + n, nil
+}
+
+func (o *Outbox) insertLocked(e *outboxEntry) {
+	e.elem = o.order.PushBack(e)
+	e.seg.live++
+	o.entries[e.seq] = e
+	o.size += e.size
+}
+
+func (o *Outbox) unlinkLocked(e *outboxEntry) {
+	o.order.Remove(e.elem)
+	e.seg.live--
+	delete(o.entries, e.seq)
+	o.size -= e.size
+}
+
+// startSegment starts a new segment named after the next sequence number.
+func (o *Outbox) startSegment() error {
+	path := filepath.Join(o.dir, fmt.Sprintf("%020d%s", o.nextSeq, segmentExt))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if o.file != nil {
+		if err := o.file.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		o.file.Close()
+	}
+	o.file = f
+	o.segments = append(o.segments, &segment{path: path})
+	return nil
+}
+
+// writeLocked appends a record to the last segment. A failed write is rolled back, so that later
+// records are not appended after a partial one, or the segment is abandoned for a new one if it
+// cannot be truncated.
+func (o *Outbox) writeLocked(kind byte, seq uint64, created time.Time, body []byte) error {
+	payload := make([]byte, 0, 17+len(body))
+	payload = append(payload, kind)
+	payload = binary.BigEndian.AppendUint64(payload, seq)
+	if kind == recordPut {
+		payload = binary.BigEndian.AppendUint64(payload, uint64(created.UnixNano()))
+		payload = append(payload, body...)
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, crcTable))
+	record = append(record, payload...)
+
+	seg := o.segments[len(o.segments)-1]
+	_, err := o.file.Write(record)
+	if err == nil && o.opts.sync == SyncAlways {
+		err = o.file.Sync()
+	}
+	if err != nil {
+		if terr := o.file.Truncate(seg.size); terr != nil {
+			if serr := o.startSegment(); serr != nil {
+				return errors.Join(err, terr, serr)
+			}
+		}
+		return err
+	}
+	seg.size += int64(len(record))
+	if o.opts.sync == SyncPeriodic {
+		o.dirty = true
+	}
+	return nil
+}
+
+// gcLocked deletes the oldest segments once all their entries are removed. Segments are only
+// deleted oldest first, so that the acks of entries in older segments are never lost.
+func (o *Outbox) gcLocked() {
+	for len(o.segments) > 1 && o.segments[0].live == 0 {
+		os.Remove(o.segments[0].path)
+		o.segments = o.segments[1:]
+	}
+}
+
+type evictedEntry struct {
+	msg    *acpb.MessageBody
+	reason error
+}
+
+// evictLocked removes the expired entries and, oldest first, as many entries as needed to fit
+// another entry of size n. Entries owned by a send are skipped, as the send may still succeed,
+// they are evicted once released. It stops at the first entry whose removal cannot be recorded,
+// which is kept.
+func (o *Outbox) evictLocked(now time.Time, n int) ([]evictedEntry, error) {
+	var evicted []evictedEntry
+	var err error
+	for elem := o.order.Front(); elem != nil; {
+		e := elem.Value.(*outboxEntry)
+		elem = elem.Next()
+		if e.owned {
+			continue
+		}
+		var reason error
+		if now.Sub(e.created) > o.opts.maxAge {
+			reason = ErrOutboxExpired
+		} else if o.size+n > o.opts.maxSize {
+			reason = ErrOutboxFull
+		} else {
+			break
+		}
+		if err = o.writeLocked(recordAck, e.seq, time.Time{}, nil); err != nil {
+			break
+		}
+		o.unlinkLocked(e)
+		evicted = append(evicted, evictedEntry{msg: e.msg, reason: reason})
+	}
+	o.gcLocked()
+	return evicted, err
+}
+
+func (o *Outbox) notify(evicted []evictedEntry) {
+	if o.opts.onEvict == nil {
+		return
+	}
+	for _, e := range evicted {
+		o.opts.onEvict(e.msg, e.reason)
+	}
+}
+
+// add records msg, owned by the caller until released or removed.
+func (o *Outbox) add(msg *acpb.MessageBody) (uint64, error) {
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	if len(body) > o.opts.maxSize {
+		return 0, fmt.Errorf("%w: message of %d bytes exceeds the max size of %d bytes", ErrOutboxFull, len(body), o.opts.maxSize)
+	}
+
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return 0, ErrOutboxClosed
+	}
+	now := time.Now()
+	evicted, err := o.evictLocked(now, len(body))
+	var e *outboxEntry
+	if err != nil {
+		err = fmt.Errorf("evicting outbox messages: %w", err)
+	} else {
+		e, err = o.putLocked(now, msg, body)
+	}
+	o.mu.Unlock()
+	o.notify(evicted)
+	if err != nil {
+		return 0, err
+	}
+	return e.seq, nil
+}
+
+func (o *Outbox) putLocked(now time.Time, msg *acpb.MessageBody, body []byte) (*outboxEntry, error) {
+	if o.segments[len(o.segments)-1].size >= o.opts.segmentSize {
+		if err := o.startSegment(); err != nil {
+			return nil, err
+		}
+	}
+	e := &outboxEntry{seq: o.nextSeq, created: now, msg: msg, size: len(body), seg: o.segments[len(o.segments)-1], owned: true}
+	if err := o.writeLocked(recordPut, e.seq, now, body); err != nil {
+		return nil, err
+	}
+	o.nextSeq++
+	o.insertLocked(e)
+	return e, nil
+}
+
+// remove removes the entry seq, once acknowledged or failed permanently.
+func (o *Outbox) remove(seq uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, ok := o.entries[seq]
+	if !ok || o.closed {
+		// Evicted meanwhile.
+		return nil
+	}
+	o.unlinkLocked(e)
+	err := o.writeLocked(recordAck, seq, time.Time{}, nil)
+	o.gcLocked()
+	return err
+}
+
+// release gives up the ownership of the entry seq, so that it is replayed.
+func (o *Outbox) release(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if e, ok := o.entries[seq]; ok {
+		e.owned = false
+	}
+}
+
+// claimNext returns the oldest entry not owned by a send and takes ownership of it, nil if there
+// is none.
+func (o *Outbox) claimNext() *outboxEntry {
+	o.mu.Lock()
+	// Eviction is retried by the next call if the outbox cannot be written.
+	evicted, _ := o.evictLocked(time.Now(), 0)
+	var next *outboxEntry
+	for elem := o.order.Front(); elem != nil && !o.closed; elem = elem.Next() {
+		if e := elem.Value.(*outboxEntry); !e.owned {
+			e.owned = true
+			next = e
+			break
+		}
+	}
+	o.mu.Unlock()
+	o.notify(evicted)
+	return next
+}
+
+// Len returns the number of messages in the outbox.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Size returns the total size of the messages in the outbox.
+func (o *Outbox) Size() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size
+}
+
+func (o *Outbox) syncLoop() {
+	defer close(o.done)
+	ticker := time.NewTicker(o.opts.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.mu.Lock()
+			if o.dirty && !o.closed {
+				o.file.Sync()
+				o.dirty = false
+			}
+			o.mu.Unlock()
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// Close syncs and closes the outbox, the messages it holds are loaded again by the next
+// OpenOutbox of its directory. Close the connections using the outbox first.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	err := errors.Join(o.file.Sync(), o.file.Close())
+	o.mu.Unlock()
+	if o.stop != nil {
+		close(o.stop)
+		<-o.done
+	}
+	return err
+}
+
+// sendOutboxed records msg in the outbox and sends it. The message is removed from the outbox
+// once acknowledged or if the send failed permanently, and kept to be replayed otherwise.
+func (c *Connection) sendOutboxed(ctx context.Context, msg *acpb.MessageBody, o sendOptions) error {
+	seq, err := c.outbox.add(msg)
+	if err != nil {
+		return fmt.Errorf("recording message in outbox: %w", err)
+	}
+	err = c.sendBody(ctx, msg, o)
+	if err != nil && c.keepInOutbox(ctx, err) {
+		c.outbox.release(seq)
+		return fmt.Errorf("%w: %w", ErrOutboxed, err)
+	}
+	if err := c.outbox.remove(seq); err != nil {
+		c.logger.Warn("Error removing message from outbox", "error", err)
+	}
+	return err
+}
+
+// keepInOutbox reports whether a message whose send failed with err should be resent.
+func (c *Connection) keepInOutbox(ctx context.Context, err error) bool {
+	if errors.Is(err, ErrSendCanceled) || errors.Is(context.Cause(ctx), ErrSendCanceled) {
+		return false
+	}
+	select {
+	case <-c.closed:
+		return true
+	default:
+	}
+	return ctx.Err() != nil || errors.Is(err, ErrMessageTimeout) || errors.Is(err, ErrResourceExhausted) || errors.Is(err, ErrConnectionDraining)
+}
+
+// replayOutbox resends the messages in the outbox not being sent by SendMessage, those whose send
+// failed and those left by a previous process, in the order they were recorded. Replay stops at
+// the first message that cannot be sent, until the next stream is established. A replay requested
+// while another one runs is run once that one returns.
+func (c *Connection) replayOutbox() {
+	select {
+	case c.replayPending <- struct{}{}:
+	default:
+		// A replay is already pending.
+	}
+	for {
+		if !c.replayMx.TryLock() {
+			// The running replay picks up the pending one.
+			return
+		}
+		select {
+		case <-c.replayPending:
+			c.replayOutboxOnce()
+		default:
+		}
+		c.replayMx.Unlock()
+		// A replay requested before the unlock found the mutex held.
+		if len(c.replayPending) == 0 {
+			return
+		}
+	}
+}
+
+func (c *Connection) replayOutboxOnce() {
+	ctx := context.Background()
+	o := sendOptions{retries: defaultSendRetries, ackTimeout: c.timeToWaitForResp}
+	for {
+		e := c.outbox.claimNext()
+		if e == nil {
+			return
+		}
+		c.logger.Debug("Replaying message from outbox", "seq", e.seq, "age", time.Since(e.created))
+		// Replayed messages are in flight sends too, Drain waits for them.
+		err := c.startSend()
+		if err == nil {
+			err = c.sendBody(ctx, e.msg, o)
+			c.inflight.Done()
+		}
+		if err != nil && c.keepInOutbox(ctx, err) {
+			c.outbox.release(e.seq)
+			c.logger.Warn("Error replaying message from outbox, will retry after reconnect", "seq", e.seq, "error", err)
+			return
+		}
+		if err != nil {
+			c.logger.Warn("Dropping message from outbox", "seq", e.seq, "error", err)
+		}
+		if err := c.outbox.remove(e.seq); err != nil {
+			c.logger.Warn("Error removing message from outbox", "error", err)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+func testMessage(value string) *acpb.MessageBody {
+	return &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: []byte(value)}}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatalf("filepath.Glob() failed: %v", err)
+	}
+	return paths
+}
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	o, err := OpenOutbox(dir, WithSegmentSize(100))
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	var seqs []uint64
+	for _, v := range []string{"a", "b", "c", "d"} {
+		seq, err := o.add(testMessage(v))
+		if err != nil {
+			t.Fatalf("add() failed: %v", err)
+		}
+		seqs = append(seqs, seq)
+	}
+	if err := o.remove(seqs[1]); err != nil {
+		t.Fatalf("remove() failed: %v", err)
+	}
+	o.release(seqs[2])
+	if e := o.claimNext(); e == nil || e.seq != seqs[2] {
+		t.Errorf("claimNext() = %v, want the released entry %d", e, seqs[2])
+	}
+	if e := o.claimNext(); e != nil {
+		t.Errorf("claimNext() = %v, want nil while all entries are owned", e)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := o.add(testMessage("e")); !errors.Is(err, ErrOutboxClosed) {
+		t.Errorf("add() after Close() = %v, want %v", err, ErrOutboxClosed)
+	}
+
+	// A torn write at the end of the log is discarded.
+	paths := segmentFiles(t, dir)
+	f, err := os.OpenFile(paths[len(paths)-1], os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("os.OpenFile() failed: %v", err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+
+	// Reopening loads the unacknowledged messages in order, as not owned.
+	o, err = OpenOutbox(dir, WithSegmentSize(100))
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	defer o.Close()
+	if o.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", o.Len())
+	}
+	var got []string
+	for e := o.claimNext(); e != nil; e = o.claimNext() {
+		got = append(got, string(e.msg.GetBody().GetValue()))
+		if diff := cmp.Diff(testMessage(got[len(got)-1]), e.msg, protocmp.Transform()); diff != "" {
+			t.Errorf("loaded message diff (-want +got):\n%s", diff)
+		}
+		if err := o.remove(e.seq); err != nil {
+			t.Fatalf("remove() failed: %v", err)
+		}
+	}
+	if diff := cmp.Diff([]string{"a", "c", "d"}, got); diff != "" {
+		t.Errorf("replayed messages diff (-want +got):\n%s", diff)
+	}
+	// New sequence numbers continue after the loaded ones.
+	seq, err := o.add(testMessage("e"))
+	if err != nil || seq <= seqs[3] {
+		t.Errorf("add() = %d, %v, want a sequence number after %d", seq, err, seqs[3])
+	}
+	o.remove(seq)
+	// Segments are deleted once all their messages are removed.
+	if paths := segmentFiles(t, dir); len(paths) != 1 {
+		t.Errorf("segment files = %v, want only the last one", paths)
+	}
+}
+
+func TestOutbox_Eviction(t *testing.T) {
+	type eviction struct {
+		value  string
+		reason error
+	}
+	var evicted []eviction
+	onEvict := func(msg *acpb.MessageBody, reason error) {
+		evicted = append(evicted, eviction{string(msg.GetBody().GetValue()), reason})
+	}
+	size := proto.Size(testMessage("a"))
+
+	o, err := OpenOutbox(t.TempDir(), WithOutboxMaxSize(2*size), WithEvictionCallback(onEvict))
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	defer o.Close()
+	add := func(v string) uint64 {
+		t.Helper()
+		seq, err := o.add(testMessage(v))
+		if err != nil {
+			t.Fatalf("add() failed: %v", err)
+		}
+		return seq
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		// Failed sends release their message.
+		o.release(add(v))
+	}
+	if _, err := o.add(testMessage("too large for the outbox")); !errors.Is(err, ErrOutboxFull) {
+		t.Errorf("add() of a message larger than the max size = %v, want %v", err, ErrOutboxFull)
+	}
+	if o.Len() != 2 || o.Size() != 2*size {
+		t.Errorf("Len(), Size() = %d, %d, want 2, %d", o.Len(), o.Size(), 2*size)
+	}
+
+	o.opts.maxAge = time.Nanosecond
+	if e := o.claimNext(); e != nil {
+		t.Errorf("claimNext() = %v, want nil once all messages expired", e)
+	}
+	want := []eviction{{"a", ErrOutboxFull}, {"b", ErrOutboxExpired}, {"c", ErrOutboxExpired}}
+	if diff := cmp.Diff(want, evicted, cmp.AllowUnexported(eviction{}), cmp.Comparer(func(a, b error) bool { return a == b })); diff != "" {
+		t.Errorf("evictions diff (-want +got):\n%s", diff)
+	}
+
+	// Messages owned by a send in progress are not evicted.
+	evicted = nil
+	o.opts.maxAge = time.Hour
+	add("d")
+	o.release(add("e"))
+	add("f")
+	want = []eviction{{"e", ErrOutboxFull}}
+	if diff := cmp.Diff(want, evicted, cmp.AllowUnexported(eviction{}), cmp.Comparer(func(a, b error) bool { return a == b })); diff != "" {
+		t.Errorf("evictions with owned messages diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestOutbox_WriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	if _, err := o.add(testMessage("a")); err != nil {
+		t.Fatalf("add() failed: %v", err)
+	}
+	// Writes to a read only file fail and it cannot be truncated, the segment is abandoned.
+	path := segmentFiles(t, dir)[0]
+	ro, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open() failed: %v", err)
+	}
+	o.file.Close()
+	o.file = ro
+	if _, err := o.add(testMessage("b")); err == nil {
+		t.Error("add() with a failing write succeeded, want error")
+	}
+	if _, err := o.add(testMessage("c")); err != nil {
+		t.Fatalf("add() after a failed write failed: %v", err)
+	}
+	o.Close()
+
+	// A partial record left at the end of an abandoned segment is discarded.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("os.OpenFile() failed: %v", err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	defer o.Close()
+	var got []string
+	for e := o.claimNext(); e != nil; e = o.claimNext() {
+		got = append(got, string(e.msg.GetBody().GetValue()))
+	}
+	if diff := cmp.Diff([]string{"a", "c"}, got); diff != "" {
+		t.Errorf("loaded messages diff (-want +got):\n%s", diff)
+	}
+}
+
+func waitForOutbox(t *testing.T, o *Outbox) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+	defer cancel()
+	for o.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for the outbox to empty, %d messages left", o.Len())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func sentValues(srv *testSrv) []string {
+	srv.reqMx.Lock()
+	defer srv.reqMx.Unlock()
+	var values []string
+	for _, req := range srv.req {
+		if req.GetMessageBody() != nil {
+			values = append(values, string(req.GetMessageBody().GetBody().GetValue()))
+		}
+	}
+	return values
+}
+
+func TestSendMessage_Outbox(t *testing.T) {
+	ctx := context.Background()
+	o, err := OpenOutbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	defer o.Close()
+	srv, conn, err := newTestConnection(ctx, t, WithOutbox(o), WithSendBackoff(ConstantBackoff{Delay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	if err := conn.SendMessageContext(ctx, testMessage("acked")); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	if o.Len() != 0 {
+		t.Errorf("Len() = %d after an acknowledged send, want 0", o.Len())
+	}
+
+	srv.reqMx.Lock()
+	srv.ackStatus = &spb.Status{Code: int32(codes.ResourceExhausted)}
+	srv.reqMx.Unlock()
+	err = conn.SendMessageContext(ctx, testMessage("kept"), WithSendRetries(0))
+	if !errors.Is(err, ErrOutboxed) || !errors.Is(err, ErrResourceExhausted) {
+		t.Fatalf("SendMessageContext() = %v, want an error wrapping %v and %v", err, ErrOutboxed, ErrResourceExhausted)
+	}
+	if o.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", o.Len())
+	}
+
+	// The message is resent once the stream is reestablished.
+	srv.reqMx.Lock()
+	srv.ackStatus = nil
+	srv.reqMx.Unlock()
+	srv.recvErr <- nil
+	waitForOutbox(t, o)
+	if diff := cmp.Diff([]string{"acked", "kept", "kept"}, sentValues(srv)); diff != "" {
+		t.Errorf("sent messages diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSendMessage_OutboxRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// Left by a previous process.
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	for _, v := range []string{"a", "b"} {
+		if _, err := o.add(testMessage(v)); err != nil {
+			t.Fatalf("add() failed: %v", err)
+		}
+	}
+	o.Close()
+
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	defer o.Close()
+	srv, _, err := newTestConnection(ctx, t, WithOutbox(o))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	waitForOutbox(t, o)
+	if diff := cmp.Diff([]string{"a", "b"}, sentValues(srv)); diff != "" {
+		t.Errorf("sent messages diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSendMessage_OutboxReplayDuringReplay(t *testing.T) {
+	ctx := context.Background()
+	o, err := OpenOutbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenOutbox() failed: %v", err)
+	}
+	defer o.Close()
+	// An ack received before its send waits for it is missed, do not wait long for it.
+	srv, conn, err := newTestConnection(ctx, t, WithOutbox(o), WithSendBackoff(ConstantBackoff{Delay: time.Millisecond}), WithAckTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	// The first replay blocks on its first send, then fails all its attempts.
+	started := make(chan struct{})
+	hold := make(chan struct{})
+	attempts := 0
+	srv.reqMx.Lock()
+	srv.ackFunc = func(*acpb.MessageBody) *spb.Status {
+		attempts++
+		if attempts == 1 {
+			close(started)
+			<-hold
+		}
+		if attempts <= defaultSendRetries+1 {
+			return &spb.Status{Code: int32(codes.ResourceExhausted)}
+		}
+		return nil
+	}
+	srv.reqMx.Unlock()
+	seq, err := o.add(testMessage("a"))
+	if err != nil {
+		t.Fatalf("add() failed: %v", err)
+	}
+	o.release(seq)
+	go conn.replayOutbox()
+	<-started
+
+	// A replay requested by a reconnect while the first one runs is not dropped.
+	conn.replayOutbox()
+	close(hold)
+	waitForOutbox(t, o)
+}
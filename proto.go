@@ -0,0 +1,136 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+var (
+	// ErrUnknownType is returned when the type URL of a received message is empty or cannot be
+	// resolved to a message type.
+	ErrUnknownType = errors.New("unknown message type")
+	// ErrMalformedPayload is returned when the payload of a received message cannot be unmarshaled
+	// as its type.
+	ErrMalformedPayload = errors.New("malformed message payload")
+	// ErrUnexpectedType is returned by TypedConnection when a received message has another type
+	// than the one the channel carries.
+	ErrUnexpectedType = errors.New("unexpected message type")
+)
+
+// NewProtoMessage returns a MessageBody with m packed in its body with anypb.New, and the given
+// labels.
+func NewProtoMessage(m proto.Message, labels map[string]string) (*acpb.MessageBody, error) {
+	body, err := apb.New(m)
+	if err != nil {
+		return nil, fmt.Errorf("packing %s: %w", m.ProtoReflect().Descriptor().FullName(), err)
+	}
+	return &acpb.MessageBody{Labels: labels, Body: body}, nil
+}
+
+// UnpackMessage unmarshals the body of msg as the message type its type URL resolves to with r,
+// protoregistry.GlobalTypes if r is nil. Errors wrap ErrUnknownType or ErrMalformedPayload.
+func UnpackMessage(msg *acpb.MessageBody, r protoregistry.MessageTypeResolver) (proto.Message, error) {
+	if r == nil {
+		r = protoregistry.GlobalTypes
+	}
+	typeURL := msg.GetBody().GetTypeUrl()
+	if typeURL == "" {
+		return nil, fmt.Errorf("%w: empty type URL", ErrUnknownType)
+	}
+	mt, err := r.FindMessageByURL(typeURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrUnknownType, typeURL, err)
+	}
+	m := mt.New().Interface()
+	if err := proto.Unmarshal(msg.GetBody().GetValue(), m); err != nil {
+		return nil, fmt.Errorf("%w as %s: %v", ErrMalformedPayload, mt.Descriptor().FullName(), err)
+	}
+	return m, nil
+}
+
+// SendProto packs m with anypb.New and sends it with the given labels, see SendMessageContext.
+func (c *Connection) SendProto(ctx context.Context, m proto.Message, labels map[string]string, opts ...SendOption) error {
+	msg, err := NewProtoMessage(m, labels)
+	if err != nil {
+		return err
+	}
+	return c.SendMessageContext(ctx, msg, opts...)
+}
+
+// ReceiveProto receives the next message and unpacks its body with the connection's type
+// resolver, see WithTypeResolver. The received MessageBody is returned with the unpacked message
+// and with errors wrapping ErrUnknownType or ErrMalformedPayload, so such messages can still be
+// handled.
+func (c *Connection) ReceiveProto(ctx context.Context) (proto.Message, *acpb.MessageBody, error) {
+	msg, err := c.ReceiveContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := UnpackMessage(msg, c.typeResolver)
+	if err != nil {
+		return nil, msg, err
+	}
+	return m, msg, nil
+}
+
+// TypedConnection wraps a Connection whose channel carries a single message type T.
+type TypedConnection[T proto.Message] struct {
+	conn *Connection
+	name protoreflect.FullName
+}
+
+// NewTypedConnection returns a TypedConnection sending and receiving T on conn.
+func NewTypedConnection[T proto.Message](conn *Connection) *TypedConnection[T] {
+	var zero T
+	return &TypedConnection[T]{conn: conn, name: zero.ProtoReflect().Descriptor().FullName()}
+}
+
+// Connection returns the underlying Connection.
+func (t *TypedConnection[T]) Connection() *Connection {
+	return t.conn
+}
+
+// Send sends m with the given labels, see SendProto.
+func (t *TypedConnection[T]) Send(ctx context.Context, m T, labels map[string]string, opts ...SendOption) error {
+	return t.conn.SendProto(ctx, m, labels, opts...)
+}
+
+// Receive receives the next message and unmarshals it as T. The received MessageBody is returned
+// with the message and with errors wrapping ErrUnexpectedType or ErrMalformedPayload.
+func (t *TypedConnection[T]) Receive(ctx context.Context) (T, *acpb.MessageBody, error) {
+	var zero T
+	msg, err := t.conn.ReceiveContext(ctx)
+	if err != nil {
+		return zero, nil, err
+	}
+	if got := msg.GetBody().MessageName(); got != t.name {
+		return zero, msg, fmt.Errorf("%w: got %q, want %s", ErrUnexpectedType, msg.GetBody().GetTypeUrl(), t.name)
+	}
+	m := zero.ProtoReflect().Type().New().Interface().(T)
+	if err := proto.Unmarshal(msg.GetBody().GetValue(), m); err != nil {
+		return zero, msg, fmt.Errorf("%w as %s: %v", ErrMalformedPayload, t.name, err)
+	}
+	return m, msg, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	wpb "google.golang.org/protobuf/types/known/wrapperspb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const stringValueURL = "type.googleapis.com/google.protobuf.StringValue"
+
+func TestUnpackMessage(t *testing.T) {
+	msg, err := NewProtoMessage(wpb.String("hello"), map[string]string{"key": "value"})
+	if err != nil {
+		t.Fatalf("NewProtoMessage() failed: %v", err)
+	}
+	if msg.GetBody().GetTypeUrl() != stringValueURL || msg.GetLabels()["key"] != "value" {
+		t.Errorf("NewProtoMessage() = %v, want a packed StringValue with the labels", msg)
+	}
+	got, err := UnpackMessage(msg, nil)
+	if err != nil {
+		t.Fatalf("UnpackMessage() failed: %v", err)
+	}
+	if diff := cmp.Diff(wpb.String("hello"), got, protocmp.Transform()); diff != "" {
+		t.Errorf("UnpackMessage() diff (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name     string
+		msg      *acpb.MessageBody
+		resolver protoregistry.MessageTypeResolver
+		want     error
+	}{
+		{"no type URL", &acpb.MessageBody{Body: &apb.Any{Value: []byte("raw")}}, nil, ErrUnknownType},
+		{"unregistered type", &acpb.MessageBody{Body: &apb.Any{TypeUrl: "example.com/Unknown"}}, nil, ErrUnknownType},
+		{"empty resolver", msg, new(protoregistry.Types), ErrUnknownType},
+		{"malformed", &acpb.MessageBody{Body: &apb.Any{TypeUrl: stringValueURL, Value: []byte{0xff}}}, nil, ErrMalformedPayload},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := UnpackMessage(tc.msg, tc.resolver); !errors.Is(err, tc.want) {
+				t.Errorf("UnpackMessage() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSendAndReceiveProto(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	if err := conn.SendProto(ctx, wpb.String("hello"), map[string]string{"key": "value"}); err != nil {
+		t.Fatalf("SendProto() failed: %v", err)
+	}
+	waitForRequests(t, srv, 2)
+	srv.reqMx.Lock()
+	sent := srv.req[1].GetMessageBody()
+	srv.reqMx.Unlock()
+	if sent.GetBody().GetTypeUrl() != stringValueURL || sent.GetLabels()["key"] != "value" {
+		t.Errorf("sent message = %v, want a packed StringValue with the labels", sent)
+	}
+
+	pushMessage(srv, "1", sent)
+	m, msg, err := conn.ReceiveProto(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveProto() failed: %v", err)
+	}
+	if diff := cmp.Diff(wpb.String("hello"), m, protocmp.Transform()); diff != "" {
+		t.Errorf("ReceiveProto() diff (-want +got):\n%s", diff)
+	}
+	if msg.GetLabels()["key"] != "value" {
+		t.Errorf("ReceiveProto() labels = %v, want key=value", msg.GetLabels())
+	}
+
+	raw := &acpb.MessageBody{Body: &apb.Any{Value: []byte("hello world")}}
+	pushMessage(srv, "2", raw)
+	if _, msg, err := conn.ReceiveProto(ctx); !errors.Is(err, ErrUnknownType) || string(msg.GetBody().GetValue()) != "hello world" {
+		t.Errorf("ReceiveProto() = %v, %v, want the raw message and %v", msg, err, ErrUnknownType)
+	}
+}
+
+func TestTypedConnection(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	typed := NewTypedConnection[*wpb.StringValue](conn)
+
+	if err := typed.Send(ctx, wpb.String("hello"), nil); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	waitForRequests(t, srv, 2)
+	srv.reqMx.Lock()
+	sent := srv.req[1].GetMessageBody()
+	srv.reqMx.Unlock()
+
+	pushMessage(srv, "1", sent)
+	got, _, err := typed.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+	if got.GetValue() != "hello" {
+		t.Errorf("Receive() = %v, want hello", got)
+	}
+
+	other, err := NewProtoMessage(wpb.Int64(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushMessage(srv, "2", other)
+	if _, _, err := typed.Receive(ctx); !errors.Is(err, ErrUnexpectedType) {
+		t.Errorf("Receive() of an Int64Value = %v, want %v", err, ErrUnexpectedType)
+	}
+	pushMessage(srv, "3", &acpb.MessageBody{Body: &apb.Any{TypeUrl: stringValueURL, Value: []byte{0xff}}})
+	if _, _, err := typed.Receive(ctx); !errors.Is(err, ErrMalformedPayload) {
+		t.Errorf("Receive() of a malformed payload = %v, want %v", err, ErrMalformedPayload)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// quotaLimiter is a pair of token buckets enforcing the message rate (messages/minute) and
+// bandwidth (bytes/minute) limits advertised by the service in the stream headers. Until limits
+// are known it allows everything.
+type quotaLimiter struct {
+	mu       sync.Mutex
+	messages *rate.Limiter
+	bytes    *rate.Limiter
+}
+
+func newQuotaLimiter() *quotaLimiter {
+	return &quotaLimiter{
+		messages: rate.NewLimiter(rate.Inf, 0),
+		bytes:    rate.NewLimiter(rate.Inf, 0),
+	}
+}
+
+// setLimits updates the limits, a limit <= 0 disables that limit.
+func (q *quotaLimiter) setLimits(messagesPerMinute, bytesPerMinute int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = updateLimiter(q.messages, messagesPerMinute)
+	q.bytes = updateLimiter(q.bytes, bytesPerMinute)
+}
+
+func updateLimiter(lim *rate.Limiter, perMinute int) *rate.Limiter {
+	if perMinute <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	limit := rate.Limit(float64(perMinute) / 60)
+	if lim.Limit() == rate.Inf {
+		// Quota is measured per minute, so start with a full minute worth of tokens.
+		return rate.NewLimiter(limit, perMinute)
+	}
+	// Keep already consumed tokens when a new stream delivers (possibly the same) limits.
+	lim.SetLimit(limit)
+	lim.SetBurst(perMinute)
+	return lim
+}
+
+// QuotaReservation holds quota reserved for a single message, see Connection.ReserveQuota.
+type QuotaReservation struct {
+	messages *rate.Reservation
+	bytes    *rate.Reservation
+}
+
+// Delay returns how long the caller must wait before sending the message the quota was reserved
+// for.
+func (r *QuotaReservation) Delay() time.Duration {
+	return max(r.messages.Delay(), r.bytes.Delay())
+}
+
+// Cancel returns the reserved quota, use this if the message will not be sent.
+func (r *QuotaReservation) Cancel() {
+	r.messages.Cancel()
+	r.bytes.Cancel()
+}
+
+func (q *quotaLimiter) reserve(size int) *QuotaReservation {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// A message larger than the bandwidth burst could never be reserved, instead let it through
+	// once the bucket is full.
+	if burst := q.bytes.Burst(); q.bytes.Limit() != rate.Inf && size > burst {
+		size = burst
+	}
+	return &QuotaReservation{
+		messages: q.messages.Reserve(),
+		bytes:    q.bytes.ReserveN(time.Now(), size),
+	}
+}
+
+func (q *quotaLimiter) wait(ctx context.Context, size int, logger *slog.Logger) error {
+	r := q.reserve(size)
+	d := r.Delay()
+	if d == 0 {
+		return nil
+	}
+	logger.Debug("Client side quota exceeded, waiting before sending", "delay", d, "size", size)
+	if err := sleepContext(ctx, d); err != nil {
+		r.Cancel()
+		return err
+	}
+	return nil
+}
+
+// available returns a channel that is closed once a message can be sent without waiting.
+func (q *quotaLimiter) available() <-chan struct{} {
+	ch := make(chan struct{})
+	r := q.reserve(1)
+	d := r.Delay()
+	r.Cancel()
+	if d == 0 {
+		close(ch)
+		return ch
+	}
+	time.AfterFunc(d, func() { close(ch) })
+	return ch
+}
+
+// WaitForQuota blocks until a message of size bytes (the serialized size of the MessageBody) can
+// be sent within the rate and bandwidth limits advertised by the service, and consumes the quota
+// for it. Sends on this connection already do this unless WithClientRateLimiting(false) is set.
+func (c *Connection) WaitForQuota(ctx context.Context, size int) error {
+	return c.quota.wait(ctx, size, c.logger)
+}
+
+// ReserveQuota reserves quota for a message of size bytes without blocking. The caller should
+// wait for the returned reservation's Delay before sending, or Cancel it.
+func (c *Connection) ReserveQuota(size int) *QuotaReservation {
+	return c.quota.reserve(size)
+}
+
+// QuotaAvailable returns a channel that is closed once quota is available to send a message
+// without waiting. Each call returns a new channel.
+func (c *Connection) QuotaAvailable() <-chan struct{} {
+	return c.quota.available()
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestQuotaLimiter(t *testing.T) {
+	q := newQuotaLimiter()
+
+	// No limits known yet, nothing should wait.
+	if d := q.reserve(1 << 20).Delay(); d != 0 {
+		t.Errorf("reserve() without limits Delay() = %v, want 0", d)
+	}
+
+	q.setLimits(60, 1000)
+	// A full minute of quota is available up front.
+	for i := 0; i < 60; i++ {
+		if d := q.reserve(10).Delay(); d != 0 {
+			t.Fatalf("reserve() #%d Delay() = %v, want 0", i, d)
+		}
+	}
+	// Message rate is exhausted, the next message has to wait ~1s (60 messages/minute).
+	r := q.reserve(10)
+	if d := r.Delay(); d <= 0 || d > time.Second {
+		t.Errorf("reserve() over message rate Delay() = %v, want (0, 1s]", d)
+	}
+	r.Cancel()
+
+	select {
+	case <-q.available():
+		t.Error("available() closed while quota is exhausted")
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.wait(ctx, 10, discardLogger); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Updating limits keeps consumed quota.
+	q.setLimits(120, 1000)
+	if got := q.messages.Limit(); got != rate.Limit(2) {
+		t.Errorf("messages.Limit() = %v, want 2", got)
+	}
+	if d := q.reserve(10).Delay(); d == 0 {
+		t.Error("reserve() after setLimits() Delay() = 0, want > 0")
+	}
+
+	// Disabling limits lets everything through again.
+	q.setLimits(0, 0)
+	select {
+	case <-q.available():
+	case <-time.After(time.Second):
+		t.Error("available() not closed without limits")
+	}
+}
+
+func TestQuotaLimiter_LargeMessage(t *testing.T) {
+	q := newQuotaLimiter()
+	q.setLimits(60, 100)
+
+	// Larger than the bandwidth burst, allowed once the bucket is full.
+	if d := q.reserve(1000).Delay(); d != 0 {
+		t.Errorf("reserve() Delay() = %v, want 0", d)
+	}
+	if d := q.reserve(1).Delay(); d == 0 {
+		t.Error("reserve() after large message Delay() = 0, want > 0")
+	}
+}
+
+func TestConnectionQuota(t *testing.T) {
+	ctx := context.Background()
+	_, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	conn.quota.mu.Lock()
+	gotMessages, gotBytes := conn.quota.messages.Burst(), conn.quota.bytes.Burst()
+	conn.quota.mu.Unlock()
+	if gotMessages != metadataMessageRateLimitValue || gotBytes != metadataBandwidthLimitValue {
+		t.Errorf("quota bursts = (%d, %d), want (%d, %d)", gotMessages, gotBytes, metadataMessageRateLimitValue, metadataBandwidthLimitValue)
+	}
+
+	select {
+	case <-conn.QuotaAvailable():
+	case <-time.After(time.Second):
+		t.Error("QuotaAvailable() not closed")
+	}
+	if err := conn.WaitForQuota(ctx, 100); err != nil {
+		t.Errorf("WaitForQuota() failed: %v", err)
+	}
+	conn.ReserveQuota(100).Cancel()
+}
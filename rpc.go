@@ -0,0 +1,379 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	// RPCIDLabel holds the correlation ID of request, response, error and cancel messages.
+	RPCIDLabel = "acs-rpc-id"
+	// RPCKindLabel marks a message as part of a call, its value is one of the RPCKind constants.
+	RPCKindLabel = "acs-rpc"
+	// RPCDeadlineLabel holds the deadline of a request in milliseconds since the Unix epoch.
+	RPCDeadlineLabel = "acs-rpc-deadline"
+
+	// RPCKindRequest marks a request sent by Caller.Call.
+	RPCKindRequest = "request"
+	// RPCKindResponse marks a successful response sent by a Responder.
+	RPCKindResponse = "response"
+	// RPCKindError marks an error response sent by a Responder, its body is a packed
+	// google.rpc.Status.
+	RPCKindError = "error"
+	// RPCKindCancel notifies the responder that the caller stopped waiting for a request.
+	RPCKindCancel = "cancel"
+
+	defaultCallTimeout = 30 * time.Second
+	// Time allowed to send a cancel notification or a response.
+	rpcNotifyTimeout = 10 * time.Second
+)
+
+// RemoteError is returned by Caller.Call when the responder's handler returned an error. It carries
+// the status the handler returned, see status.Convert, so status.Code works on it.
+type RemoteError struct {
+	Status *status.Status
+}
+
+// Error returns the error message for RemoteError.
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error: code = %s desc = %s", e.Status.Code(), e.Status.Message())
+}
+
+// GRPCStatus returns the status of the remote error.
+func (e *RemoteError) GRPCStatus() *status.Status {
+	return e.Status
+}
+
+// Dispatcher consumes received messages, see ReceiveLoop.
+type Dispatcher interface {
+	// Deliver offers a received message to the dispatcher, it returns false if the message is not
+	// for it. Deliver must not block.
+	Deliver(msg *acpb.MessageBody) bool
+}
+
+// ReceiveLoop receives messages from conn until ctx is done or the connection is closed, and
+// offers each one to the dispatchers in order. Messages no dispatcher accepts are passed to
+// unmatched, or dropped if it is nil. unmatched is called on the receive loop and must not block.
+// Messages that cannot be decoded (see DecodeError) are skipped. It returns the error that stopped
+// the loop.
+func ReceiveLoop(ctx context.Context, conn *Connection, unmatched func(*acpb.MessageBody), dispatchers ...Dispatcher) error {
+	for {
+		msg, err := conn.ReceiveContext(ctx)
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		delivered := false
+		for _, d := range dispatchers {
+			if delivered = d.Deliver(msg); delivered {
+				break
+			}
+		}
+		if !delivered && unmatched != nil {
+			unmatched(msg)
+		}
+	}
+}
+
+// rpcMessage returns a copy of msg with the call labels set.
+func rpcMessage(msg *acpb.MessageBody, id, kind string) *acpb.MessageBody {
+	msg = proto.Clone(msg).(*acpb.MessageBody)
+	if msg.Labels == nil {
+		msg.Labels = make(map[string]string)
+	}
+	if msg.Body == nil {
+		// Body is required, even for cancel notifications and empty responses.
+		msg.Body = &apb.Any{}
+	}
+	msg.Labels[RPCIDLabel] = id
+	msg.Labels[RPCKindLabel] = kind
+	return msg
+}
+
+// stripRPCLabels returns a copy of msg without the call labels.
+func stripRPCLabels(msg *acpb.MessageBody) *acpb.MessageBody {
+	msg = proto.Clone(msg).(*acpb.MessageBody)
+	delete(msg.Labels, RPCIDLabel)
+	delete(msg.Labels, RPCKindLabel)
+	delete(msg.Labels, RPCDeadlineLabel)
+	return msg
+}
+
+// CallerOption configures a Caller.
+type CallerOption func(*Caller)
+
+// WithCallTimeout sets the timeout of calls whose context has no deadline, 30 seconds by default.
+func WithCallTimeout(d time.Duration) CallerOption {
+	return func(c *Caller) {
+		c.timeout = d
+	}
+}
+
+// Caller sends requests on a Connection and matches them with the responses of a Responder on
+// the other side, using the RPCIDLabel and RPCKindLabel labels. Responses are only matched once
+// they are passed to Deliver, usually by ReceiveLoop or Serve.
+type Caller struct {
+	conn    *Connection
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]chan *acpb.MessageBody
+}
+
+// NewCaller returns a Caller sending requests on conn.
+func NewCaller(conn *Connection, opts ...CallerOption) *Caller {
+	c := &Caller{
+		conn:    conn,
+		timeout: defaultCallTimeout,
+		pending: make(map[string]chan *acpb.MessageBody),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Call sends msg as a request and waits for the matching response. It returns the response
+// without the call labels, a *RemoteError if the responder's handler failed, or the context's
+// error once ctx is done, in which case the responder is notified that the call was canceled.
+// The deadline of ctx (or the Caller's timeout) is sent along with the request.
+func (c *Caller) Call(ctx context.Context, msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	id := uuid.New().String()
+	req := rpcMessage(msg, id, RPCKindRequest)
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Labels[RPCDeadlineLabel] = strconv.FormatInt(deadline.UnixMilli(), 10)
+	}
+
+	ch := make(chan *acpb.MessageBody, 1)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.conn.SendMessageContext(ctx, req); err != nil {
+		if ctx.Err() != nil {
+			go c.notifyCancel(id)
+		}
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return decodeResponse(resp)
+	case <-ctx.Done():
+		go c.notifyCancel(id)
+		return nil, ctx.Err()
+	}
+}
+
+// notifyCancel tells the responder to stop handling the request with the given ID, best effort.
+func (c *Caller) notifyCancel(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcNotifyTimeout)
+	defer cancel()
+	if err := c.conn.SendMessageContext(ctx, rpcMessage(&acpb.MessageBody{}, id, RPCKindCancel), WithSendRetries(0)); err != nil {
+		c.conn.logger.Debug("Error sending cancel notification", "rpc_id", id, "error", err)
+	}
+}
+
+// Deliver implements Dispatcher, it accepts response and error messages. Responses for calls that
+// already returned are dropped.
+func (c *Caller) Deliver(msg *acpb.MessageBody) bool {
+	kind := msg.GetLabels()[RPCKindLabel]
+	if kind != RPCKindResponse && kind != RPCKindError {
+		return false
+	}
+	id := msg.GetLabels()[RPCIDLabel]
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	c.mu.Unlock()
+	if !ok {
+		c.conn.logger.Debug("Dropping response for unknown call", "rpc_id", id)
+		return true
+	}
+	select {
+	case ch <- msg:
+	default:
+		// Duplicate response.
+	}
+	return true
+}
+
+// HandleMessage implements Handler, so responses can be routed to the Caller by Serve.
+func (c *Caller) HandleMessage(_ context.Context, msg *acpb.MessageBody) error {
+	if !c.Deliver(msg) {
+		return fmt.Errorf("%w: not a response", ErrNoHandler)
+	}
+	return nil
+}
+
+func decodeResponse(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	if msg.GetLabels()[RPCKindLabel] != RPCKindError {
+		return stripRPCLabels(msg), nil
+	}
+	st := &spb.Status{}
+	if err := msg.GetBody().UnmarshalTo(st); err != nil {
+		return nil, &RemoteError{Status: status.Newf(codes.Unknown, "malformed error response: %v", err)}
+	}
+	return nil, &RemoteError{Status: status.FromProto(st)}
+}
+
+// ResponderFunc handles a request received by a Responder. The context is canceled when the caller
+// cancels the call and carries the caller's deadline. A non nil error is sent back to the caller
+// as a RemoteError, converted with status.Convert.
+type ResponderFunc func(ctx context.Context, req *acpb.MessageBody) (*acpb.MessageBody, error)
+
+// Responder handles the requests sent by a Caller on the other side and sends back the responses
+// on a Connection. Each request is handled on its own goroutine so the receive loop is never
+// blocked.
+type Responder struct {
+	conn    *Connection
+	handler ResponderFunc
+
+	mu sync.Mutex
+	// Cancel functions of the requests being handled, by correlation ID.
+	inflight map[string]context.CancelFunc
+}
+
+// NewResponder returns a Responder handling requests with handler and responding on conn.
+func NewResponder(conn *Connection, handler ResponderFunc) *Responder {
+	return &Responder{
+		conn:     conn,
+		handler:  handler,
+		inflight: make(map[string]context.CancelFunc),
+	}
+}
+
+// Deliver implements Dispatcher, it accepts request and cancel messages. Requests are handled
+// asynchronously.
+func (r *Responder) Deliver(msg *acpb.MessageBody) bool {
+	switch msg.GetLabels()[RPCKindLabel] {
+	case RPCKindRequest:
+		// Registered before returning, so a cancel message delivered next finds the call.
+		ctx, done := r.track(context.Background(), msg)
+		go func() {
+			defer done()
+			r.handle(ctx, msg)
+		}()
+		return true
+	case RPCKindCancel:
+		r.mu.Lock()
+		cancel, ok := r.inflight[msg.GetLabels()[RPCIDLabel]]
+		r.mu.Unlock()
+		if ok {
+			cancel()
+		}
+		return true
+	}
+	return false
+}
+
+// HandleMessage implements Handler, so requests can be routed to the Responder by Serve. Unlike
+// Deliver, requests are handled before HandleMessage returns, within the limits of Serve's
+// worker pool.
+func (r *Responder) HandleMessage(ctx context.Context, msg *acpb.MessageBody) error {
+	switch msg.GetLabels()[RPCKindLabel] {
+	case RPCKindRequest:
+		ctx, done := r.track(ctx, msg)
+		defer done()
+		r.handle(ctx, msg)
+		return nil
+	case RPCKindCancel:
+		r.Deliver(msg)
+		return nil
+	}
+	return fmt.Errorf("%w: not a request", ErrNoHandler)
+}
+
+// track returns the context to handle req with, canceled by a cancel message for req or once the
+// caller's deadline passes. done must be called once req is handled.
+func (r *Responder) track(ctx context.Context, req *acpb.MessageBody) (_ context.Context, done func()) {
+	id := req.GetLabels()[RPCIDLabel]
+	ctx, cancel := context.WithCancel(ctx)
+	cancelDeadline := func() {}
+	if ms, err := strconv.ParseInt(req.GetLabels()[RPCDeadlineLabel], 10, 64); err == nil {
+		ctx, cancelDeadline = context.WithDeadline(ctx, time.UnixMilli(ms))
+	}
+	r.mu.Lock()
+	r.inflight[id] = cancel
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.inflight, id)
+		r.mu.Unlock()
+		cancelDeadline()
+		cancel()
+	}
+}
+
+// handle runs the handler for req and sends the response, unless the call was canceled. ctx is
+// the context returned by track.
+func (r *Responder) handle(ctx context.Context, req *acpb.MessageBody) {
+	id := req.GetLabels()[RPCIDLabel]
+	resp, err := r.handler(ctx, stripRPCLabels(req))
+	if ctx.Err() != nil {
+		// The caller is no longer waiting.
+		r.conn.logger.Debug("Not responding to canceled call", "rpc_id", id, "error", ctx.Err())
+		return
+	}
+	r.respond(id, resp, err)
+}
+
+// respond sends the response or error for the call with the given ID.
+func (r *Responder) respond(id string, resp *acpb.MessageBody, err error) {
+	var msg *acpb.MessageBody
+	if err != nil {
+		body, perr := apb.New(status.Convert(err).Proto())
+		if perr != nil {
+			body, _ = apb.New(status.New(codes.Internal, perr.Error()).Proto())
+		}
+		msg = rpcMessage(&acpb.MessageBody{Body: body}, id, RPCKindError)
+	} else {
+		if resp == nil {
+			resp = &acpb.MessageBody{}
+		}
+		msg = rpcMessage(resp, id, RPCKindResponse)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rpcNotifyTimeout)
+	defer cancel()
+	if err := r.conn.SendMessageContext(ctx, msg); err != nil && !errors.Is(err, ErrConnectionClosed) {
+		r.conn.logger.Warn("Error sending response", "rpc_id", id, "error", err)
+	}
+}
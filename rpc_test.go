@@ -0,0 +1,210 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// waitForRPCMessage waits for the client to send a message with the given RPCKindLabel.
+func waitForRPCMessage(t *testing.T, srv *testSrv, kind string) *acpb.MessageBody {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	for {
+		srv.reqMx.Lock()
+		for _, req := range srv.req {
+			if msg := req.GetMessageBody(); msg.GetLabels()[RPCKindLabel] == kind {
+				srv.reqMx.Unlock()
+				return msg
+			}
+		}
+		srv.reqMx.Unlock()
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for a %q message", kind)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func pushMessage(srv *testSrv, id string, msg *acpb.MessageBody) {
+	srv.send <- &acpb.StreamAgentMessagesResponse{MessageId: id, Type: &acpb.StreamAgentMessagesResponse_MessageBody{MessageBody: msg}}
+}
+
+func TestCall(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	caller := NewCaller(conn)
+	unmatched := make(chan *acpb.MessageBody, 1)
+	go ReceiveLoop(ctx, conn, func(msg *acpb.MessageBody) { unmatched <- msg }, caller)
+
+	type result struct {
+		resp *acpb.MessageBody
+		err  error
+	}
+	call := func(ctx context.Context) <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			resp, err := caller.Call(ctx, &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: []byte("ping")}})
+			ch <- result{resp, err}
+		}()
+		return ch
+	}
+
+	done := call(ctx)
+	req := waitForRPCMessage(t, srv, RPCKindRequest)
+	id := req.GetLabels()[RPCIDLabel]
+	if id == "" || req.GetLabels()["key"] != "value" {
+		t.Errorf("request labels = %v, want a correlation ID and the caller's labels", req.GetLabels())
+	}
+	if ms, err := strconv.ParseInt(req.GetLabels()[RPCDeadlineLabel], 10, 64); err != nil || time.Until(time.UnixMilli(ms)) > defaultCallTimeout {
+		t.Errorf("request deadline label = %q, want the default call timeout", req.GetLabels()[RPCDeadlineLabel])
+	}
+
+	// A response to an unknown call is consumed, other messages are not.
+	pushMessage(srv, "1", &acpb.MessageBody{Labels: map[string]string{RPCIDLabel: "unknown", RPCKindLabel: RPCKindResponse}})
+	pushMessage(srv, "2", &acpb.MessageBody{Body: &apb.Any{Value: []byte("unrelated")}})
+	if msg := <-unmatched; string(msg.GetBody().GetValue()) != "unrelated" {
+		t.Errorf("unmatched message = %v, want the unrelated message", msg)
+	}
+
+	pushMessage(srv, "3", &acpb.MessageBody{Labels: map[string]string{RPCIDLabel: id, RPCKindLabel: RPCKindResponse, "key": "value"}, Body: &apb.Any{Value: []byte("pong")}})
+	got := <-done
+	if got.err != nil {
+		t.Fatalf("Call() failed: %v", got.err)
+	}
+	want := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: []byte("pong")}}
+	if diff := cmp.Diff(want, got.resp, protocmp.Transform()); diff != "" {
+		t.Errorf("Call() diff (-want +got):\n%s", diff)
+	}
+
+	// Remote error.
+	srv.reqMx.Lock()
+	srv.req = nil
+	srv.reqMx.Unlock()
+	done = call(ctx)
+	id = waitForRPCMessage(t, srv, RPCKindRequest).GetLabels()[RPCIDLabel]
+	body, err := apb.New(status.New(codes.NotFound, "no such thing").Proto())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushMessage(srv, "4", &acpb.MessageBody{Labels: map[string]string{RPCIDLabel: id, RPCKindLabel: RPCKindError}, Body: body})
+	got = <-done
+	var remoteErr *RemoteError
+	if !errors.As(got.err, &remoteErr) || status.Code(got.err) != codes.NotFound {
+		t.Errorf("Call() = %v, want a NotFound RemoteError", got.err)
+	}
+
+	// Timeout, the responder is notified.
+	srv.reqMx.Lock()
+	srv.req = nil
+	srv.reqMx.Unlock()
+	tCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	done = call(tCtx)
+	id = waitForRPCMessage(t, srv, RPCKindRequest).GetLabels()[RPCIDLabel]
+	if got := <-done; !errors.Is(got.err, context.DeadlineExceeded) {
+		t.Errorf("Call() = %v, want %v", got.err, context.DeadlineExceeded)
+	}
+	if got := waitForRPCMessage(t, srv, RPCKindCancel); got.GetLabels()[RPCIDLabel] != id || got.GetBody() == nil {
+		t.Errorf("cancel notification = %v, want a message with a body for call %q", got, id)
+	}
+}
+
+func TestResponder(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	canceled := make(chan error, 1)
+	responder := NewResponder(conn, func(ctx context.Context, req *acpb.MessageBody) (*acpb.MessageBody, error) {
+		switch string(req.GetBody().GetValue()) {
+		case "ping":
+			if _, ok := req.GetLabels()[RPCIDLabel]; ok {
+				t.Errorf("handler request labels = %v, want no call labels", req.GetLabels())
+			}
+			return &acpb.MessageBody{Body: &apb.Any{Value: []byte("pong")}}, nil
+		case "fail":
+			return nil, status.Error(codes.PermissionDenied, "denied")
+		default:
+			<-ctx.Done()
+			canceled <- ctx.Err()
+			return nil, ctx.Err()
+		}
+	})
+	go ReceiveLoop(ctx, conn, nil, responder)
+
+	request := func(id, value string) {
+		pushMessage(srv, id, &acpb.MessageBody{Labels: map[string]string{RPCIDLabel: id, RPCKindLabel: RPCKindRequest}, Body: &apb.Any{Value: []byte(value)}})
+	}
+
+	request("call-1", "ping")
+	resp := waitForRPCMessage(t, srv, RPCKindResponse)
+	want := &acpb.MessageBody{Labels: map[string]string{RPCIDLabel: "call-1", RPCKindLabel: RPCKindResponse}, Body: &apb.Any{Value: []byte("pong")}}
+	if diff := cmp.Diff(want, resp, protocmp.Transform()); diff != "" {
+		t.Errorf("response diff (-want +got):\n%s", diff)
+	}
+
+	request("call-2", "fail")
+	resp = waitForRPCMessage(t, srv, RPCKindError)
+	if _, err := decodeResponse(resp); status.Code(err) != codes.PermissionDenied || resp.GetLabels()[RPCIDLabel] != "call-2" {
+		t.Errorf("error response = %v (%v), want PermissionDenied for call-2", resp, err)
+	}
+
+	request("call-3", "block")
+	// Wait for the handler to start before canceling it.
+	for {
+		responder.mu.Lock()
+		_, ok := responder.inflight["call-3"]
+		responder.mu.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	pushMessage(srv, "cancel-3", &acpb.MessageBody{Labels: map[string]string{RPCIDLabel: "call-3", RPCKindLabel: RPCKindCancel}})
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("handler context error = %v, want %v", err, context.Canceled)
+	}
+
+	// A cancel delivered right after its request is not lost.
+	responder.Deliver(&acpb.MessageBody{Labels: map[string]string{RPCIDLabel: "call-4", RPCKindLabel: RPCKindRequest}, Body: &apb.Any{Value: []byte("block")}})
+	responder.Deliver(&acpb.MessageBody{Labels: map[string]string{RPCIDLabel: "call-4", RPCKindLabel: RPCKindCancel}})
+	select {
+	case err := <-canceled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("handler context error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Error("handler not canceled by a cancel delivered right after its request")
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const defaultServeWorkers = 16
+
+// ErrNoHandler is returned by ServeMux when no route matches a message.
+var ErrNoHandler = errors.New("no handler for message")
+
+// Handler handles a message received by Serve. Handlers run on the worker pool of Serve, never on
+// the receive loop, and should return once ctx is done. A returned error is logged.
+type Handler interface {
+	HandleMessage(ctx context.Context, msg *acpb.MessageBody) error
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(ctx context.Context, msg *acpb.MessageBody) error
+
+// HandleMessage calls f(ctx, msg).
+func (f HandlerFunc) HandleMessage(ctx context.Context, msg *acpb.MessageBody) error {
+	return f(ctx, msg)
+}
+
+// TimeoutHandler returns a Handler that runs h with a context that times out after d, to set a
+// timeout for a single ServeMux route.
+func TimeoutHandler(h Handler, d time.Duration) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *acpb.MessageBody) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return h.HandleMessage(ctx, msg)
+	})
+}
+
+type serveOptions struct {
+	workers   int
+	queueSize int
+	timeout   time.Duration
+}
+
+// ServeOption configures Serve.
+type ServeOption func(*serveOptions)
+
+// WithServeWorkers sets the number of messages handled concurrently. Defaults to 16.
+func WithServeWorkers(n int) ServeOption {
+	return func(o *serveOptions) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+// WithServeQueueSize sets how many received messages wait for a free worker before Serve stops
+// receiving. Defaults to the number of workers.
+func WithServeQueueSize(n int) ServeOption {
+	return func(o *serveOptions) {
+		if n >= 0 {
+			o.queueSize = n
+		}
+	}
+}
+
+// WithHandlerTimeout sets the timeout of every handler call, handlers are not timed out by
+// default.
+func WithHandlerTimeout(d time.Duration) ServeOption {
+	return func(o *serveOptions) {
+		if d > 0 {
+			o.timeout = d
+		}
+	}
+}
+
+// Serve receives messages from conn and passes them to h on a bounded pool of workers, so the
+// receive loop is never stalled by a handler. If all workers are busy and the queue is full, Serve
+// stops receiving until a worker is free, the connection's receive buffer absorbs bursts in the
+// meantime. Panics in h are recovered and logged, messages that cannot be decoded (see
+// DecodeError) are skipped.
+//
+// Serve returns once ctx is done or the connection is closed, after the queued messages were
+// handled and the running handlers returned. Handlers are called with a context that is canceled
+// when ctx is done. In manual acknowledgement mode (see WithManualAck) messages are acknowledged
+// once their handler returned, even if it failed or panicked.
+func Serve(ctx context.Context, conn *Connection, h Handler, opts ...ServeOption) error {
+	o := &serveOptions{workers: defaultServeWorkers, queueSize: -1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.queueSize < 0 {
+		o.queueSize = o.workers
+	}
+
+	queue := make(chan *Envelope, o.queueSize)
+	var wg sync.WaitGroup
+	for range o.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for env := range queue {
+				handle(ctx, conn, h, env.Body, o.timeout)
+				if err := env.Ack(); err != nil && !errors.Is(err, ErrAutoAcked) {
+					conn.logger.Warn("Error acknowledging message", logKeyMessageID, env.MessageID, "error", err)
+				}
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(queue)
+
+	for {
+		env, err := conn.ReceiveEnvelope(ctx)
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) {
+			// Already logged, skip the message.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case queue <- env:
+		case <-ctx.Done():
+			// Acknowledged messages are not dropped, handlers see the canceled context.
+			queue <- env
+			return ctx.Err()
+		}
+	}
+}
+
+// handle runs h for a single message, recovering panics.
+func handle(ctx context.Context, conn *Connection, h Handler, msg *acpb.MessageBody, timeout time.Duration) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			conn.logger.Error("Handler panicked", "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	if err := h.HandleMessage(ctx, msg); err != nil {
+		conn.logger.Warn("Error handling message", "error", err)
+	}
+}
+
+// route is a ServeMux entry, a message matches if it has the label key (with the value, unless
+// anyValue) or the type URL.
+type route struct {
+	key      string
+	value    string
+	anyValue bool
+	typeURL  string
+	h        Handler
+}
+
+func (r *route) match(msg *acpb.MessageBody) bool {
+	if r.typeURL != "" {
+		return msg.GetBody().GetTypeUrl() == r.typeURL
+	}
+	v, ok := msg.GetLabels()[r.key]
+	return ok && (r.anyValue || v == r.value)
+}
+
+// ServeMux is a Handler routing messages by label or by the type URL of their body. Routes are
+// tried in the order they were registered and the first match handles the message, messages
+// matching no route go to the default handler.
+//
+// To serve a Caller and a Responder on the same connection, route RPCKindLabel responses and
+// errors to the Caller and requests and cancellations to the Responder.
+type ServeMux struct {
+	mu     sync.RWMutex
+	routes []*route
+	def    Handler
+}
+
+// NewServeMux returns an empty ServeMux, it returns ErrNoHandler for every message until routes
+// are registered.
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+func (m *ServeMux) add(r *route) {
+	if r.h == nil {
+		panic("client: nil Handler")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routes = append(m.routes, r)
+}
+
+// HandleLabel routes messages with the label key set to value to h.
+func (m *ServeMux) HandleLabel(key, value string, h Handler) {
+	m.add(&route{key: key, value: value, h: h})
+}
+
+// HandleLabelKey routes messages with the label key set, whatever its value, to h.
+func (m *ServeMux) HandleLabelKey(key string, h Handler) {
+	m.add(&route{key: key, anyValue: true, h: h})
+}
+
+// HandleType routes messages whose body has the given type URL, for example
+// "type.googleapis.com/google.protobuf.StringValue", to h.
+func (m *ServeMux) HandleType(typeURL string, h Handler) {
+	if typeURL == "" {
+		panic("client: empty type URL")
+	}
+	m.add(&route{typeURL: typeURL, h: h})
+}
+
+// HandleDefault sets the handler of the messages matching no route.
+func (m *ServeMux) HandleDefault(h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.def = h
+}
+
+// Handler returns the handler for msg, nil if no route matches and there is no default handler.
+func (m *ServeMux) Handler(msg *acpb.MessageBody) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, r := range m.routes {
+		if r.match(msg) {
+			return r.h
+		}
+	}
+	return m.def
+}
+
+// HandleMessage implements Handler, it passes msg to the handler of the first matching route.
+func (m *ServeMux) HandleMessage(ctx context.Context, msg *acpb.MessageBody) error {
+	h := m.Handler(msg)
+	if h == nil {
+		return fmt.Errorf("%w (type %q, labels %v)", ErrNoHandler, msg.GetBody().GetTypeUrl(), msg.GetLabels())
+	}
+	return h.HandleMessage(ctx, msg)
+}
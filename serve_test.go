@@ -0,0 +1,164 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+func TestServeMux(t *testing.T) {
+	var got string
+	named := func(name string) Handler {
+		return HandlerFunc(func(context.Context, *acpb.MessageBody) error {
+			got = name
+			return nil
+		})
+	}
+	mux := NewServeMux()
+	mux.HandleLabel("op", "a", named("op=a"))
+	mux.HandleLabelKey("op", named("op"))
+	mux.HandleType("example.com/T", named("type"))
+
+	tests := []struct {
+		msg  *acpb.MessageBody
+		want string
+	}{
+		{&acpb.MessageBody{Labels: map[string]string{"op": "a"}}, "op=a"},
+		{&acpb.MessageBody{Labels: map[string]string{"op": ""}}, "op"},
+		{&acpb.MessageBody{Labels: map[string]string{"op": "b"}, Body: &apb.Any{TypeUrl: "example.com/T"}}, "op"},
+		{&acpb.MessageBody{Body: &apb.Any{TypeUrl: "example.com/T"}}, "type"},
+	}
+	for _, tc := range tests {
+		got = ""
+		if err := mux.HandleMessage(context.Background(), tc.msg); err != nil {
+			t.Errorf("HandleMessage(%v) failed: %v", tc.msg, err)
+		}
+		if got != tc.want {
+			t.Errorf("HandleMessage(%v) routed to %q, want %q", tc.msg, got, tc.want)
+		}
+	}
+
+	unrouted := &acpb.MessageBody{Body: &apb.Any{TypeUrl: "example.com/Other"}}
+	if err := mux.HandleMessage(context.Background(), unrouted); !errors.Is(err, ErrNoHandler) {
+		t.Errorf("HandleMessage() without route = %v, want %v", err, ErrNoHandler)
+	}
+	mux.HandleDefault(named("default"))
+	if err := mux.HandleMessage(context.Background(), unrouted); err != nil || got != "default" {
+		t.Errorf("HandleMessage() = %v routed to %q, want the default handler", err, got)
+	}
+}
+
+func TestServe(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	handled := make(chan string, 10)
+	release := make(chan struct{})
+	var running, maxRunning atomic.Int32
+	mux := NewServeMux()
+	mux.HandleLabel("op", "panic", HandlerFunc(func(context.Context, *acpb.MessageBody) error {
+		panic("handler panic")
+	}))
+	mux.HandleLabel("op", "timeout", TimeoutHandler(HandlerFunc(func(ctx context.Context, msg *acpb.MessageBody) error {
+		<-ctx.Done()
+		handled <- "timeout: " + ctx.Err().Error()
+		return ctx.Err()
+	}), 10*time.Millisecond))
+	mux.HandleLabel("op", "block", HandlerFunc(func(ctx context.Context, msg *acpb.MessageBody) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+		handled <- "block"
+		return nil
+	}))
+	mux.HandleDefault(HandlerFunc(func(ctx context.Context, msg *acpb.MessageBody) error {
+		handled <- string(msg.GetBody().GetValue())
+		return nil
+	}))
+
+	sCtx, cancel := context.WithCancel(ctx)
+	served := make(chan error, 1)
+	go func() { served <- Serve(sCtx, conn, mux, WithServeWorkers(2), WithServeQueueSize(0)) }()
+
+	push := func(i int, op, value string) {
+		pushMessage(srv, strconv.Itoa(i), &acpb.MessageBody{Labels: map[string]string{"op": op}, Body: &apb.Any{Value: []byte(value)}})
+	}
+	push(1, "panic", "")
+	push(2, "timeout", "")
+	if got := <-handled; got != "timeout: "+context.DeadlineExceeded.Error() {
+		t.Errorf("timeout handler = %q, want %v", got, context.DeadlineExceeded)
+	}
+	push(3, "", "after panic")
+	if got := <-handled; got != "after panic" {
+		t.Errorf("handled %q, want %q", got, "after panic")
+	}
+
+	for i := range 4 {
+		push(10+i, "block", "")
+	}
+	close(release)
+	for range 4 {
+		if got := <-handled; got != "block" {
+			t.Errorf("handled %q, want %q", got, "block")
+		}
+	}
+	if got := maxRunning.Load(); got > 2 {
+		t.Errorf("%d handlers ran concurrently, want at most 2", got)
+	}
+
+	cancel()
+	if err := <-served; !errors.Is(err, context.Canceled) {
+		t.Errorf("Serve() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestServe_RPC(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	responder := NewResponder(conn, func(ctx context.Context, req *acpb.MessageBody) (*acpb.MessageBody, error) {
+		return &acpb.MessageBody{Body: &apb.Any{Value: []byte("pong")}}, nil
+	})
+	mux := NewServeMux()
+	mux.HandleLabel(RPCKindLabel, RPCKindRequest, responder)
+	mux.HandleLabel(RPCKindLabel, RPCKindCancel, responder)
+	sCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go Serve(sCtx, conn, mux)
+
+	pushMessage(srv, "1", &acpb.MessageBody{Labels: map[string]string{RPCIDLabel: "call-1", RPCKindLabel: RPCKindRequest}})
+	if resp := waitForRPCMessage(t, srv, RPCKindResponse); string(resp.GetBody().GetValue()) != "pong" {
+		t.Errorf("response = %v, want pong", resp)
+	}
+}
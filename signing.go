@@ -0,0 +1,297 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	// SignatureLabel is a reserved label holding the signature of a message, base32 encoded
+	// (lowercase, extended hex alphabet, no padding) to satisfy the label character constraints.
+	SignatureLabel = "acs-sig"
+	// SignatureKeyIDLabel is a reserved label holding the ID of the signing key.
+	SignatureKeyIDLabel = "acs-sig-key"
+	// SignatureAlgorithmLabel is a reserved label holding the SignatureAlgorithm.
+	SignatureAlgorithmLabel = "acs-sig-alg"
+	// SignatureTimeLabel is a reserved label holding the signing time in milliseconds since the
+	// Unix epoch.
+	SignatureTimeLabel = "acs-sig-ts"
+	// SignatureNonceLabel is a reserved label holding a random nonce, unique to each message, base32
+	// encoded like SignatureLabel.
+	SignatureNonceLabel = "acs-sig-nonce"
+
+	defaultMaxClockSkew       = 5 * time.Minute
+	defaultMaxReplayCacheSize = 100000
+)
+
+var (
+	// ErrUnsignedMessage is returned when a received message is not signed.
+	ErrUnsignedMessage = errors.New("message is not signed")
+	// ErrInvalidSignature is returned when the signature of a received message does not verify
+	// or its key is not trusted.
+	ErrInvalidSignature = errors.New("invalid message signature")
+	// ErrStaleMessage is returned when a received message was signed outside the clock skew
+	// window.
+	ErrStaleMessage = errors.New("stale message")
+	// ErrReplayedMessage is returned when a received message was already received.
+	ErrReplayedMessage = errors.New("replayed message")
+	// ErrReplayCacheFull is returned when a received message cannot be checked for replays
+	// because the nonces of too many messages received within the clock skew window are recorded.
+	ErrReplayCacheFull = errors.New("replay cache full")
+)
+
+// SignatureAlgorithm is a message signature algorithm.
+type SignatureAlgorithm string
+
+const (
+	// SignatureEd25519 signs messages with an Ed25519 private key.
+	SignatureEd25519 SignatureAlgorithm = "ed25519"
+	// SignatureHMACSHA256 signs messages with HMAC-SHA256 and a shared secret.
+	SignatureHMACSHA256 SignatureAlgorithm = "hmac-sha256"
+)
+
+// Signer signs sent messages, see WithSigning.
+type Signer interface {
+	// Algorithm returns the algorithm of the signatures.
+	Algorithm() SignatureAlgorithm
+	// KeyID returns the ID receivers look the verification key up with.
+	KeyID() string
+	// Sign returns the signature of data.
+	Sign(data []byte) ([]byte, error)
+}
+
+type ed25519Signer struct {
+	id  string
+	key ed25519.PrivateKey
+}
+
+// NewEd25519Signer returns a Signer signing with key, receivers verify the signatures with the
+// public key of key in TrustedKeys.Ed25519[id].
+func NewEd25519Signer(id string, key ed25519.PrivateKey) Signer {
+	return &ed25519Signer{id: id, key: key}
+}
+
+func (s *ed25519Signer) Algorithm() SignatureAlgorithm { return SignatureEd25519 }
+func (s *ed25519Signer) KeyID() string                 { return s.id }
+func (s *ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, data), nil
+}
+
+type hmacSigner struct {
+	id  string
+	key []byte
+}
+
+// NewHMACSigner returns a Signer signing with HMAC-SHA256 and key, receivers verify the signatures
+// with the same key in TrustedKeys.HMAC[id].
+func NewHMACSigner(id string, key []byte) Signer {
+	return &hmacSigner{id: id, key: key}
+}
+
+func (s *hmacSigner) Algorithm() SignatureAlgorithm { return SignatureHMACSHA256 }
+func (s *hmacSigner) KeyID() string                 { return s.id }
+func (s *hmacSigner) Sign(data []byte) ([]byte, error) {
+	return hmacSHA256(s.key, data), nil
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// TrustedKeys are the keys received messages are verified with, by key ID.
+type TrustedKeys struct {
+	Ed25519 map[string]ed25519.PublicKey
+	HMAC    map[string][]byte
+}
+
+func (k *TrustedKeys) verify(alg SignatureAlgorithm, id string, data, sig []byte) error {
+	switch alg {
+	case SignatureEd25519:
+		key, ok := k.Ed25519[id]
+		if !ok {
+			return fmt.Errorf("%w: untrusted %s key %q", ErrInvalidSignature, alg, id)
+		}
+		if !ed25519.Verify(key, data, sig) {
+			return ErrInvalidSignature
+		}
+	case SignatureHMACSHA256:
+		key, ok := k.HMAC[id]
+		if !ok {
+			return fmt.Errorf("%w: untrusted %s key %q", ErrInvalidSignature, alg, id)
+		}
+		if !hmac.Equal(hmacSHA256(key, data), sig) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidSignature, alg)
+	}
+	return nil
+}
+
+// replayCache remembers the nonces of verified messages until their timestamp leaves the skew
+// window, past which the messages are rejected as stale anyway. Nonces are never forgotten before
+// they expire, which would allow replaying their message: when full, new nonces are refused.
+type replayCache struct {
+	mu      sync.Mutex
+	maxSize int
+	seen    map[string]time.Time
+	// Nonces in insertion order, with the expiry they were recorded with. A nonce recorded again
+	// after it expired is in order twice, only its latest entry is live.
+	order []replayEntry
+}
+
+type replayEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// add records nonce until expires. It returns ErrReplayedMessage if nonce is already recorded, and
+// ErrReplayCacheFull if maxSize nonces that have not expired are.
+func (r *replayCache) add(nonce string, expires, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.order) > 0 && !r.keepLocked(r.order[0], now) {
+		r.order = r.order[1:]
+	}
+	if e, ok := r.seen[nonce]; ok && now.Before(e) {
+		return ErrReplayedMessage
+	}
+	if len(r.order) >= r.maxSize {
+		// Expiries are not in insertion order, look for expired nonces past the oldest one.
+		r.order = slices.DeleteFunc(r.order, func(e replayEntry) bool { return !r.keepLocked(e, now) })
+		if len(r.order) >= r.maxSize {
+			return ErrReplayCacheFull
+		}
+	}
+	r.seen[nonce] = expires
+	r.order = append(r.order, replayEntry{nonce, expires})
+	return nil
+}
+
+// keepLocked reports whether e is the live entry of a nonce that has not expired, it forgets the
+// nonce if it expired.
+func (r *replayCache) keepLocked(e replayEntry, now time.Time) bool {
+	// Stale entries of nonces recorded again since are dropped.
+	if !r.seen[e.nonce].Equal(e.expires) {
+		return false
+	}
+	if !now.Before(e.expires) {
+		delete(r.seen, e.nonce)
+		return false
+	}
+	return true
+}
+
+// signedData returns the data covered by the signature of msg: its type URL, its labels except
+// SignatureLabel, and its payload.
+func signedData(msg *acpb.MessageBody) []byte {
+	unsigned := withPayload(msg, nil, map[string]string{SignatureLabel: ""})
+	b := authenticatedData(unsigned)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(msg.GetBody().GetValue())))
+	return append(b, msg.GetBody().GetValue()...)
+}
+
+// signingCodec signs sent messages with signer if set, and verifies received messages with keys
+// if set.
+type signingCodec struct {
+	signer  Signer
+	keys    *TrustedKeys
+	maxSkew time.Duration
+	replay  *replayCache
+	now     func() time.Time
+}
+
+func (sc *signingCodec) encode(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	if sc.signer == nil {
+		return msg, nil
+	}
+	for _, label := range []string{SignatureLabel, SignatureKeyIDLabel, SignatureAlgorithmLabel, SignatureTimeLabel, SignatureNonceLabel} {
+		if _, ok := msg.GetLabels()[label]; ok {
+			return nil, fmt.Errorf("label %q is reserved", label)
+		}
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := withPayload(msg, msg.GetBody().GetValue(), map[string]string{
+		SignatureKeyIDLabel:     sc.signer.KeyID(),
+		SignatureAlgorithmLabel: string(sc.signer.Algorithm()),
+		SignatureTimeLabel:      strconv.FormatInt(sc.now().UnixMilli(), 10),
+		SignatureNonceLabel:     labelEncoding.EncodeToString(nonce),
+	})
+	sig, err := sc.signer.Sign(signedData(out))
+	if err != nil {
+		return nil, fmt.Errorf("signing message: %w", err)
+	}
+	out.Labels[SignatureLabel] = labelEncoding.EncodeToString(sig)
+	if err := checkLabelLimits(out.Labels); err != nil {
+		return nil, fmt.Errorf("signing message: %w", err)
+	}
+	return out, nil
+}
+
+func (sc *signingCodec) decode(msg *acpb.MessageBody) (*acpb.MessageBody, error) {
+	if sc.keys == nil {
+		return msg, nil
+	}
+	labels := msg.GetLabels()
+	encoded, ok := labels[SignatureLabel]
+	if !ok {
+		return nil, ErrUnsignedMessage
+	}
+	sig, err := labelEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if err := sc.keys.verify(SignatureAlgorithm(labels[SignatureAlgorithmLabel]), labels[SignatureKeyIDLabel], signedData(msg), sig); err != nil {
+		return nil, err
+	}
+
+	// The timestamp and nonce are covered by the signature.
+	ms, err := strconv.ParseInt(labels[SignatureTimeLabel], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestamp: %v", ErrStaleMessage, err)
+	}
+	now, signed := sc.now(), time.UnixMilli(ms)
+	if skew := now.Sub(signed).Abs(); skew > sc.maxSkew {
+		return nil, fmt.Errorf("%w: signed at %v, %v from now", ErrStaleMessage, signed, skew)
+	}
+	if err := sc.replay.add(labels[SignatureNonceLabel], signed.Add(sc.maxSkew), now); err != nil {
+		return nil, fmt.Errorf("%w: nonce %s", err, labels[SignatureNonceLabel])
+	}
+	return withPayload(msg, msg.GetBody().GetValue(), map[string]string{
+		SignatureLabel:          "",
+		SignatureKeyIDLabel:     "",
+		SignatureAlgorithmLabel: "",
+		SignatureTimeLabel:      "",
+		SignatureNonceLabel:     "",
+	}), nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+var labelValueRE = regexp.MustCompile(`^[a-z0-9_-]*$`)
+
+func TestSigningCodec(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() failed: %v", err)
+	}
+	hmacKey := bytes.Repeat([]byte{1}, 32)
+	keys := &TrustedKeys{
+		Ed25519: map[string]ed25519.PublicKey{"ed-1": pub},
+		HMAC:    map[string][]byte{"hmac-1": hmacKey},
+	}
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{TypeUrl: "example.com/T", Value: []byte("reboot")}}
+
+	for _, s := range []Signer{NewEd25519Signer("ed-1", priv), NewHMACSigner("hmac-1", hmacKey)} {
+		t.Run(string(s.Algorithm()), func(t *testing.T) {
+			now := time.Now()
+			sc := &signingCodec{
+				signer:  s,
+				keys:    keys,
+				maxSkew: time.Minute,
+				replay:  &replayCache{maxSize: 10, seen: make(map[string]time.Time)},
+				now:     func() time.Time { return now },
+			}
+			signed, err := sc.encode(msg)
+			if err != nil {
+				t.Fatalf("encode() failed: %v", err)
+			}
+			if signed.GetLabels()[SignatureKeyIDLabel] != s.KeyID() || signed.GetLabels()[SignatureLabel] == "" {
+				t.Errorf("encode() labels = %v, want a signature by %s", signed.GetLabels(), s.KeyID())
+			}
+			for k, v := range signed.GetLabels() {
+				if !labelValueRE.MatchString(v) {
+					t.Errorf("encode() label %s = %q, want only lowercase letters, digits, underscores and dashes", k, v)
+				}
+			}
+
+			tamper := map[string]func(*acpb.MessageBody){
+				"payload":     func(m *acpb.MessageBody) { m.Body.Value = []byte("rm -rf") },
+				"label":       func(m *acpb.MessageBody) { m.Labels["key"] = "other" },
+				"added label": func(m *acpb.MessageBody) { m.Labels["other"] = "value" },
+				"type URL":    func(m *acpb.MessageBody) { m.Body.TypeUrl = "example.com/Other" },
+				"timestamp":   func(m *acpb.MessageBody) { m.Labels[SignatureTimeLabel] = "1" },
+				"nonce":       func(m *acpb.MessageBody) { m.Labels[SignatureNonceLabel] = "other" },
+				"unknown key": func(m *acpb.MessageBody) { m.Labels[SignatureKeyIDLabel] = "other" },
+				"algorithm":   func(m *acpb.MessageBody) { m.Labels[SignatureAlgorithmLabel] = "none" },
+				"signature":   func(m *acpb.MessageBody) { m.Labels[SignatureLabel] = "AAAA" },
+			}
+			for name, f := range tamper {
+				m := proto.Clone(signed).(*acpb.MessageBody)
+				f(m)
+				if _, err := sc.decode(m); !errors.Is(err, ErrInvalidSignature) {
+					t.Errorf("decode() with tampered %s = %v, want %v", name, err, ErrInvalidSignature)
+				}
+			}
+
+			verified, err := sc.decode(signed)
+			if err != nil {
+				t.Fatalf("decode() failed: %v", err)
+			}
+			if diff := cmp.Diff(msg, verified, protocmp.Transform()); diff != "" {
+				t.Errorf("decode() diff (-want +got):\n%s", diff)
+			}
+			if _, err := sc.decode(signed); !errors.Is(err, ErrReplayedMessage) {
+				t.Errorf("decode() of a replayed message = %v, want %v", err, ErrReplayedMessage)
+			}
+
+			old, err := sc.encode(msg)
+			if err != nil {
+				t.Fatalf("encode() failed: %v", err)
+			}
+			now = now.Add(2 * time.Minute)
+			if _, err := sc.decode(old); !errors.Is(err, ErrStaleMessage) {
+				t.Errorf("decode() of a stale message = %v, want %v", err, ErrStaleMessage)
+			}
+		})
+	}
+
+	sc := &signingCodec{keys: keys, maxSkew: time.Minute, now: time.Now}
+	if _, err := sc.decode(msg); !errors.Is(err, ErrUnsignedMessage) {
+		t.Errorf("decode() of an unsigned message = %v, want %v", err, ErrUnsignedMessage)
+	}
+	sc = &signingCodec{signer: NewHMACSigner("hmac-1", hmacKey), now: time.Now}
+	reserved := &acpb.MessageBody{Labels: map[string]string{SignatureNonceLabel: "1"}}
+	if _, err := sc.encode(reserved); err == nil {
+		t.Error("encode() with a reserved label succeeded, want error")
+	}
+	labeled := &acpb.MessageBody{Labels: map[string]string{}}
+	for i := range 8 {
+		labeled.Labels[strconv.Itoa(i)] = "value"
+	}
+	if _, err := sc.encode(labeled); err == nil {
+		t.Error("encode() of a message with 8 labels succeeded, want error")
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	now := time.Now()
+	r := &replayCache{maxSize: 2, seen: make(map[string]time.Time)}
+	if err := r.add("a", now.Add(time.Minute), now); err != nil {
+		t.Fatalf("add() failed: %v", err)
+	}
+	if err := r.add("a", now.Add(time.Minute), now); !errors.Is(err, ErrReplayedMessage) {
+		t.Errorf("add() of a repeated nonce = %v, want %v", err, ErrReplayedMessage)
+	}
+	// Expired nonces are forgotten, their messages are stale.
+	if err := r.add("a", now.Add(3*time.Minute), now.Add(2*time.Minute)); err != nil {
+		t.Errorf("add() of an expired nonce = %v, want nil", err)
+	}
+	r.add("b", now.Add(3*time.Minute), now.Add(2*time.Minute))
+	// Live nonces are never evicted, the cache refuses new ones instead.
+	if err := r.add("c", now.Add(3*time.Minute), now.Add(2*time.Minute)); !errors.Is(err, ErrReplayCacheFull) {
+		t.Errorf("add() to a full cache = %v, want %v", err, ErrReplayCacheFull)
+	}
+	if err := r.add("a", now.Add(3*time.Minute), now.Add(2*time.Minute)); !errors.Is(err, ErrReplayedMessage) {
+		t.Errorf("add() of a nonce in a full cache = %v, want %v", err, ErrReplayedMessage)
+	}
+	if len(r.seen) != 2 || len(r.order) != 2 {
+		t.Errorf("replay cache holds %d nonces, want 2", len(r.seen))
+	}
+	// Room is made once nonces expire, whatever their insertion order.
+	r = &replayCache{maxSize: 2, seen: make(map[string]time.Time)}
+	r.add("a", now.Add(5*time.Minute), now)
+	r.add("b", now.Add(time.Minute), now)
+	if err := r.add("c", now.Add(5*time.Minute), now.Add(2*time.Minute)); err != nil {
+		t.Errorf("add() after a nonce expired = %v, want nil", err)
+	}
+	if err := r.add("a", now.Add(5*time.Minute), now.Add(2*time.Minute)); !errors.Is(err, ErrReplayedMessage) {
+		t.Errorf("add() of a live nonce = %v, want %v", err, ErrReplayedMessage)
+	}
+
+	// Evicting the expired entry of a nonce recorded again keeps its latest entry.
+	r = &replayCache{maxSize: 3, seen: make(map[string]time.Time)}
+	r.add("a", now.Add(time.Minute), now)
+	r.add("a", now.Add(3*time.Minute), now.Add(2*time.Minute))
+	r.add("b", now.Add(3*time.Minute), now.Add(2*time.Minute))
+	if err := r.add("a", now.Add(3*time.Minute), now.Add(2*time.Minute)); !errors.Is(err, ErrReplayedMessage) {
+		t.Errorf("add() of a nonce recorded again = %v, want %v", err, ErrReplayedMessage)
+	}
+}
+
+func TestSigning(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{1}, 32)
+	srv, conn, err := newTestConnection(ctx, t,
+		WithSigning(NewHMACSigner("hmac-1", key)),
+		WithVerification(&TrustedKeys{HMAC: map[string][]byte{"hmac-1": key}}, time.Minute),
+		WithEncryption(CipherAESGCM, testKeys("key-1")))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}, Body: &apb.Any{Value: []byte("command")}}
+	if err := conn.SendMessageContext(ctx, msg); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	waitForRequests(t, srv, 2)
+	srv.reqMx.Lock()
+	sent := srv.req[1].GetMessageBody()
+	srv.reqMx.Unlock()
+	if sent.GetLabels()[SignatureLabel] == "" || sent.GetLabels()[KeyIDLabel] != "key-1" {
+		t.Errorf("sent message labels = %v, want a signed and encrypted message", sent.GetLabels())
+	}
+
+	pushMessage(srv, "1", sent)
+	got, err := conn.ReceiveContext(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveContext() failed: %v", err)
+	}
+	if diff := cmp.Diff(msg, got, protocmp.Transform()); diff != "" {
+		t.Errorf("ReceiveContext() diff (-want +got):\n%s", diff)
+	}
+
+	var decodeErr *DecodeError
+	pushMessage(srv, "2", sent)
+	if _, err := conn.ReceiveContext(ctx); !errors.As(err, &decodeErr) || !errors.Is(err, ErrReplayedMessage) {
+		t.Errorf("ReceiveContext() of a replayed message = %v, want a DecodeError wrapping %v", err, ErrReplayedMessage)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+// ErrConnectionDraining is an error indicating that a send was rejected because the connection is
+// draining.
+var ErrConnectionDraining = errors.New("connection draining")
+
+// ConnectionState is the state of a Connection.
+type ConnectionState int
+
+const (
+	// StateConnecting is the initial state, the first stream is being created.
+	StateConnecting ConnectionState = iota
+	// StateReady means the stream is established and messages can be sent and received.
+	StateReady
+	// StateReconnecting means the stream was closed and a new one is being created.
+	StateReconnecting
+	// StateDraining means Drain was called, new sends are rejected while in flight sends finish.
+	StateDraining
+	// StateClosed is the final state, the connection will not reopen.
+	StateClosed
+)
+
+// String returns the name of the state.
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "Connecting"
+	case StateReady:
+		return "Ready"
+	case StateReconnecting:
+		return "Reconnecting"
+	case StateDraining:
+		return "Draining"
+	case StateClosed:
+		return "Closed"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// StateEvent describes a state transition of a Connection. An event with From equal to To is sent
+// for each failed stream creation attempt while connecting or reconnecting. While draining, the
+// state stays Draining but reconnects are still reported, with events from Draining to Draining.
+type StateEvent struct {
+	From ConnectionState
+	To   ConnectionState
+	// Reason is a short description of why the transition happened: "EOF" or the status code name
+	// (for example "Canceled", "Unavailable" or "ResourceExhausted") of Err, empty if there is no
+	// error.
+	Reason string
+	// Err is the error that caused the transition, if any.
+	Err error
+	// Generation is the number of streams that have been established on the connection, it is
+	// incremented each time a stream is established, before the connection becomes Ready.
+	Generation int
+	// Attempt is the number of failed stream creation attempts since the last Ready state.
+	Attempt int
+	Time    time.Time
+}
+
+// Size of each subscriber's channel, events are dropped for subscribers that do not keep up.
+const stateEventBuffer = 16
+
+func reasonFromError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, io.EOF) {
+		return "EOF"
+	}
+	return status.Code(err).String()
+}
+
+// setState transitions the connection to state to, notifying subscribers. Once Closed no further
+// transitions happen, and while Draining only the transition to Closed is allowed: other
+// transitions are reported without leaving Draining.
+func (c *Connection) setState(to ConnectionState, err error, attempt int) {
+	c.stateMx.Lock()
+	defer c.stateMx.Unlock()
+	c.setStateLocked(to, err, attempt)
+}
+
+func (c *Connection) setStateLocked(to ConnectionState, err error, attempt int) {
+	from := c.state
+	if from == StateClosed {
+		return
+	}
+	if to == StateReconnecting && from != StateReconnecting {
+		c.metrics.reconnect(reasonFromError(err))
+	}
+	if from == StateDraining && to != StateClosed {
+		to = StateDraining
+	}
+	c.state = to
+	ev := StateEvent{
+		From:       from,
+		To:         to,
+		Reason:     reasonFromError(err),
+		Err:        err,
+		Generation: c.generation,
+		Attempt:    attempt,
+		Time:       time.Now(),
+	}
+	for id, sub := range c.stateSubs {
+		select {
+		case sub <- ev:
+		default:
+			c.logger.Warn("Dropping state event for slow subscriber", "from", from, "to", to)
+		}
+		if to == StateClosed {
+			close(sub)
+			delete(c.stateSubs, id)
+		}
+	}
+}
+
+// reportAttempt notifies subscribers of a failed stream creation attempt without changing state.
+func (c *Connection) reportAttempt(err error, attempt int) {
+	c.stateMx.Lock()
+	defer c.stateMx.Unlock()
+	c.setStateLocked(c.state, err, attempt)
+}
+
+// nextGeneration counts a newly established stream and returns its number, which state events
+// report from the following transition to Ready.
+func (c *Connection) nextGeneration() int {
+	c.stateMx.Lock()
+	defer c.stateMx.Unlock()
+	c.generation++
+	return c.generation
+}
+
+// State returns the current state of the connection.
+func (c *Connection) State() ConnectionState {
+	c.stateMx.Lock()
+	defer c.stateMx.Unlock()
+	return c.state
+}
+
+// Done returns a channel that is closed once the connection is closed.
+func (c *Connection) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns nil while the connection is open, and the error that caused it to close once Done is
+// closed. A connection closed by the caller returns ErrConnectionClosed.
+func (c *Connection) Err() error {
+	select {
+	case <-c.closed:
+		return c.getCloseErr()
+	default:
+		return nil
+	}
+}
+
+// Subscribe returns a channel on which state transitions are delivered, and a function to stop the
+// subscription. The channel is closed after the transition to Closed or once the subscription is
+// stopped. Events are dropped if the subscriber does not keep up.
+func (c *Connection) Subscribe() (<-chan StateEvent, func()) {
+	c.stateMx.Lock()
+	defer c.stateMx.Unlock()
+	ch := make(chan StateEvent, stateEventBuffer)
+	if c.state == StateClosed {
+		close(ch)
+		return ch, func() {}
+	}
+	id := c.nextStateSub
+	c.nextStateSub++
+	c.stateSubs[id] = ch
+	return ch, func() {
+		c.stateMx.Lock()
+		defer c.stateMx.Unlock()
+		if sub, ok := c.stateSubs[id]; ok {
+			close(sub)
+			delete(c.stateSubs, id)
+		}
+	}
+}
+
+// startSend registers an in flight send, once per call to a public send method whatever the
+// number of attempts, it fails if the connection is draining or closed. The send is unregistered
+// with c.inflight.Done.
+func (c *Connection) startSend() error {
+	c.stateMx.Lock()
+	defer c.stateMx.Unlock()
+	switch c.state {
+	case StateDraining:
+		return ErrConnectionDraining
+	case StateClosed:
+		return fmt.Errorf("connection closed with err: %w", c.getCloseErr())
+	}
+	c.inflight.Add(1)
+	return nil
+}
+
+// Drain stops accepting new sends, waits for in flight sends to finish (or ctx to be done) and
+// then closes the connection. Messages can still be received while draining.
+func (c *Connection) Drain(ctx context.Context) error {
+	c.setState(StateDraining, nil, 0)
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-c.closed:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	c.Close()
+	return err
+}
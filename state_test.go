@@ -0,0 +1,198 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+func nextEvent(t *testing.T, events <-chan StateEvent) StateEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("state event channel closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for state event")
+	}
+	return StateEvent{}
+}
+
+func TestConnectionState(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	if got := conn.State(); got != StateReady {
+		t.Fatalf("State() = %v, want %v", got, StateReady)
+	}
+	if err := conn.Err(); err != nil {
+		t.Fatalf("Err() = %v, want nil", err)
+	}
+	events, stop := conn.Subscribe()
+	defer stop()
+
+	tests := []struct {
+		name       string
+		err        error
+		wantReason string
+	}{
+		{name: "EOF", err: nil, wantReason: "EOF"},
+		{name: "Unavailable", err: status.Error(codes.Unavailable, ""), wantReason: "Unavailable"},
+	}
+	for i, tc := range tests {
+		srv.recvErr <- tc.err
+		ev := nextEvent(t, events)
+		if ev.From != StateReady || ev.To != StateReconnecting || ev.Reason != tc.wantReason {
+			t.Errorf("%s: event = %+v, want Ready -> Reconnecting with reason %q", tc.name, ev, tc.wantReason)
+		}
+		ev = nextEvent(t, events)
+		if ev.From != StateReconnecting || ev.To != StateReady || ev.Generation != i+2 {
+			t.Errorf("%s: event = %+v, want Reconnecting -> Ready with generation %d", tc.name, ev, i+2)
+		}
+	}
+
+	conn.Close()
+	ev := nextEvent(t, events)
+	if ev.To != StateClosed || !errors.Is(ev.Err, ErrConnectionClosed) {
+		t.Errorf("event = %+v, want Closed with ErrConnectionClosed", ev)
+	}
+	if _, ok := <-events; ok {
+		t.Error("state event channel not closed after Closed")
+	}
+	select {
+	case <-conn.Done():
+	default:
+		t.Error("Done() not closed after Close()")
+	}
+	if err := conn.Err(); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Err() = %v, want %v", err, ErrConnectionClosed)
+	}
+	if got := conn.State(); got != StateClosed {
+		t.Errorf("State() = %v, want %v", got, StateClosed)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+	_, conn, err := newTestConnection(ctx, t)
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	events, stop := conn.Subscribe()
+	defer stop()
+
+	if err := conn.Drain(ctx); err != nil {
+		t.Fatalf("Drain() failed: %v", err)
+	}
+	if ev := nextEvent(t, events); ev.To != StateDraining {
+		t.Errorf("event = %+v, want Draining", ev)
+	}
+	if ev := nextEvent(t, events); ev.To != StateClosed {
+		t.Errorf("event = %+v, want Closed", ev)
+	}
+	err = conn.SendMessage(&acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}})
+	if !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("SendMessage() after Drain() = %v, want %v", err, ErrConnectionClosed)
+	}
+}
+
+func TestStartSend_Draining(t *testing.T) {
+	conn := newConnection(testChannelID, nil)
+	conn.setState(StateReady, nil, 0)
+	conn.setState(StateDraining, nil, 0)
+	if err := conn.startSend(); !errors.Is(err, ErrConnectionDraining) {
+		t.Errorf("startSend() = %v, want %v", err, ErrConnectionDraining)
+	}
+	// Only Closed is allowed after Draining, reconnects are still reported.
+	events, stop := conn.Subscribe()
+	defer stop()
+	conn.setState(StateReconnecting, status.Error(codes.Unavailable, ""), 0)
+	if got := conn.State(); got != StateDraining {
+		t.Errorf("State() = %v, want %v", got, StateDraining)
+	}
+	if ev := nextEvent(t, events); ev.From != StateDraining || ev.To != StateDraining || ev.Reason != "Unavailable" {
+		t.Errorf("event = %+v, want Draining -> Draining with reason Unavailable", ev)
+	}
+
+	conn.callerManagedClient = true
+	conn.close(ErrConnectionClosed)
+	if err := conn.startSend(); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("startSend() after close = %v, want %v", err, ErrConnectionClosed)
+	}
+}
+
+func TestDrain_Retry(t *testing.T) {
+	ctx := context.Background()
+	srv, conn, err := newTestConnection(ctx, t, WithSendBackoff(ConstantBackoff{Delay: 100 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("newTestConnection() failed: %v", err)
+	}
+	firstAttempt := make(chan struct{})
+	srv.reqMx.Lock()
+	srv.ackFunc = func(msg *acpb.MessageBody) *spb.Status {
+		select {
+		case <-firstAttempt:
+			return nil
+		default:
+			close(firstAttempt)
+			return &spb.Status{Code: int32(codes.ResourceExhausted)}
+		}
+	}
+	srv.reqMx.Unlock()
+
+	sendErr := make(chan error, 1)
+	go func() { sendErr <- conn.SendMessage(&acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}}) }()
+	<-firstAttempt
+	// The send is retried after its backoff, Drain waits for it.
+	if err := conn.Drain(ctx); err != nil {
+		t.Errorf("Drain() failed: %v", err)
+	}
+	if err := <-sendErr; err != nil {
+		t.Errorf("SendMessage() during Drain() = %v, want nil", err)
+	}
+}
+
+func TestReportAttempt(t *testing.T) {
+	conn := newConnection(testChannelID, nil)
+	conn.setState(StateReconnecting, nil, 0)
+	unavailable := status.Error(codes.Unavailable, "")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			conn.reportAttempt(unavailable, i)
+		}
+	}()
+	conn.setState(StateReady, nil, 0)
+	<-done
+	// An attempt reported concurrently must not undo the transition.
+	if got := conn.State(); got != StateReady {
+		t.Errorf("State() = %v, want %v", got, StateReady)
+	}
+}
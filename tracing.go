@@ -0,0 +1,174 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/base32"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+const (
+	// TraceParentLabel holds the W3C traceparent of a message without the version and dashes:
+	// 32 hex characters trace ID, 16 hex characters parent span ID and 2 hex characters flags.
+	TraceParentLabel = "acs-tp"
+	// TraceStateLabel holds the W3C tracestate of a message, base32 encoded (lowercase, extended
+	// hex alphabet, no padding) to satisfy the label character constraints.
+	TraceStateLabel = "acs-ts"
+
+	tracerName = meterName
+
+	traceParentHeader  = "traceparent"
+	traceStateHeader   = "tracestate"
+	traceParentVersion = "00"
+	// Length of the compact traceparent, trace ID + span ID + flags.
+	compactTraceParentLen = 32 + 16 + 2
+
+	// Label constraints of MessageBody.
+	maxLabels     = 12
+	maxLabelBytes = 1024
+)
+
+var (
+	tracePropagator = propagation.TraceContext{}
+	// Encodes binary label values with the characters allowed in labels.
+	labelEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+)
+
+// labelCarrier adapts MessageBody labels to a propagation.TextMapCarrier using the compact trace
+// labels.
+type labelCarrier map[string]string
+
+// Get implements propagation.TextMapCarrier.
+func (l labelCarrier) Get(key string) string {
+	switch key {
+	case traceParentHeader:
+		tp := l[TraceParentLabel]
+		if len(tp) != compactTraceParentLen {
+			return ""
+		}
+		return strings.Join([]string{traceParentVersion, tp[:32], tp[32:48], tp[48:]}, "-")
+	case traceStateHeader:
+		ts, err := labelEncoding.DecodeString(l[TraceStateLabel])
+		if err != nil {
+			return ""
+		}
+		return string(ts)
+	}
+	return ""
+}
+
+// Set implements propagation.TextMapCarrier.
+func (l labelCarrier) Set(key, value string) {
+	switch key {
+	case traceParentHeader:
+		parts := strings.Split(value, "-")
+		if len(parts) != 4 || parts[0] != traceParentVersion {
+			return
+		}
+		l[TraceParentLabel] = parts[1] + parts[2] + parts[3]
+	case traceStateHeader:
+		if value != "" {
+			l[TraceStateLabel] = labelEncoding.EncodeToString([]byte(value))
+		}
+	}
+}
+
+// Keys implements propagation.TextMapCarrier.
+func (l labelCarrier) Keys() []string {
+	return []string{traceParentHeader, traceStateHeader}
+}
+
+func labelBytes(labels map[string]string) int {
+	n := 0
+	for k, v := range labels {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+// checkLabelLimits returns an error if labels exceed the label constraints of MessageBody.
+func checkLabelLimits(labels map[string]string) error {
+	if n, size := len(labels), labelBytes(labels); n > maxLabels || size >= maxLabelBytes {
+		return fmt.Errorf("%d labels of %d bytes exceed the limit of %d labels and less than %d bytes", n, size, maxLabels, maxLabelBytes)
+	}
+	return nil
+}
+
+// injectTraceLabels returns a copy of msg with the span context of ctx added to its labels. The
+// tracestate is dropped if it does not fit in the label limits, and msg is returned as is if
+// there is no span context or even the traceparent does not fit.
+func injectTraceLabels(ctx context.Context, msg *acpb.MessageBody, logger *slog.Logger) *acpb.MessageBody {
+	carrier := labelCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	if _, ok := carrier[TraceParentLabel]; !ok {
+		return msg
+	}
+	labels := len(msg.GetLabels())
+	size := labelBytes(msg.GetLabels())
+	if ts, ok := carrier[TraceStateLabel]; ok && (labels+2 > maxLabels || size+labelBytes(carrier) >= maxLabelBytes) {
+		logger.Debug("Dropping tracestate, message labels are at their limit", "tracestate", ts)
+		delete(carrier, TraceStateLabel)
+	}
+	if labels+len(carrier) > maxLabels || size+labelBytes(carrier) >= maxLabelBytes {
+		logger.Debug("Not propagating trace context, message labels are at their limit")
+		return msg
+	}
+	msg = proto.Clone(msg).(*acpb.MessageBody)
+	if msg.Labels == nil {
+		msg.Labels = make(map[string]string, len(carrier))
+	}
+	for k, v := range carrier {
+		msg.Labels[k] = v
+	}
+	return msg
+}
+
+// extractTraceContext returns ctx with the remote span context carried in msg's labels, if any.
+func extractTraceContext(ctx context.Context, msg *acpb.MessageBody) context.Context {
+	if _, ok := msg.GetLabels()[TraceParentLabel]; !ok {
+		return ctx
+	}
+	return tracePropagator.Extract(ctx, labelCarrier(msg.GetLabels()))
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ReceiveTraced is like ReceiveContext but also returns a context derived from ctx carrying the
+// remote span context propagated in the message's labels by the sender, if any. Use it as the
+// parent of spans created while handling the message.
+func (c *Connection) ReceiveTraced(ctx context.Context) (context.Context, *acpb.MessageBody, error) {
+	msg, err := c.ReceiveContext(ctx)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return extractTraceContext(ctx, msg), msg, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+
+	apb "google.golang.org/protobuf/types/known/anypb"
+	acpb "github.com/GoogleCloudPlatform/agentcommunication_client/gapic/agentcommunicationpb"
+)
+
+// Allowed label key and value characters, see MessageBody.
+var validLabel = regexp.MustCompile(`^[a-z0-9_-]*$`)
+
+func testSpanContext(t *testing.T) trace.SpanContext {
+	t.Helper()
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := trace.ParseTraceState("congo=t61rcWkgMzE,rojo=00f067aa0ba902b7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled, TraceState: ts})
+}
+
+func TestTraceLabels(t *testing.T) {
+	sc := testSpanContext(t)
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	msg := &acpb.MessageBody{Labels: map[string]string{"key": "value"}}
+	got := injectTraceLabels(ctx, msg, discardLogger)
+	if len(msg.GetLabels()) != 1 {
+		t.Errorf("injectTraceLabels() modified the passed in message: %v", msg.GetLabels())
+	}
+	if tp := got.GetLabels()[TraceParentLabel]; tp != "4bf92f3577b34da6a3ce929d0e0e473600f067aa0ba902b701" {
+		t.Errorf("labels[%s] = %q, want compact traceparent", TraceParentLabel, tp)
+	}
+	for k, v := range got.GetLabels() {
+		if !validLabel.MatchString(k) || !validLabel.MatchString(v) {
+			t.Errorf("label %q=%q contains invalid characters", k, v)
+		}
+	}
+
+	extracted := trace.SpanContextFromContext(extractTraceContext(context.Background(), got))
+	if !extracted.IsRemote() || extracted.TraceID() != sc.TraceID() || extracted.SpanID() != sc.SpanID() || extracted.TraceState().String() != sc.TraceState().String() {
+		t.Errorf("extracted span context = %+v, want %+v", extracted, sc)
+	}
+}
+
+func TestTraceLabels_Limits(t *testing.T) {
+	ctx := trace.ContextWithSpanContext(context.Background(), testSpanContext(t))
+
+	// No span context, nothing is added.
+	msg := &acpb.MessageBody{}
+	if got := injectTraceLabels(context.Background(), msg, discardLogger); len(got.GetLabels()) != 0 {
+		t.Errorf("injectTraceLabels() without span = %v, want no labels", got.GetLabels())
+	}
+
+	// Only room for one more label, tracestate is dropped.
+	labels := make(map[string]string)
+	for i := 0; i < maxLabels-1; i++ {
+		labels[fmt.Sprintf("key%d", i)] = "value"
+	}
+	got := injectTraceLabels(ctx, &acpb.MessageBody{Labels: labels}, discardLogger)
+	if _, ok := got.GetLabels()[TraceStateLabel]; ok {
+		t.Errorf("injectTraceLabels() added %s over the label limit", TraceStateLabel)
+	}
+	if _, ok := got.GetLabels()[TraceParentLabel]; !ok {
+		t.Errorf("injectTraceLabels() did not add %s", TraceParentLabel)
+	}
+
+	// No room left at all.
+	labels = map[string]string{"key": strings.Repeat("a", maxLabelBytes-10)}
+	if got := injectTraceLabels(ctx, &acpb.MessageBody{Labels: labels}, discardLogger); len(got.GetLabels()) != 1 {
+		t.Errorf("injectTraceLabels() over the size limit = %v, want labels unchanged", got.GetLabels())
+	}
+}
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	metadataInitMx.Lock()
+	metadataInited = false
+	metadataInitMx.Unlock()
+	srv, cc, err := createTestSrv(t)
+	if err != nil {
+		t.Fatalf("createTestSrv() failed: %v", err)
+	}
+	client, err := NewClient(ctx, false, option.WithGRPCConn(cc))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	conn, err := NewConnection(ctx, testChannelID, client, WithTracerProvider(tp))
+	if err != nil {
+		t.Fatalf("NewConnection() failed: %v", err)
+	}
+	defer conn.Close()
+
+	parentCtx, parent := tp.Tracer("test").Start(ctx, "parent")
+	if err := conn.SendMessageContext(parentCtx, &acpb.MessageBody{Body: &apb.Any{Value: []byte("test-body")}}); err != nil {
+		t.Fatalf("SendMessageContext() failed: %v", err)
+	}
+	parent.End()
+	waitForRequests(t, srv, 2)
+
+	srv.reqMx.Lock()
+	sent := srv.req[1].GetMessageBody()
+	srv.reqMx.Unlock()
+	if tp := sent.GetLabels()[TraceParentLabel]; !strings.HasPrefix(tp, parent.SpanContext().TraceID().String()) {
+		t.Errorf("sent labels[%s] = %q, want trace ID %s", TraceParentLabel, tp, parent.SpanContext().TraceID())
+	}
+
+	// Echo the message back, the receiver should continue the trace.
+	srv.send <- &acpb.StreamAgentMessagesResponse{MessageId: "test-message-id", Type: &acpb.StreamAgentMessagesResponse_MessageBody{MessageBody: sent}}
+	recvCtx, _, err := conn.ReceiveTraced(ctx)
+	if err != nil {
+		t.Fatalf("ReceiveTraced() failed: %v", err)
+	}
+	if got := trace.SpanContextFromContext(recvCtx).TraceID(); got != parent.SpanContext().TraceID() {
+		t.Errorf("ReceiveTraced() trace ID = %s, want %s", got, parent.SpanContext().TraceID())
+	}
+
+	var names []string
+	for _, s := range recorder.Ended() {
+		names = append(names, s.Name())
+		if s.Name() != "parent" && s.Parent().TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("span %q has trace ID %s, want %s", s.Name(), s.Parent().TraceID(), parent.SpanContext().TraceID())
+		}
+	}
+	for _, want := range []string{"agentcommunication.SendMessage", "agentcommunication.WaitForAck"} {
+		found := false
+		for _, n := range names {
+			found = found || n == want
+		}
+		if !found {
+			t.Errorf("ended spans = %v, want %q", names, want)
+		}
+	}
+}
@@ -17,11 +17,11 @@ package client
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/GoogleCloudPlatform/agentcommunication_client/gapic"
 	"google.golang.org/api/option"
@@ -41,30 +41,31 @@ var (
 	// variable at runtime.
 	DefaultAllowVSOCK = false
 
-	// vsockAvailable is a function that checks if vsock is available on the system.
-	vsockAvailable func() bool = func() bool {
+	// vsockAvailable is a function that checks if vsock is available on the system, logging to
+	// logger.
+	vsockAvailable func(logger *slog.Logger) bool = func(logger *slog.Logger) bool {
 		allowVSOCK := DefaultAllowVSOCK
 		// Allow overriding DefaultAllowVSOCK with an environment variable at runtime.
 		if env := os.Getenv("ACS_ALLOW_VSOCK"); env != "" {
 			allowVSOCK = strings.ToLower(env) == "true"
 		}
 		if !allowVSOCK {
-			loggerPrintf("VSOCK not allowed")
+			logger.Debug("VSOCK not allowed")
 			return false
 		}
-		loggerPrintf("VSOCK allowed, checking if available")
+		logger.Debug("VSOCK allowed, checking if available")
 
 		// Check if vsock is available on the system, currently this only works on Linux, once we add
 		// support for other OSes we can update this to check.
 		conn, err := vsock.Dial(vsockContextID, vsockPort, nil)
 		if err != nil {
-			loggerPrintf("Failed to dial vsock, falling back to network: %v", err)
+			logger.Info("Failed to dial vsock, falling back to network", "error", err)
 			return false
 		}
 		if err := conn.Close(); err != nil {
-			loggerPrintf("Failed to close vsock connection: %v", err)
+			logger.Warn("Failed to close vsock connection", "error", err)
 		}
-		loggerPrintf("VSOCK available")
+		logger.Debug("VSOCK available")
 
 		return allowVSOCK
 	}
@@ -79,7 +80,7 @@ func init() {
 	if env := os.Getenv("ACS_VSOCK_PORT"); env != "" {
 		parsedPort, err := strconv.ParseUint(env, 10, 32)
 		if err != nil {
-			loggerPrintf("Failed to parse vsock port: %v", err)
+			defaultLogger().Warn("Failed to parse vsock port", "value", env, "error", err)
 		} else {
 			vsockPort = uint32(parsedPort)
 		}
@@ -116,11 +117,11 @@ func vsockDialer(_ context.Context, addr string) (net.Conn, error) {
 	return conn, nil
 }
 
-func newVSOCKClient(ctx context.Context, port uint32, opts ...option.ClientOption) (*agentcommunication.Client, error) {
+func newVSOCKClient(ctx context.Context, port uint32, kp keepalive.ClientParameters, opts ...option.ClientOption) (*agentcommunication.Client, error) {
 	vsockConn, err := grpc.NewClient(
 		vsockTarget,
 		grpc.WithContextDialer(vsockDialer),
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: 60 * time.Second, Timeout: 10 * time.Second}),
+		grpc.WithKeepaliveParams(kp),
 		// Do not use TLS for VSOCK.
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	)
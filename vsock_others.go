@@ -16,12 +16,15 @@
 
 package client
 
-import "runtime"
+import (
+	"log/slog"
+	"runtime"
+)
 
 func init() {
-	vsockAvailable = func() bool {
+	vsockAvailable = func(logger *slog.Logger) bool {
 		if DefaultAllowVSOCK {
-			loggerPrintf("VSOCK currently not supported on %s, falling back to network", runtime.GOOS)
+			logger.Info("VSOCK currently not supported, falling back to network", "os", runtime.GOOS)
 		}
 		return false
 	}